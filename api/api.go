@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"GoHole/config"
+)
+
+// Prefix of every API path
+const prefix = "/api/v1/"
+
+// Error is the body of the failed requests
+type Error struct {
+	Error string
+}
+
+var server *http.Server = nil
+var serverLock sync.Mutex
+
+// Handler returns the HTTP handler of the API, the requests must be
+// authenticated with the APIToken of the config
+func Handler() http.Handler {
+	return NewHandler(validToken)
+}
+
+// NewHandler returns the HTTP handler of the API, authorizing the
+// requests with auth (e.g. the dashboard uses its login sessions)
+func NewHandler(auth func(r *http.Request) bool) http.Handler {
+	authenticated := func(h http.HandlerFunc) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !auth(r) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "invalid or missing API token")
+				return
+			}
+			h(w, r)
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"openapi.json", serveOpenAPI)
+	mux.Handle(prefix+"blocked", authenticated(blocked))
+	mux.Handle(prefix+"blocked/", authenticated(blockedDomain))
+	mux.Handle(prefix+"allowed", authenticated(allowed))
+	mux.Handle(prefix+"allowed/", authenticated(allowedDomain))
+	mux.Handle(prefix+"blocklists", authenticated(blocklists))
+	mux.Handle(prefix+"blocklists/", authenticated(blocklist))
+	mux.Handle(prefix+"cache", authenticated(cache))
+	mux.Handle(prefix+"cache/", authenticated(cacheDomain))
+	mux.Handle(prefix+"queries", authenticated(queries))
+	mux.Handle(prefix+"queries/stream", authenticated(queryStream))
+	mux.Handle(prefix+"clients", authenticated(clients))
+	mux.Handle(prefix+"domains/top", authenticated(topDomains))
+	mux.Handle(prefix+"stats", authenticated(stats))
+	mux.Handle(prefix+"stats/history", authenticated(history))
+	mux.Handle(prefix+"config", authenticated(configDump))
+	return mux
+}
+
+// ListenAndServe serves the API on APIAddress until Close is called,
+// it does nothing if APIAddress is empty
+func ListenAndServe() {
+	addr := config.GetInstance().APIAddress
+	if addr == "" {
+		return
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("Failed to start HTTP API: %s\n", err)
+		return
+	}
+
+	serverLock.Lock()
+	server = &http.Server{Handler: Handler()}
+	s := server
+	serverLock.Unlock()
+
+	log.Printf("HTTP API at http://%s%s\n", l.Addr(), prefix)
+	err = s.Serve(l)
+	if err != nil && err != http.ErrServerClosed {
+		log.Printf("HTTP API stopped: %s\n", err)
+	}
+}
+
+// Close stops the API, the requests in progress are interrupted
+func Close() {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	if server != nil {
+		server.Close()
+		server = nil
+	}
+}
+
+// validToken reports whether the request has the API token
+func validToken(r *http.Request) bool {
+	token := config.GetInstance().APIToken
+	sent := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, Error{Error: msg})
+}
+
+// allowMethods answers 405 if the request method is not in methods
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method "+r.Method+" not allowed")
+	return false
+}
+
+// readJSON decodes the request body into v
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// pathDomain returns the domain at the end of the request path,
+// e.g. "example.com" for /api/v1/blocked/example.com
+func pathDomain(w http.ResponseWriter, r *http.Request, route string) (string, bool) {
+	domain := strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix+route+"/"), "."))
+	if !validDomain(domain) {
+		writeError(w, http.StatusBadRequest, "invalid domain "+domain)
+		return "", false
+	}
+	return domain, true
+}
+
+func validDomain(domain string) bool {
+	if domain == "" || len(domain) > 253 || strings.ContainsAny(domain, "/ \t") {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+	}
+	return true
+}
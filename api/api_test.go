@@ -0,0 +1,328 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"GoHole/blocking"
+	"GoHole/config"
+	"GoHole/dnscache"
+)
+
+const testToken = "0123456789abcdef0123"
+
+var testDir string
+
+func TestMain(m *testing.M) {
+	var err error
+	testDir, err = ioutil.TempDir("", "gohole-api")
+	if err != nil {
+		panic(err)
+	}
+	cfg := `{
+		"APIAddress": "127.0.0.1:8080",
+		"APIToken": "` + testToken + `",
+		"ControlSocket": "` + testDir + `/gohole.sock",
+		"PidFile": "` + testDir + `/gohole.pid",
+		"BlockedDomainsFile": "` + testDir + `/gohole.blocked",
+		"AllowedDomainsFile": "` + testDir + `/gohole.allowed",
+		"BlocklistsFile": "` + testDir + `/gohole.blocklists",
+		"Groups": [{"Name": "kids", "Clients": ["192.168.1.10"]}],
+		"Blocklists": [{"Name": "ads", "Domains": ["ads.example.com"]}],
+		"Allowlist": ["good.example.com"]
+	}`
+	path := filepath.Join(testDir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(cfg), 0600); err != nil {
+		panic(err)
+	}
+	config.CreateInstance(path)
+	dnscache.Configure()
+	if err := blocking.Load(); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(testDir)
+	os.Exit(code)
+}
+
+// request sends a request to the API with the token, or without it if
+// token is empty
+func request(t *testing.T, method, path, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, prefix+path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, req)
+	return w
+}
+
+func expect(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("got status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid JSON %q: %s", w.Body.String(), err)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	paths := []string{"blocked", "blocked/a.com", "allowed", "allowed/a.com", "blocklists", "blocklists/ads",
+		"cache", "cache/a.com", "queries", "queries/stream", "clients", "domains/top", "stats", "stats/history", "config"}
+	for _, path := range paths {
+		for _, token := range []string{"", "wrong-token-wrong-token"} {
+			w := request(t, "GET", path, "", token)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("GET %s with token %q: got status %d, want 401", path, token, w.Code)
+			}
+			if w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("GET %s: missing WWW-Authenticate header", path)
+			}
+		}
+	}
+
+	// the description is public
+	expect(t, request(t, "GET", "openapi.json", "", ""), http.StatusOK)
+}
+
+func TestBadRequest(t *testing.T) {
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"POST", "blocked", `{"Domain": `, http.StatusBadRequest},
+		{"POST", "blocked", `{"Domain": "bad domain"}`, http.StatusBadRequest},
+		{"POST", "blocked", `{"Domain": "a.com", "IPv4": "::1"}`, http.StatusBadRequest},
+		{"POST", "blocked", `{"Domain": "a.com", "IPv6": "127.0.0.1"}`, http.StatusBadRequest},
+		{"GET", "blocked/a..com", "", http.StatusBadRequest},
+		{"POST", "allowed", `{"Domain": ""}`, http.StatusBadRequest},
+		{"DELETE", "allowed/a..com", "", http.StatusBadRequest},
+		{"POST", "blocklists", `{"Name": ""}`, http.StatusBadRequest},
+		{"POST", "blocklists", `{"Name": "x", "Groups": ["nobody"]}`, http.StatusBadRequest},
+		{"POST", "blocklists", `{"Name": "x", "Schedule": "never"}`, http.StatusBadRequest},
+		{"POST", "blocklists", `{"Name": "x", "Domains": ["a b"]}`, http.StatusBadRequest},
+		{"POST", "blocklists", `{"Name": "x", "Sources": ["/etc/passwd"]}`, http.StatusBadRequest},
+		{"POST", "blocklists", `{"Name": "x", "Sources": ["file:///etc/passwd"]}`, http.StatusBadRequest},
+		{"POST", "blocklists", `{"Name": "x", "Sources": ["https://"]}`, http.StatusBadRequest},
+		{"GET", "cache/a..com", "", http.StatusBadRequest},
+		{"GET", "queries?limit=0", "", http.StatusBadRequest},
+		{"GET", "queries?since=yesterday", "", http.StatusBadRequest},
+		{"GET", "queries/stream?type=BOGUS", "", http.StatusBadRequest},
+		{"GET", "domains/top?limit=many", "", http.StatusBadRequest},
+		{"PUT", "blocked", "", http.StatusMethodNotAllowed},
+		{"POST", "stats", "", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		w := request(t, test.method, test.path, test.body, testToken)
+		if w.Code != test.status {
+			t.Errorf("%s %s %s: got status %d, want %d: %s", test.method, test.path, test.body, w.Code, test.status, w.Body.String())
+		}
+	}
+}
+
+func TestBlocked(t *testing.T) {
+	w := request(t, "POST", "blocked", `{"Domain": "Tracker.Example.com."}`, testToken)
+	expect(t, w, http.StatusCreated)
+	var e dnscache.Entry
+	decode(t, w, &e)
+	if e.Domain != "tracker.example.com" || e.IPv4 != blocking.BlockedIPv4 || !e.Blocked {
+		t.Errorf("got %+v", e)
+	}
+
+	var list []dnscache.Entry
+	w = request(t, "GET", "blocked", "", testToken)
+	expect(t, w, http.StatusOK)
+	decode(t, w, &list)
+	if len(list) != 1 || list[0].Domain != "tracker.example.com" {
+		t.Errorf("got %+v", list)
+	}
+	expect(t, request(t, "GET", "blocked/tracker.example.com", "", testToken), http.StatusOK)
+
+	// the blocked domains are saved
+	data, _ := ioutil.ReadFile(dnscache.BlockedFile())
+	if !strings.Contains(string(data), "tracker.example.com 127.0.0.1 ::1") {
+		t.Errorf("blocked file %q", data)
+	}
+
+	expect(t, request(t, "DELETE", "blocked/tracker.example.com", "", testToken), http.StatusNoContent)
+	expect(t, request(t, "GET", "blocked/tracker.example.com", "", testToken), http.StatusNotFound)
+	expect(t, request(t, "DELETE", "blocked/tracker.example.com", "", testToken), http.StatusNotFound)
+	data, _ = ioutil.ReadFile(dnscache.BlockedFile())
+	if strings.Contains(string(data), "tracker.example.com") {
+		t.Errorf("blocked file %q", data)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	w := request(t, "POST", "allowed", `{"Domain": "ads.example.com"}`, testToken)
+	expect(t, w, http.StatusCreated)
+	if !blocking.IsAllowed("ads.example.com") {
+		t.Errorf("ads.example.com not allowed")
+	}
+
+	var list []blocking.Allowed
+	w = request(t, "GET", "allowed", "", testToken)
+	expect(t, w, http.StatusOK)
+	decode(t, w, &list)
+	want := []blocking.Allowed{{Domain: "ads.example.com", Source: "api"}, {Domain: "good.example.com", Source: "config"}}
+	if len(list) != 2 || list[0] != want[0] || list[1] != want[1] {
+		t.Errorf("got %+v, want %+v", list, want)
+	}
+
+	// the allowed domains are saved and loaded again
+	if err := blocking.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !blocking.IsAllowed("sub.ads.example.com") {
+		t.Errorf("allowed domain lost on reload")
+	}
+
+	expect(t, request(t, "DELETE", "allowed/ads.example.com", "", testToken), http.StatusNoContent)
+	expect(t, request(t, "DELETE", "allowed/ads.example.com", "", testToken), http.StatusNotFound)
+	expect(t, request(t, "DELETE", "allowed/good.example.com", "", testToken), http.StatusNotFound)
+	if err := blocking.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if blocking.IsAllowed("ads.example.com") {
+		t.Errorf("removed domain allowed after reload")
+	}
+
+	// nothing is allowed when the domains can not be saved
+	old := config.GetInstance()
+	c := *old
+	c.AllowedDomainsFile = testDir + "/missing/gohole.allowed"
+	config.Swap(&c)
+	defer config.Swap(old)
+	expect(t, request(t, "POST", "allowed", `{"Domain": "ads.example.com"}`, testToken), http.StatusInternalServerError)
+	if blocking.IsAllowed("ads.example.com") {
+		t.Errorf("domain allowed without saving it")
+	}
+}
+
+func TestBlocklists(t *testing.T) {
+	w := request(t, "POST", "blocklists", `{"Name": "games", "Domains": ["roblox.com"], "Groups": ["kids"]}`, testToken)
+	expect(t, w, http.StatusCreated)
+	var b Blocklist
+	decode(t, w, &b)
+	if b.Name != "games" || b.Source != "api" || b.Domains != 1 || !b.Active {
+		t.Errorf("got %+v", b)
+	}
+	if !blocking.Check("192.168.1.10", "www.roblox.com", time.Now()).Blocked {
+		t.Errorf("blocklist not enforced for the group")
+	}
+	if blocking.Check("192.168.1.2", "www.roblox.com", time.Now()).Blocked {
+		t.Errorf("blocklist enforced outside the group")
+	}
+
+	expect(t, request(t, "POST", "blocklists", `{"Name": "games"}`, testToken), http.StatusConflict)
+	expect(t, request(t, "POST", "blocklists", `{"Name": "ads"}`, testToken), http.StatusConflict)
+
+	var list []Blocklist
+	w = request(t, "GET", "blocklists", "", testToken)
+	expect(t, w, http.StatusOK)
+	decode(t, w, &list)
+	if len(list) != 2 || list[0].Name != "ads" || list[0].Source != "config" || list[1].Name != "games" {
+		t.Errorf("got %+v", list)
+	}
+
+	w = request(t, "PUT", "blocklists/games", `{"Domains": ["roblox.com", "fortnite.com"]}`, testToken)
+	expect(t, w, http.StatusOK)
+	decode(t, w, &b)
+	if b.Domains != 2 || len(b.Groups) != 0 {
+		t.Errorf("got %+v", b)
+	}
+	expect(t, request(t, "PUT", "blocklists/games", `{"Name": "other"}`, testToken), http.StatusBadRequest)
+	expect(t, request(t, "PUT", "blocklists/ads", `{}`, testToken), http.StatusConflict)
+	expect(t, request(t, "DELETE", "blocklists/ads", "", testToken), http.StatusConflict)
+	expect(t, request(t, "GET", "blocklists/nope", "", testToken), http.StatusNotFound)
+
+	// the blocklists are saved and loaded again
+	if err := blocking.Load(); err != nil {
+		t.Fatal(err)
+	}
+	w = request(t, "GET", "blocklists/games", "", testToken)
+	expect(t, w, http.StatusOK)
+	decode(t, w, &b)
+	if b.Domains != 2 {
+		t.Errorf("blocklist lost on reload: %+v", b)
+	}
+
+	expect(t, request(t, "DELETE", "blocklists/games", "", testToken), http.StatusNoContent)
+	expect(t, request(t, "GET", "blocklists/games", "", testToken), http.StatusNotFound)
+	if err := blocking.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if blocking.GetInstance().Blocklist("games") != nil {
+		t.Errorf("removed blocklist back after reload")
+	}
+}
+
+func TestCache(t *testing.T) {
+	dnscache.AddDomainIPv4("cached.example.com", "192.0.2.1", true)
+	expect(t, request(t, "POST", "blocked", `{"Domain": "pinned.example.com"}`, testToken), http.StatusCreated)
+
+	var stats dnscache.Stats
+	w := request(t, "GET", "cache", "", testToken)
+	expect(t, w, http.StatusOK)
+	decode(t, w, &stats)
+	if stats.Entries == 0 {
+		t.Errorf("got %+v", stats)
+	}
+
+	var e dnscache.Entry
+	w = request(t, "GET", "cache/cached.example.com", "", testToken)
+	expect(t, w, http.StatusOK)
+	decode(t, w, &e)
+	if e.IPv4 != "192.0.2.1" || e.Blocked {
+		t.Errorf("got %+v", e)
+	}
+	expect(t, request(t, "GET", "cache/missing.example.com", "", testToken), http.StatusNotFound)
+
+	// flushing keeps the blocked domains
+	var removed map[string]int
+	w = request(t, "DELETE", "cache", "", testToken)
+	expect(t, w, http.StatusOK)
+	decode(t, w, &removed)
+	if removed["Removed"] == 0 {
+		t.Errorf("got %+v", removed)
+	}
+	expect(t, request(t, "GET", "cache/cached.example.com", "", testToken), http.StatusNotFound)
+	expect(t, request(t, "GET", "cache/pinned.example.com", "", testToken), http.StatusOK)
+
+	expect(t, request(t, "DELETE", "cache/pinned.example.com", "", testToken), http.StatusNoContent)
+	expect(t, request(t, "GET", "blocked/pinned.example.com", "", testToken), http.StatusNotFound)
+}
+
+func TestStatsAndConfig(t *testing.T) {
+	var s Stats
+	w := request(t, "GET", "stats", "", testToken)
+	expect(t, w, http.StatusOK)
+	decode(t, w, &s)
+
+	w = request(t, "GET", "stats/history", "", testToken)
+	expect(t, w, http.StatusOK)
+	var history []interface{}
+	decode(t, w, &history)
+	if len(history) == 0 {
+		t.Errorf("empty history")
+	}
+
+	w = request(t, "GET", "config", "", testToken)
+	expect(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), `"APIAddress"`) {
+		t.Errorf("config without APIAddress: %s", w.Body.String())
+	}
+}
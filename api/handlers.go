@@ -0,0 +1,459 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoHole/blocking"
+	"GoHole/config"
+	"GoHole/dnscache"
+	"GoHole/logs"
+)
+
+// BlockedDomain is the body to block a domain, the IPs the domain is
+// answered with default to 127.0.0.1 and ::1
+type BlockedDomain struct {
+	Domain string
+	IPv4   string
+	IPv6   string
+}
+
+// AllowedDomain is the body to allow a domain
+type AllowedDomain struct {
+	Domain string
+}
+
+// Blocklist is a blocklist as loaded by the server
+type Blocklist struct {
+	Name     string
+	Groups   []string
+	Schedule string
+	Active   bool   // whether its schedule is active now
+	Source   string // "config" or "api"
+	Domains  int    // number of domains
+}
+
+// Stats are the query counters since the server started and the cache
+// statistics
+type Stats struct {
+	Queries logs.Statistics
+	Cache   dnscache.Stats
+}
+
+// GET lists the blocked domains, POST blocks a domain
+func blocked(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "POST") {
+		return
+	}
+	if r.Method == "GET" {
+		writeJSON(w, http.StatusOK, dnscache.GetBlockedDomains())
+		return
+	}
+
+	var b BlockedDomain
+	if !readJSON(w, r, &b) {
+		return
+	}
+	b.Domain = strings.ToLower(strings.TrimSuffix(b.Domain, "."))
+	if !validDomain(b.Domain) {
+		writeError(w, http.StatusBadRequest, "invalid domain "+b.Domain)
+		return
+	}
+	if b.IPv4 == "" {
+		b.IPv4 = blocking.BlockedIPv4
+	}
+	if b.IPv6 == "" {
+		b.IPv6 = blocking.BlockedIPv6
+	}
+	if ip := net.ParseIP(b.IPv4); ip == nil || ip.To4() == nil {
+		writeError(w, http.StatusBadRequest, "invalid IPv4 "+b.IPv4)
+		return
+	}
+	if ip := net.ParseIP(b.IPv6); ip == nil || ip.To4() != nil {
+		writeError(w, http.StatusBadRequest, "invalid IPv6 "+b.IPv6)
+		return
+	}
+
+	dnscache.AddDomainIPv4(b.Domain, b.IPv4, false)
+	dnscache.AddDomainIPv6(b.Domain, b.IPv6, false)
+	saveBlocked()
+	e, _ := dnscache.GetEntry(b.Domain)
+	writeJSON(w, http.StatusCreated, e)
+}
+
+// GET shows a blocked domain, DELETE unblocks it
+func blockedDomain(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "DELETE") {
+		return
+	}
+	domain, ok := pathDomain(w, r, "blocked")
+	if !ok {
+		return
+	}
+	e, found := dnscache.GetEntry(domain)
+	if !found || !e.Blocked {
+		writeError(w, http.StatusNotFound, "domain "+domain+" is not blocked")
+		return
+	}
+	if r.Method == "GET" {
+		writeJSON(w, http.StatusOK, e)
+		return
+	}
+	dnscache.DeleteDomainIPv4(domain)
+	dnscache.DeleteDomainIPv6(domain)
+	saveBlocked()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET lists the allowed domains, POST allows a domain
+func allowed(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "POST") {
+		return
+	}
+	if r.Method == "GET" {
+		writeJSON(w, http.StatusOK, blocking.GetAllowed())
+		return
+	}
+
+	var a AllowedDomain
+	if !readJSON(w, r, &a) {
+		return
+	}
+	if !validDomain(strings.ToLower(strings.TrimSuffix(a.Domain, "."))) {
+		writeError(w, http.StatusBadRequest, "invalid domain "+a.Domain)
+		return
+	}
+	allowed, err := blocking.Allow(a.Domain)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, allowed)
+}
+
+// DELETE removes an allowed domain
+func allowedDomain(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "DELETE") {
+		return
+	}
+	domain, ok := pathDomain(w, r, "allowed")
+	if !ok {
+		return
+	}
+	found, err := blocking.Disallow(domain)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "domain "+domain+" is not allowed through the API (domains in the config Allowlist can not be removed)")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET lists the blocklists, POST adds a blocklist
+func blocklists(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "POST") {
+		return
+	}
+	if r.Method == "GET" {
+		list := []Blocklist{}
+		for _, b := range blocking.GetInstance().Blocklists {
+			list = append(list, newBlocklist(b))
+		}
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+
+	var bc config.BlocklistConfig
+	if !readJSON(w, r, &bc) {
+		return
+	}
+	if blocking.GetInstance().Blocklist(bc.Name) != nil {
+		writeError(w, http.StatusConflict, "blocklist "+bc.Name+" already exists")
+		return
+	}
+	addBlocklist(w, bc)
+}
+
+// GET shows a blocklist, PUT replaces a blocklist added through the API,
+// DELETE removes it
+func blocklist(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "PUT", "DELETE") {
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, prefix+"blocklists/")
+	b := blocking.GetInstance().Blocklist(name)
+	if b == nil {
+		writeError(w, http.StatusNotFound, "blocklist "+name+" does not exist")
+		return
+	}
+	if b.Source != "api" && r.Method != "GET" {
+		writeError(w, http.StatusConflict, blocking.ErrConfigBlocklist.Error())
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, newBlocklist(b))
+	case "PUT":
+		var bc config.BlocklistConfig
+		if !readJSON(w, r, &bc) {
+			return
+		}
+		if bc.Name != "" && bc.Name != name {
+			writeError(w, http.StatusBadRequest, "the Name of the body must be the blocklist of the path, "+name)
+			return
+		}
+		bc.Name = name
+		addBlocklist(w, bc)
+	case "DELETE":
+		_, err := blocking.RemoveBlocklist(name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// addBlocklist adds or replaces a blocklist, answering the request
+func addBlocklist(w http.ResponseWriter, bc config.BlocklistConfig) {
+	if bc.Name == "" || strings.Contains(bc.Name, "/") {
+		writeError(w, http.StatusBadRequest, "invalid blocklist name "+bc.Name)
+		return
+	}
+	for _, d := range bc.Domains {
+		if !validDomain(strings.ToLower(strings.TrimSuffix(d, "."))) {
+			writeError(w, http.StatusBadRequest, "invalid domain "+d)
+			return
+		}
+	}
+	b, created, err := blocking.AddBlocklist(bc)
+	if err == blocking.ErrConfigBlocklist {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		// unknown group or schedule, or a source that is not a URL
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, newBlocklist(b))
+}
+
+func newBlocklist(b *blocking.Blocklist) Blocklist {
+	bl := Blocklist{Name: b.Name, Groups: b.Groups, Active: true, Source: b.Source, Domains: b.Size()}
+	if bl.Groups == nil {
+		bl.Groups = []string{}
+	}
+	if b.Schedule != nil {
+		bl.Schedule = b.Schedule.Name
+		bl.Active = b.Schedule.IsActive(time.Now())
+	}
+	return bl
+}
+
+// GET shows the cache statistics, DELETE removes the resolved domains
+// (the blocked domains are kept)
+func cache(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "DELETE") {
+		return
+	}
+	if r.Method == "GET" {
+		writeJSON(w, http.StatusOK, dnscache.GetStats())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"Removed": dnscache.FlushResolved()})
+}
+
+// GET shows the cached IPs of a domain, DELETE removes them
+func cacheDomain(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "DELETE") {
+		return
+	}
+	domain, ok := pathDomain(w, r, "cache")
+	if !ok {
+		return
+	}
+	e, found := dnscache.GetEntry(domain)
+	if !found {
+		writeError(w, http.StatusNotFound, "domain "+domain+" is not in cache")
+		return
+	}
+	if r.Method == "GET" {
+		writeJSON(w, http.StatusOK, e)
+		return
+	}
+	dnscache.DeleteDomainIPv4(domain)
+	dnscache.DeleteDomainIPv6(domain)
+	if e.Blocked {
+		saveBlocked()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET searches the query log: ?client=IP&domain=text&since=RFC3339&until=RFC3339&limit=N
+func queries(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET") {
+		return
+	}
+	params := r.URL.Query()
+	filter := logs.QueryFilter{
+		ClientIp: params.Get("client"),
+		Domain:   strings.ToLower(params.Get("domain")),
+	}
+	var ok bool
+	if filter.Limit, ok = queryLimit(w, r); !ok {
+		return
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if params.Get(name) == "" {
+			continue
+		}
+		var err error
+		*t, err = time.Parse(time.RFC3339, params.Get(name))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, name+" must be a RFC 3339 time, e.g. 2026-10-19T21:00:00+02:00")
+			return
+		}
+	}
+
+	list, err := logs.SearchQueries(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// GET streams the queries as they are answered, as server-sent events:
+// ?client=IP&domain=pattern&blocked=true&type=AAAA
+func queryStream(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET") {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	params := r.URL.Query()
+	filter := logs.TailFilter{
+		ClientIp: params.Get("client"),
+		Domain:   params.Get("domain"),
+		Blocked:  params.Get("blocked") == "true",
+		Type:     params.Get("type"),
+	}
+	events, cancel, err := logs.Subscribe(filter)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case e := <-events:
+			data, _ := json.Marshal(e)
+			_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// GET lists the clients and their number of queries
+func clients(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET") {
+		return
+	}
+	list, err := logs.GetClients()
+	if err != nil {
+		list = []logs.ClientLog{} // the log is empty
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// GET lists the most queried domains: ?limit=N
+func topDomains(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET") {
+		return
+	}
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+	list, err := logs.GetTopDomains(limit)
+	if err != nil {
+		list = []logs.DomainLog{} // the log is empty
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// GET shows the query counters and the cache statistics
+func stats(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET") {
+		return
+	}
+	writeJSON(w, http.StatusOK, Stats{Queries: logs.GetStats(), Cache: dnscache.GetStats()})
+}
+
+// GET shows the queries of the last 24 hours in slots of 10 minutes
+func history(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET") {
+		return
+	}
+	writeJSON(w, http.StatusOK, logs.GetHistory())
+}
+
+// GET shows the effective config and where each value came from
+func configDump(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET") {
+		return
+	}
+	writeJSON(w, http.StatusOK, config.GetInstance().Dump())
+}
+
+// queryLimit returns the ?limit= parameter, 100 by default
+func queryLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a positive number")
+			return 0, false
+		}
+	}
+	return limit, true
+}
+
+// saveBlocked keeps the blocked domains changed through the API across
+// restarts
+func saveBlocked() {
+	_, err := dnscache.SaveBlocked()
+	if err != nil {
+		log.Printf("Failed to save blocked domains to %s: %s\n", dnscache.BlockedFile(), err)
+	}
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"net/http"
+)
+
+// serveOpenAPI serves the description of the API, it does not need
+// the token so API clients can be generated from it
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPI))
+}
+
+// OpenAPI 3.0 description of the API
+const openAPI = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "GoHole API",
+    "description": "Administration API of a running GoHole server. Every request except this description needs the header \"Authorization: Bearer <APIToken>\".",
+    "version": "1"
+  },
+  "servers": [{"url": "/api/v1"}],
+  "security": [{"token": []}],
+  "paths": {
+    "/blocked": {
+      "get": {
+        "summary": "List the blocked domains",
+        "responses": {"200": {"description": "Blocked domains", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Entry"}}}}}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      },
+      "post": {
+        "summary": "Block a domain",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BlockedDomain"}}}},
+        "responses": {"201": {"description": "Domain blocked", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Entry"}}}}, "400": {"$ref": "#/components/responses/BadRequest"}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      }
+    },
+    "/blocked/{domain}": {
+      "parameters": [{"$ref": "#/components/parameters/Domain"}],
+      "get": {
+        "summary": "Show a blocked domain",
+        "responses": {"200": {"description": "Blocked domain", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Entry"}}}}, "401": {"$ref": "#/components/responses/Unauthorized"}, "404": {"$ref": "#/components/responses/NotFound"}}
+      },
+      "delete": {
+        "summary": "Unblock a domain",
+        "responses": {"204": {"description": "Domain unblocked"}, "401": {"$ref": "#/components/responses/Unauthorized"}, "404": {"$ref": "#/components/responses/NotFound"}}
+      }
+    },
+    "/allowed": {
+      "get": {
+        "summary": "List the allowed domains, they are never blocked",
+        "responses": {"200": {"description": "Allowed domains", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Allowed"}}}}}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      },
+      "post": {
+        "summary": "Allow a domain and its subdomains",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AllowedDomain"}}}},
+        "responses": {"201": {"description": "Domain allowed", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Allowed"}}}}, "400": {"$ref": "#/components/responses/BadRequest"}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      }
+    },
+    "/allowed/{domain}": {
+      "parameters": [{"$ref": "#/components/parameters/Domain"}],
+      "delete": {
+        "summary": "Remove a domain allowed through the API",
+        "responses": {"204": {"description": "Domain no longer allowed"}, "401": {"$ref": "#/components/responses/Unauthorized"}, "404": {"$ref": "#/components/responses/NotFound"}}
+      }
+    },
+    "/blocklists": {
+      "get": {
+        "summary": "List the blocklists",
+        "responses": {"200": {"description": "Blocklists", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Blocklist"}}}}}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      },
+      "post": {
+        "summary": "Add a blocklist, saved to BlocklistsFile",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BlocklistConfig"}}}},
+        "responses": {"201": {"description": "Blocklist added", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Blocklist"}}}}, "400": {"$ref": "#/components/responses/BadRequest"}, "401": {"$ref": "#/components/responses/Unauthorized"}, "409": {"$ref": "#/components/responses/Conflict"}}
+      }
+    },
+    "/blocklists/{name}": {
+      "parameters": [{"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "Show a blocklist",
+        "responses": {"200": {"description": "Blocklist", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Blocklist"}}}}, "401": {"$ref": "#/components/responses/Unauthorized"}, "404": {"$ref": "#/components/responses/NotFound"}}
+      },
+      "put": {
+        "summary": "Replace a blocklist added through the API",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BlocklistConfig"}}}},
+        "responses": {"200": {"description": "Blocklist replaced", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Blocklist"}}}}, "400": {"$ref": "#/components/responses/BadRequest"}, "401": {"$ref": "#/components/responses/Unauthorized"}, "404": {"$ref": "#/components/responses/NotFound"}, "409": {"$ref": "#/components/responses/Conflict"}}
+      },
+      "delete": {
+        "summary": "Remove a blocklist added through the API",
+        "responses": {"204": {"description": "Blocklist removed"}, "401": {"$ref": "#/components/responses/Unauthorized"}, "404": {"$ref": "#/components/responses/NotFound"}, "409": {"$ref": "#/components/responses/Conflict"}}
+      }
+    },
+    "/cache": {
+      "get": {
+        "summary": "Show the cache statistics",
+        "responses": {"200": {"description": "Cache statistics", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CacheStats"}}}}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      },
+      "delete": {
+        "summary": "Flush the resolved domains, the blocked domains are kept",
+        "responses": {"200": {"description": "Number of domains removed", "content": {"application/json": {"schema": {"type": "object", "properties": {"Removed": {"type": "integer"}}}}}}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      }
+    },
+    "/cache/{domain}": {
+      "parameters": [{"$ref": "#/components/parameters/Domain"}],
+      "get": {
+        "summary": "Show the cached IPs of a domain",
+        "responses": {"200": {"description": "Cached domain", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Entry"}}}}, "401": {"$ref": "#/components/responses/Unauthorized"}, "404": {"$ref": "#/components/responses/NotFound"}}
+      },
+      "delete": {
+        "summary": "Remove a domain from the cache",
+        "responses": {"204": {"description": "Domain removed"}, "401": {"$ref": "#/components/responses/Unauthorized"}, "404": {"$ref": "#/components/responses/NotFound"}}
+      }
+    },
+    "/queries": {
+      "get": {
+        "summary": "Search the query log, latest queries first",
+        "parameters": [
+          {"name": "client", "in": "query", "description": "Client IP", "schema": {"type": "string"}},
+          {"name": "domain", "in": "query", "description": "Part of the domain", "schema": {"type": "string"}},
+          {"name": "since", "in": "query", "description": "RFC 3339 time", "schema": {"type": "string", "format": "date-time"}},
+          {"name": "until", "in": "query", "description": "RFC 3339 time", "schema": {"type": "string", "format": "date-time"}},
+          {"$ref": "#/components/parameters/Limit"}
+        ],
+        "responses": {"200": {"description": "Queries", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Query"}}}}}, "400": {"$ref": "#/components/responses/BadRequest"}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      }
+    },
+    "/queries/stream": {
+      "get": {
+        "summary": "Stream the queries as they are answered, as server-sent events with a Query event in JSON per message",
+        "parameters": [
+          {"name": "client", "in": "query", "description": "Client IP", "schema": {"type": "string"}},
+          {"name": "domain", "in": "query", "description": "Part of the domain, or a pattern where * matches anything, e.g. *.google.com", "schema": {"type": "string"}},
+          {"name": "blocked", "in": "query", "description": "Only the blocked queries", "schema": {"type": "boolean"}},
+          {"name": "type", "in": "query", "description": "Record type, e.g. AAAA", "schema": {"type": "string"}}
+        ],
+        "responses": {"200": {"description": "Stream of QueryEvent", "content": {"text/event-stream": {"schema": {"$ref": "#/components/schemas/QueryEvent"}}}}, "400": {"$ref": "#/components/responses/BadRequest"}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      }
+    },
+    "/clients": {
+      "get": {
+        "summary": "List the clients, most active first",
+        "responses": {"200": {"description": "Clients", "content": {"application/json": {"schema": {"type": "array", "items": {"type": "object", "properties": {"ClientIp": {"type": "string"}, "Queries": {"type": "integer"}}}}}}}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      }
+    },
+    "/domains/top": {
+      "get": {
+        "summary": "List the most queried domains",
+        "parameters": [{"$ref": "#/components/parameters/Limit"}],
+        "responses": {"200": {"description": "Domains", "content": {"application/json": {"schema": {"type": "array", "items": {"type": "object", "properties": {"Domain": {"type": "string"}, "Queries": {"type": "integer"}}}}}}}, "400": {"$ref": "#/components/responses/BadRequest"}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      }
+    },
+    "/stats": {
+      "get": {
+        "summary": "Show the query counters since the server started and the cache statistics",
+        "responses": {"200": {"description": "Statistics", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Stats"}}}}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      }
+    },
+    "/stats/history": {
+      "get": {
+        "summary": "Show the queries of the last 24 hours in slots of 10 minutes, oldest first",
+        "responses": {"200": {"description": "History", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/HistoryPoint"}}}}}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      }
+    },
+    "/config": {
+      "get": {
+        "summary": "Show the effective config and where each value came from",
+        "responses": {"200": {"description": "Config fields", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/ConfigField"}}}}}, "401": {"$ref": "#/components/responses/Unauthorized"}}
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "token": {"type": "http", "scheme": "bearer", "description": "APIToken of the config"}
+    },
+    "parameters": {
+      "Domain": {"name": "domain", "in": "path", "required": true, "schema": {"type": "string"}, "example": "ads.example.com"},
+      "Limit": {"name": "limit", "in": "query", "description": "Max number of results (default 100)", "schema": {"type": "integer", "minimum": 1}}
+    },
+    "responses": {
+      "BadRequest": {"description": "Invalid request", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Unauthorized": {"description": "Invalid or missing API token", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "NotFound": {"description": "Domain or blocklist not found", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "Conflict": {"description": "The blocklist already exists, or it is in the config file", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    },
+    "schemas": {
+      "Error": {"type": "object", "properties": {"Error": {"type": "string"}}},
+      "BlockedDomain": {"type": "object", "required": ["Domain"], "properties": {"Domain": {"type": "string"}, "IPv4": {"type": "string", "default": "127.0.0.1"}, "IPv6": {"type": "string", "default": "::1"}}},
+      "AllowedDomain": {"type": "object", "required": ["Domain"], "properties": {"Domain": {"type": "string"}}},
+      "Allowed": {"type": "object", "properties": {"Domain": {"type": "string"}, "Source": {"type": "string", "enum": ["config", "api"]}}},
+      "Entry": {"type": "object", "properties": {"Domain": {"type": "string"}, "IPv4": {"type": "string"}, "IPv6": {"type": "string"}, "Blocked": {"type": "boolean"}, "Expires": {"type": "string", "format": "date-time", "description": "Zero for blocked domains"}}},
+      "Blocklist": {"type": "object", "properties": {"Name": {"type": "string"}, "Groups": {"type": "array", "items": {"type": "string"}}, "Schedule": {"type": "string"}, "Active": {"type": "boolean"}, "Source": {"type": "string", "enum": ["config", "api"]}, "Domains": {"type": "integer"}}},
+      "BlocklistConfig": {"type": "object", "required": ["Name"], "properties": {"Name": {"type": "string"}, "Sources": {"type": "array", "items": {"type": "string"}, "description": "http or https URLs"}, "Domains": {"type": "array", "items": {"type": "string"}}, "Groups": {"type": "array", "items": {"type": "string"}}, "Schedule": {"type": "string"}}},
+      "Query": {"type": "object", "properties": {"Id": {"type": "integer"}, "ClientIp": {"type": "string"}, "Domain": {"type": "string"}, "Cached": {"type": "boolean"}, "Rewritten": {"type": "boolean"}, "Blocked": {"type": "boolean"}, "Timestamp": {"type": "string", "format": "date-time"}}},
+      "QueryEvent": {"type": "object", "properties": {"Time": {"type": "string", "format": "date-time"}, "ClientIp": {"type": "string"}, "Domain": {"type": "string"}, "Type": {"type": "string"}, "Status": {"type": "string", "enum": ["local", "blocked", "rewritten", "cached", "forwarded", "stale", "failed"]}, "List": {"type": "string"}}},
+      "CacheStats": {"type": "object", "properties": {"Entries": {"type": "integer"}, "Bytes": {"type": "integer"}, "MaxEntries": {"type": "integer"}, "MaxBytes": {"type": "integer"}, "Pinned": {"type": "integer"}, "PinnedBytes": {"type": "integer"}, "Hits": {"type": "integer"}, "Misses": {"type": "integer"}, "Evictions": {"type": "integer"}, "Rejected": {"type": "integer"}, "HitRatio": {"type": "number"}, "Policy": {"type": "string"}}},
+      "QueryStats": {"type": "object", "properties": {"Total": {"type": "integer"}, "Blocked": {"type": "integer"}, "NonBlocked": {"type": "integer"}, "Cached": {"type": "integer"}, "NonCached": {"type": "integer"}, "Ipv4": {"type": "integer"}, "Ipv6": {"type": "integer"}, "Rewritten": {"type": "integer"}, "Prefetched": {"type": "integer"}, "Coalesced": {"type": "integer"}}},
+      "HistoryPoint": {"type": "object", "properties": {"Time": {"type": "string", "format": "date-time"}, "Total": {"type": "integer"}, "Blocked": {"type": "integer"}}},
+      "Stats": {"type": "object", "properties": {"Queries": {"$ref": "#/components/schemas/QueryStats"}, "Cache": {"$ref": "#/components/schemas/CacheStats"}}},
+      "ConfigField": {"type": "object", "properties": {"Name": {"type": "string"}, "Value": {"type": "string"}, "Source": {"type": "string"}}}
+    }
+  }
+}
+`
@@ -0,0 +1,102 @@
+package blocking
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"GoHole/config"
+)
+
+// Allowed is a domain that is never blocked, neither by the blocklists
+// nor by the blocked domains in cache
+type Allowed struct {
+	Domain string
+	Source string // "config" (Allowlist) or "api", added on the running server
+}
+
+// domains allowed on the running server, saved to AllowedFile
+var allowed = map[string]bool{}
+var allowedLock sync.RWMutex
+
+func cleanDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// Allow stops blocking domain and its subdomains, nothing changes if
+// AllowedFile can not be saved
+func Allow(domain string) (Allowed, error) {
+	allowedLock.Lock()
+	defer allowedLock.Unlock()
+
+	d := cleanDomain(domain)
+	was := allowed[d]
+	allowed[d] = true
+	if err := saveAllowed(); err != nil {
+		if !was {
+			delete(allowed, d)
+		}
+		return Allowed{}, fmt.Errorf("failed to save allowed domains to %s: %s", AllowedFile(), err)
+	}
+	log.Printf("Domain %s allowed", d)
+	return Allowed{Domain: d, Source: "api"}, nil
+}
+
+// Disallow removes a domain allowed on the running server, it returns
+// false if it was not allowed (or it is in the config Allowlist)
+func Disallow(domain string) (bool, error) {
+	allowedLock.Lock()
+	defer allowedLock.Unlock()
+
+	d := cleanDomain(domain)
+	if !allowed[d] {
+		return false, nil
+	}
+	delete(allowed, d)
+	if err := saveAllowed(); err != nil {
+		allowed[d] = true
+		return false, fmt.Errorf("failed to save allowed domains to %s: %s", AllowedFile(), err)
+	}
+	log.Printf("Domain %s no longer allowed", d)
+	return true, nil
+}
+
+// GetAllowed returns the allowed domains, sorted by name
+func GetAllowed() []Allowed {
+	allowedLock.RLock()
+	defer allowedLock.RUnlock()
+
+	list := []Allowed{}
+	for _, d := range config.GetInstance().Allowlist {
+		list = append(list, Allowed{Domain: cleanDomain(d), Source: "config"})
+	}
+	for d := range allowed {
+		list = append(list, Allowed{Domain: d, Source: "api"})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Domain < list[j].Domain })
+	return list
+}
+
+// IsAllowed reports whether the domain, or one of its parent domains,
+// is allowed
+func IsAllowed(domain string) bool {
+	allowedLock.RLock()
+	defer allowedLock.RUnlock()
+
+	if len(allowed) == 0 && len(GetInstance().allowed) == 0 {
+		return false
+	}
+	domain = cleanDomain(domain)
+	for {
+		if allowed[domain] || GetInstance().allowed[domain] {
+			return true
+		}
+		i := strings.Index(domain, ".")
+		if i < 0 {
+			return false
+		}
+		domain = domain[i+1:]
+	}
+}
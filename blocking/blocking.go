@@ -0,0 +1,409 @@
+package blocking
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"GoHole/config"
+	"GoHole/parser"
+)
+
+// IPs used to answer queries for domains blocked by a blocklist
+const (
+	BlockedIPv4 = "127.0.0.1"
+	BlockedIPv6 = "::1"
+)
+
+// Group is a named set of clients
+type Group struct {
+	Name     string
+	Schedule *Schedule
+	nets     []*net.IPNet
+}
+
+// Blocklist is a set of domains blocked for some groups, optionally
+// only while its schedule is active
+type Blocklist struct {
+	Name     string
+	Groups   []string
+	Schedule *Schedule
+	Source   string // "config" (Blocklists) or "api", added on the running server
+	domains  map[string]bool
+}
+
+// Rules holds the groups, schedules and blocklists loaded from the config
+type Rules struct {
+	Groups     []*Group
+	Schedules  map[string]*Schedule
+	Blocklists []*Blocklist
+	allowed    map[string]bool          // Allowlist of the config
+	added      []config.BlocklistConfig // blocklists added through the API
+	gen        int                      // listsGen when the added blocklists were read
+}
+
+// Decision is the result of checking a query against the blocklists
+type Decision struct {
+	Blocked  bool
+	List     string // blocklist that blocked the domain
+	Group    string // client group the list was enforced for (empty if it applies to everybody)
+	Schedule string // schedule that was active when blocking
+}
+
+// Trace explains how a single blocklist was evaluated for a query
+type Trace struct {
+	List           string
+	Matched        string // domain (or parent domain) found in the list
+	Group          string
+	Schedule       string
+	ScheduleActive bool
+	Blocked        bool
+}
+
+var instance *Rules = &Rules{Schedules: map[string]*Schedule{}}
+var instanceLock sync.RWMutex // guards the swap of instance on reload
+
+func GetInstance() *Rules {
+	instanceLock.RLock()
+	defer instanceLock.RUnlock()
+	return instance
+}
+
+func setInstance(r *Rules) {
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
+	instance = r
+}
+
+// Load builds the rules of the current config and enforces them
+func Load() error {
+	rules, err := Build(config.GetInstance())
+	if err != nil {
+		return err
+	}
+	Swap(rules)
+	return nil
+}
+
+// Build builds the rules of a config, downloading and parsing every
+// blocklist source, with the blocklists added through the API. They are
+// enforced once swapped in with Swap.
+func Build(cfg *config.MyConfig) (*Rules, error) {
+	rules := &Rules{Schedules: map[string]*Schedule{}, allowed: map[string]bool{}}
+
+	for _, d := range cfg.Allowlist {
+		rules.allowed[cleanDomain(d)] = true
+	}
+
+	for _, sc := range cfg.Schedules {
+		s, err := newSchedule(sc)
+		if err != nil {
+			return nil, err
+		}
+		rules.Schedules[s.Name] = s
+	}
+
+	for _, gc := range cfg.Groups {
+		g := &Group{Name: gc.Name}
+		if gc.Schedule != "" {
+			g.Schedule = rules.Schedules[gc.Schedule]
+			if g.Schedule == nil {
+				return nil, fmt.Errorf("group %s: unknown schedule %s", gc.Name, gc.Schedule)
+			}
+		}
+		for _, c := range gc.Clients {
+			if !strings.Contains(c, "/") {
+				if strings.Contains(c, ":") {
+					c += "/128"
+				} else {
+					c += "/32"
+				}
+			}
+			_, n, err := net.ParseCIDR(c)
+			if err != nil {
+				return nil, fmt.Errorf("group %s: %s", gc.Name, err)
+			}
+			g.nets = append(g.nets, n)
+		}
+		rules.Groups = append(rules.Groups, g)
+	}
+
+	for _, bc := range cfg.Blocklists {
+		b, err := rules.newBlocklist(bc, "config")
+		if err != nil {
+			return nil, err
+		}
+		rules.Blocklists = append(rules.Blocklists, b)
+	}
+
+	// the blocklists added through the API
+	listsLock.Lock()
+	rules.gen = listsGen
+	listsLock.Unlock()
+	path := blocklistsFile(cfg)
+	added, err := loadBlocklists(path)
+	if err != nil {
+		log.Printf("Error loading blocklists from %s: %s", path, err)
+	}
+	for _, bc := range added {
+		if rules.Blocklist(bc.Name) != nil {
+			log.Printf("Blocklist %s of %s ignored, the config has a blocklist with the same name", bc.Name, path)
+			continue
+		}
+		b, err := rules.newBlocklist(bc, "api")
+		if err != nil {
+			log.Printf("Blocklist %s of %s ignored: %s", bc.Name, path, err)
+			continue
+		}
+		rules.Blocklists = append(rules.Blocklists, b)
+		rules.added = append(rules.added, bc)
+	}
+	return rules, nil
+}
+
+// Swap enforces the rules made by Build, with the domains allowed
+// through the API saved in AllowedFile
+func Swap(rules *Rules) {
+	listsLock.Lock()
+	defer listsLock.Unlock()
+
+	if rules.gen != listsGen {
+		// blocklists were added or removed through the API while building
+		rules.keepAdded(GetInstance())
+	}
+	setInstance(rules)
+	err := loadAllowed()
+	if err != nil {
+		log.Printf("Error loading allowed domains from %s: %s", AllowedFile(), err)
+	}
+}
+
+// keepAdded replaces the blocklists added through the API with the ones
+// of the running rules
+func (r *Rules) keepAdded(running *Rules) {
+	lists := []*Blocklist{}
+	for _, b := range r.Blocklists {
+		if b.Source != "api" {
+			lists = append(lists, b)
+		}
+	}
+	r.Blocklists, r.added = lists, nil
+	for _, bc := range running.added {
+		b := running.Blocklist(bc.Name)
+		if b == nil || r.Blocklist(bc.Name) != nil {
+			continue
+		}
+		kept := *b
+		if err := r.link(&kept, bc); err != nil {
+			log.Printf("Blocklist %s ignored: %s", bc.Name, err)
+			continue
+		}
+		r.Blocklists = append(r.Blocklists, &kept)
+		r.added = append(r.added, bc)
+	}
+}
+
+// newBlocklist builds a blocklist, downloading and parsing its sources
+func (r *Rules) newBlocklist(bc config.BlocklistConfig, source string) (*Blocklist, error) {
+	if bc.Name == "" {
+		return nil, errors.New("blocklist without name")
+	}
+	b := &Blocklist{Name: bc.Name, Groups: bc.Groups, Source: source, domains: map[string]bool{}}
+	err := r.link(b, bc)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range bc.Domains {
+		b.domains[strings.ToLower(d)] = true
+	}
+	for _, src := range bc.Sources {
+		domains, err := parser.ParseDomainsFile(src)
+		if err != nil {
+			log.Printf("Error loading blocklist %s from %s: %s", bc.Name, src, err)
+			continue
+		}
+		for _, d := range domains {
+			b.domains[strings.ToLower(d)] = true
+		}
+	}
+	log.Printf("Blocklist %s loaded with %d domains", b.Name, len(b.domains))
+	return b, nil
+}
+
+// link points a list to its schedule of the rules, checking that its
+// groups exist
+func (r *Rules) link(b *Blocklist, bc config.BlocklistConfig) error {
+	b.Schedule = nil
+	if bc.Schedule != "" {
+		b.Schedule = r.Schedules[bc.Schedule]
+		if b.Schedule == nil {
+			return fmt.Errorf("blocklist %s: unknown schedule %s", bc.Name, bc.Schedule)
+		}
+	}
+	for _, g := range bc.Groups {
+		if r.group(g) == nil {
+			return fmt.Errorf("blocklist %s: unknown group %s", bc.Name, g)
+		}
+	}
+	return nil
+}
+
+// Blocklist returns the list with the name, nil if there is none
+func (r *Rules) Blocklist(name string) *Blocklist {
+	for _, b := range r.Blocklists {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+func (r *Rules) group(name string) *Group {
+	for _, g := range r.Groups {
+		if g.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+func (g *Group) contains(ip net.IP) bool {
+	for _, n := range g.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientGroups returns the groups the client belongs to
+func (r *Rules) ClientGroups(clientIp string) []*Group {
+	var groups []*Group
+	ip := net.ParseIP(clientIp)
+	if ip == nil {
+		return groups
+	}
+	for _, g := range r.Groups {
+		if g.contains(ip) {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// match returns the domain, or its closest parent domain, found in the list
+func (b *Blocklist) match(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for {
+		if b.domains[domain] {
+			return domain
+		}
+		i := strings.Index(domain, ".")
+		if i < 0 {
+			return ""
+		}
+		domain = domain[i+1:]
+	}
+}
+
+// evaluate checks a list for a client at time t
+func (r *Rules) evaluate(b *Blocklist, groups []*Group, domain string, t time.Time) Trace {
+	tr := Trace{List: b.Name, Matched: b.match(domain)}
+
+	// find a client group the list is enforced for, preferring
+	// one whose schedule is active
+	var group *Group = nil
+	for _, g := range groups {
+		if !contains(b.Groups, g.Name) {
+			continue
+		}
+		if group == nil {
+			group = g
+		}
+		if g.Schedule == nil || g.Schedule.IsActive(t) {
+			group = g
+			break
+		}
+	}
+	applies := len(b.Groups) == 0 || group != nil
+	if group != nil {
+		tr.Group = group.Name
+	}
+
+	// both the list and the group schedule (if any) must be active
+	tr.ScheduleActive = true
+	for _, s := range []*Schedule{b.Schedule, groupSchedule(group)} {
+		if s != nil {
+			tr.Schedule = s.Name
+			if !s.IsActive(t) {
+				tr.ScheduleActive = false
+				break
+			}
+		}
+	}
+
+	tr.Blocked = tr.Matched != "" && applies && tr.ScheduleActive
+	return tr
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func groupSchedule(g *Group) *Schedule {
+	if g == nil {
+		return nil
+	}
+	return g.Schedule
+}
+
+// Check decides if a domain queried by a client at time t must be blocked
+func Check(clientIp, domain string, t time.Time) Decision {
+	r := GetInstance()
+	groups := r.ClientGroups(clientIp)
+	for _, b := range r.Blocklists {
+		tr := r.evaluate(b, groups, domain, t)
+		if tr.Blocked {
+			return Decision{Blocked: true, List: tr.List, Group: tr.Group, Schedule: tr.Schedule}
+		}
+	}
+	return Decision{Blocked: false}
+}
+
+// Explain returns how every blocklist was evaluated for a query,
+// used for diagnostics
+func Explain(clientIp, domain string, t time.Time) []Trace {
+	r := GetInstance()
+	groups := r.ClientGroups(clientIp)
+	traces := []Trace{}
+	for _, b := range r.Blocklists {
+		traces = append(traces, r.evaluate(b, groups, domain, t))
+	}
+	return traces
+}
+
+// Explanation tells why a query is blocked or not
+type Explanation struct {
+	Groups   []string // groups of the client
+	Lists    []Trace
+	Decision Decision
+}
+
+// Why explains how the blocklists apply to a domain queried by a client
+// at time t
+func Why(clientIp, domain string, t time.Time) Explanation {
+	e := Explanation{Groups: []string{}, Lists: Explain(clientIp, domain, t), Decision: Check(clientIp, domain, t)}
+	for _, g := range GetInstance().ClientGroups(clientIp) {
+		e.Groups = append(e.Groups, g.Name)
+	}
+	return e
+}
@@ -0,0 +1,206 @@
+package blocking
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"GoHole/config"
+)
+
+// ErrConfigBlocklist is returned when changing a blocklist of the config
+// file, which can only be changed editing the config
+var ErrConfigBlocklist = errors.New("the blocklist is in the config file, edit the config to change it")
+
+// serializes the changes of the blocklists added through the API, which
+// increment listsGen
+var listsLock sync.Mutex
+var listsGen = 0
+
+// Size returns the number of domains of the list
+func (b *Blocklist) Size() int {
+	return len(b.domains)
+}
+
+// ErrSource is returned when adding a blocklist whose sources are not
+// URLs, the API can not read the files of the server
+var ErrSource = errors.New("the sources of the blocklists added through the API must be http or https URLs")
+
+func validSource(source string) bool {
+	u, err := url.Parse(source)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// AddBlocklist adds a blocklist on the running server, replacing the one
+// added before with the same name, and saves it to BlocklistsFile. It
+// returns whether the list is new.
+func AddBlocklist(bc config.BlocklistConfig) (*Blocklist, bool, error) {
+	for _, src := range bc.Sources {
+		if !validSource(src) {
+			return nil, false, ErrSource
+		}
+	}
+	if old := GetInstance().Blocklist(bc.Name); old != nil && old.Source == "config" {
+		return nil, false, ErrConfigBlocklist
+	}
+	// download the sources without holding the lock, it can take a while
+	b, err := GetInstance().newBlocklist(bc, "api")
+	if err != nil {
+		return nil, false, err
+	}
+
+	listsLock.Lock()
+	defer listsLock.Unlock()
+
+	// the config may have been reloaded meanwhile
+	r := GetInstance()
+	if old := r.Blocklist(bc.Name); old != nil && old.Source == "config" {
+		return nil, false, ErrConfigBlocklist
+	}
+	err = r.link(b, bc)
+	if err != nil {
+		return nil, false, err
+	}
+
+	rules := *r
+	rules.Blocklists, rules.added = nil, nil
+	created := true
+	for _, old := range r.Blocklists {
+		if old.Name == bc.Name {
+			created = false
+			continue
+		}
+		rules.Blocklists = append(rules.Blocklists, old)
+	}
+	for _, old := range r.added {
+		if old.Name != bc.Name {
+			rules.added = append(rules.added, old)
+		}
+	}
+	rules.Blocklists = append(rules.Blocklists, b)
+	rules.added = append(rules.added, bc)
+
+	err = saveBlocklists(rules.added)
+	if err != nil {
+		return nil, false, err
+	}
+	setInstance(&rules)
+	listsGen++
+	log.Printf("Blocklist %s added", bc.Name)
+	return b, created, nil
+}
+
+// RemoveBlocklist removes a blocklist added on the running server, it
+// returns false if there is no such list (or it is in the config)
+func RemoveBlocklist(name string) (bool, error) {
+	listsLock.Lock()
+	defer listsLock.Unlock()
+
+	r := GetInstance()
+	if b := r.Blocklist(name); b == nil || b.Source != "api" {
+		return false, nil
+	}
+	rules := *r
+	rules.Blocklists, rules.added = nil, nil
+	for _, b := range r.Blocklists {
+		if b.Name != name {
+			rules.Blocklists = append(rules.Blocklists, b)
+		}
+	}
+	for _, bc := range r.added {
+		if bc.Name != name {
+			rules.added = append(rules.added, bc)
+		}
+	}
+
+	err := saveBlocklists(rules.added)
+	if err != nil {
+		return false, err
+	}
+	setInstance(&rules)
+	listsGen++
+	log.Printf("Blocklist %s removed", name)
+	return true, nil
+}
+
+// BlocklistsFile returns the file the blocklists added through the API
+// are saved to, so they are kept across restarts
+func BlocklistsFile() string {
+	return blocklistsFile(config.GetInstance())
+}
+
+func blocklistsFile(cfg *config.MyConfig) string {
+	return config.HomeFile(cfg.BlocklistsFile, "gohole.blocklists")
+}
+
+// AllowedFile returns the file the domains allowed through the API are
+// saved to, one domain per line
+func AllowedFile() string {
+	return config.HomeFile(config.GetInstance().AllowedDomainsFile, "gohole.allowed")
+}
+
+func saveBlocklists(lists []config.BlocklistConfig) error {
+	if lists == nil {
+		lists = []config.BlocklistConfig{}
+	}
+	data, err := json.MarshalIndent(lists, "", "\t")
+	if err != nil {
+		return err
+	}
+	return config.WriteFile(BlocklistsFile(), data)
+}
+
+func loadBlocklists(path string) ([]config.BlocklistConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil // nothing added yet
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lists []config.BlocklistConfig
+	return lists, json.Unmarshal(data, &lists)
+}
+
+// saveAllowed writes the allowed domains, allowedLock must be held
+func saveAllowed() error {
+	var b strings.Builder
+	for d := range allowed {
+		b.WriteString(d + "\n")
+	}
+	return config.WriteFile(AllowedFile(), []byte(b.String()))
+}
+
+// loadAllowed replaces the allowed domains with the ones in AllowedFile
+func loadAllowed() error {
+	allowedLock.Lock()
+	defer allowedLock.Unlock()
+
+	file, err := os.Open(AllowedFile())
+	if os.IsNotExist(err) {
+		return nil // nothing allowed yet
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	loaded := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if d := strings.TrimSpace(scanner.Text()); d != "" {
+			loaded[cleanDomain(d)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	allowed = loaded
+	return nil
+}
@@ -0,0 +1,99 @@
+package blocking
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Pause is a temporary suspension of blocking for everybody
+// (empty Target), a client IP or a group
+type Pause struct {
+	Target string
+	Until  time.Time
+}
+
+var pauses = map[string]*Pause{}
+var pausesLock sync.Mutex
+
+// PauseBlocking disables blocking for target during d, blocking is
+// automatically enabled again once it expires. The target must be
+// empty, an IP or a configured group.
+func PauseBlocking(target string, d time.Duration) (Pause, error) {
+	if target != "" && net.ParseIP(target) == nil && GetInstance().group(target) == nil {
+		return Pause{}, errors.New(target + " is not an IP address or a configured group")
+	}
+
+	pausesLock.Lock()
+	defer pausesLock.Unlock()
+
+	p := &Pause{Target: target, Until: time.Now().Add(d)}
+	pauses[target] = p
+	time.AfterFunc(d, func() {
+		pausesLock.Lock()
+		defer pausesLock.Unlock()
+		// it may have been resumed or paused again in the meantime
+		if pauses[target] == p {
+			delete(pauses, target)
+			log.Printf("Blocking enabled again for %s", pauseName(target))
+		}
+	})
+
+	log.Printf("Blocking paused for %s until %s", pauseName(target), p.Until.Format(time.RFC1123))
+	return *p, nil
+}
+
+// ResumeBlocking enables blocking again for target
+func ResumeBlocking(target string) {
+	pausesLock.Lock()
+	defer pausesLock.Unlock()
+
+	delete(pauses, target)
+	log.Printf("Blocking enabled again for %s", pauseName(target))
+}
+
+// GetPauses returns the active pauses
+func GetPauses() []Pause {
+	pausesLock.Lock()
+	defer pausesLock.Unlock()
+
+	list := []Pause{}
+	now := time.Now()
+	for _, p := range pauses {
+		if p.Until.After(now) {
+			list = append(list, *p)
+		}
+	}
+	return list
+}
+
+// IsPaused reports whether blocking is paused for the client at time t,
+// either globally, for its IP or for one of its groups
+func IsPaused(clientIp string, t time.Time) bool {
+	pausesLock.Lock()
+	defer pausesLock.Unlock()
+
+	if len(pauses) == 0 {
+		return false
+	}
+
+	targets := []string{"", clientIp}
+	for _, g := range GetInstance().ClientGroups(clientIp) {
+		targets = append(targets, g.Name)
+	}
+	for _, target := range targets {
+		if p, ok := pauses[target]; ok && p.Until.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func pauseName(target string) string {
+	if target == "" {
+		return "all clients"
+	}
+	return target
+}
@@ -0,0 +1,109 @@
+package blocking
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"GoHole/config"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+type window struct {
+	days [7]bool
+	from int // minutes since midnight
+	to   int
+}
+
+// Schedule is a set of weekly time windows in a given timezone
+type Schedule struct {
+	Name     string
+	location *time.Location
+	windows  []window
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func newSchedule(cfg config.ScheduleConfig) (*Schedule, error) {
+	s := &Schedule{Name: cfg.Name, location: time.Local}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %s", cfg.Name, err)
+		}
+		s.location = loc
+	}
+	if len(cfg.Windows) == 0 {
+		return nil, errors.New("schedule " + cfg.Name + " has no windows")
+	}
+
+	for _, w := range cfg.Windows {
+		var win window
+		var err error
+		if win.from, err = parseClock(w.From); err != nil {
+			return nil, fmt.Errorf("schedule %s: %s", cfg.Name, err)
+		}
+		if win.to, err = parseClock(w.To); err != nil {
+			return nil, fmt.Errorf("schedule %s: %s", cfg.Name, err)
+		}
+		if len(w.Days) == 0 {
+			for d := range win.days {
+				win.days[d] = true
+			}
+		}
+		for _, d := range w.Days {
+			day := strings.ToLower(d)
+			if len(day) > 3 {
+				day = day[0:3] // accept "monday", "Tuesday"...
+			}
+			wd, ok := weekdays[day]
+			if !ok {
+				return nil, fmt.Errorf("schedule %s: invalid day %q", cfg.Name, d)
+			}
+			win.days[wd] = true
+		}
+		s.windows = append(s.windows, win)
+	}
+
+	return s, nil
+}
+
+// IsActive reports whether t falls inside one of the schedule windows
+func (s *Schedule) IsActive(t time.Time) bool {
+	t = t.In(s.location)
+	day := t.Weekday()
+	yesterday := (day + 6) % 7
+	minute := t.Hour()*60 + t.Minute()
+
+	for _, w := range s.windows {
+		if w.from < w.to {
+			if w.days[day] && minute >= w.from && minute < w.to {
+				return true
+			}
+		} else {
+			// the window wraps past midnight (from == to is a whole day), so
+			// it can have started today or yesterday
+			if (w.days[day] && minute >= w.from) || (w.days[yesterday] && minute < w.to) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
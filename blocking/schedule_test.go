@@ -0,0 +1,139 @@
+package blocking
+
+import (
+	"testing"
+	"time"
+
+	"GoHole/config"
+)
+
+// at parses a UTC time, 2026-10-19 is a Monday
+func at(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func testSchedule(t *testing.T, name, timezone string, days []string, from, to string) *Schedule {
+	s, err := newSchedule(config.ScheduleConfig{
+		Name:     name,
+		Timezone: timezone,
+		Windows:  []config.ScheduleWindow{{Days: days, From: from, To: to}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestScheduleIsActive(t *testing.T) {
+	tests := []struct {
+		name     string
+		timezone string
+		days     []string
+		from, to string
+		time     string
+		active   bool
+	}{
+		{"inside", "UTC", nil, "09:00", "17:00", "2026-10-19 12:00", true},
+		{"at the start", "UTC", nil, "09:00", "17:00", "2026-10-19 09:00", true},
+		{"at the end", "UTC", nil, "09:00", "17:00", "2026-10-19 17:00", false},
+		{"other day", "UTC", []string{"tue"}, "09:00", "17:00", "2026-10-19 12:00", false},
+		{"long day names", "UTC", []string{"Monday"}, "09:00", "17:00", "2026-10-19 12:00", true},
+
+		// 21:00-07:00 starting on Monday night
+		{"wrap, the day it starts", "UTC", []string{"mon"}, "21:00", "07:00", "2026-10-19 22:00", true},
+		{"wrap, the next morning", "UTC", []string{"mon"}, "21:00", "07:00", "2026-10-20 06:59", true},
+		{"wrap, the next morning at the end", "UTC", []string{"mon"}, "21:00", "07:00", "2026-10-20 07:00", false},
+		{"wrap, the morning of the day it starts", "UTC", []string{"mon"}, "21:00", "07:00", "2026-10-19 06:00", false},
+		{"wrap, the next night", "UTC", []string{"mon"}, "21:00", "07:00", "2026-10-20 22:00", false},
+		{"wrap, before it starts", "UTC", []string{"mon"}, "21:00", "07:00", "2026-10-19 20:59", false},
+
+		// from == to is a whole day from that time
+		{"whole day from midnight", "UTC", []string{"mon"}, "00:00", "00:00", "2026-10-19 23:59", true},
+		{"whole day ends at midnight", "UTC", []string{"mon"}, "00:00", "00:00", "2026-10-20 00:00", false},
+		{"whole day from 08:00", "UTC", []string{"mon"}, "08:00", "08:00", "2026-10-20 07:59", true},
+		{"whole day from 08:00 ends", "UTC", []string{"mon"}, "08:00", "08:00", "2026-10-20 08:00", false},
+		{"whole day before it starts", "UTC", []string{"mon"}, "08:00", "08:00", "2026-10-19 07:59", false},
+
+		// New York is UTC-4 until the DST change on 2026-11-01 06:00 UTC, UTC-5 after
+		{"timezone", "America/New_York", nil, "21:00", "07:00", "2026-10-31 01:30", true},
+		{"timezone, UTC is inside", "America/New_York", nil, "21:00", "07:00", "2026-10-30 22:30", false},
+		{"timezone, before the DST change", "America/New_York", nil, "21:00", "07:00", "2026-10-31 11:30", false},
+		{"timezone, after the DST change", "America/New_York", nil, "21:00", "07:00", "2026-11-01 11:30", true},
+		{"timezone, after the DST change, evening", "America/New_York", nil, "21:00", "07:00", "2026-11-02 01:30", false},
+		{"timezone, repeated hour", "America/New_York", []string{"sat"}, "21:00", "07:00", "2026-11-01 06:30", true},
+		{"timezone, day of the window", "America/New_York", []string{"mon"}, "21:00", "07:00", "2026-10-20 02:00", true},
+		{"timezone, day in UTC", "America/New_York", []string{"tue"}, "21:00", "07:00", "2026-10-20 02:00", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := testSchedule(t, "test", test.timezone, test.days, test.from, test.to)
+			if got := s.IsActive(at(test.time)); got != test.active {
+				t.Errorf("active at %s: got %t, want %t", test.time, got, test.active)
+			}
+		})
+	}
+}
+
+func TestScheduleErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.ScheduleConfig
+	}{
+		{"no windows", config.ScheduleConfig{Name: "s"}},
+		{"invalid time", config.ScheduleConfig{Name: "s", Windows: []config.ScheduleWindow{{From: "25:00", To: "07:00"}}}},
+		{"invalid day", config.ScheduleConfig{Name: "s", Windows: []config.ScheduleWindow{{Days: []string{"xyz"}, From: "21:00", To: "07:00"}}}},
+		{"invalid timezone", config.ScheduleConfig{Name: "s", Timezone: "Nowhere/City", Windows: []config.ScheduleWindow{{From: "21:00", To: "07:00"}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newSchedule(test.cfg); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+// the blocklists of a group with a schedule are only enforced while both
+// the list and the group schedules are active
+func TestGroupAndListSchedules(t *testing.T) {
+	night := testSchedule(t, "night", "UTC", nil, "21:00", "07:00")
+	weekdays := testSchedule(t, "weekdays", "UTC", []string{"mon", "tue", "wed", "thu", "fri"}, "00:00", "00:00")
+	kids := &Group{Name: "kids", Schedule: weekdays}
+	adults := &Group{Name: "adults"}
+
+	tests := []struct {
+		name     string
+		list     *Schedule
+		groups   []string // groups of the list
+		client   []*Group // groups of the client
+		time     string
+		blocked  bool
+		group    string
+		schedule string
+	}{
+		{"list schedule active", night, nil, nil, "2026-10-19 22:00", true, "", "night"},
+		{"list schedule inactive", night, nil, nil, "2026-10-19 12:00", false, "", "night"},
+		{"both active", night, []string{"kids"}, []*Group{kids}, "2026-10-19 22:00", true, "kids", "weekdays"},
+		{"group schedule inactive", night, []string{"kids"}, []*Group{kids}, "2026-10-24 22:00", false, "kids", "weekdays"},
+		{"list schedule inactive for the group", night, []string{"kids"}, []*Group{kids}, "2026-10-19 12:00", false, "kids", "night"},
+		{"group schedule only", nil, []string{"kids"}, []*Group{kids}, "2026-10-19 12:00", true, "kids", "weekdays"},
+		{"group without schedule", night, []string{"kids", "adults"}, []*Group{kids, adults}, "2026-10-24 22:00", true, "adults", "night"},
+		{"group with an active schedule first", night, []string{"kids", "adults"}, []*Group{kids, adults}, "2026-10-19 22:00", true, "kids", "weekdays"},
+		{"client not in the groups", nil, []string{"kids"}, []*Group{adults}, "2026-10-19 12:00", false, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := &Blocklist{Name: "list", Groups: test.groups, Schedule: test.list, domains: map[string]bool{"ads.example.com": true}}
+			r := &Rules{Groups: []*Group{kids, adults}, Blocklists: []*Blocklist{b}}
+			tr := r.evaluate(b, test.client, "www.ads.example.com", at(test.time))
+			if tr.Blocked != test.blocked || tr.Group != test.group || tr.Schedule != test.schedule {
+				t.Errorf("got blocked %t by group %q and schedule %q, want %t, %q and %q",
+					tr.Blocked, tr.Group, tr.Schedule, test.blocked, test.group, test.schedule)
+			}
+		})
+	}
+}
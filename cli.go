@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"GoHole/config"
+)
+
+// command is a gohole subcommand: either a group of subcommands or a
+// command with its own flags and arguments
+type command struct {
+	name     string
+	args     string // positional arguments shown in the help, e.g. "<domain>"
+	nargs    int    // number of positional arguments, -1 for any
+	optArgs  int    // number of optional positional arguments after them
+	summary  string
+	noConfig bool // runs without loading the config file
+
+	// flags adds the flags of the command and returns the function that
+	// runs it with the positional arguments
+	flags func(fs *flag.FlagSet) func(args []string) error
+
+	sub []*command
+}
+
+// errUsage is returned when the command line is wrong, the usage has
+// already been printed
+var errUsage = errors.New("invalid usage")
+
+func (c *command) find(name string) *command {
+	for _, s := range c.sub {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// newFlagSet returns the flags of a command, which print the help of
+// the command on -h
+func (c *command) newFlagSet(path []string) (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+	var run func(args []string) error
+	if c.flags != nil {
+		run = c.flags(fs)
+	}
+	fs.Usage = func() {
+		c.usage(os.Stderr, path, fs)
+	}
+	return fs, run
+}
+
+// runCommand finds the command in args and runs it
+func runCommand(root *command, args []string) error {
+	if len(args) > 0 && args[0] == "help" {
+		return help(root, args[1:])
+	}
+
+	c := root
+	path := []string{root.name}
+	for len(c.sub) > 0 {
+		if len(args) == 0 || isHelp(args[0]) {
+			c.usage(os.Stdout, path, nil)
+			if len(args) == 0 {
+				return errUsage
+			}
+			return nil
+		}
+		s := c.find(args[0])
+		if s == nil {
+			return fmt.Errorf("unknown command \"%s\", run \"%s help\"", strings.Join(append(path, args[0]), " "), root.name)
+		}
+		c = s
+		path = append(path, args[0])
+		args = args[1:]
+	}
+
+	fs, run := c.newFlagSet(path)
+	fs.SetOutput(os.Stderr)
+	args, err := parseInterspersed(fs, args)
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return errUsage
+	}
+	if c.nargs >= 0 && (len(args) < c.nargs || len(args) > c.nargs+c.optArgs) {
+		expected := c.args
+		if expected == "" {
+			expected = "no arguments"
+		}
+		fmt.Fprintf(os.Stderr, "Wrong arguments for %s, expected %s\n\n", strings.Join(path, " "), expected)
+		fs.Usage()
+		return errUsage
+	}
+
+	if !c.noConfig {
+		config.CreateInstance(cfgFile)
+	}
+	return run(args)
+}
+
+// parseInterspersed parses the flags found anywhere in args (not only
+// before the positional arguments) and returns the positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help" || arg == "help"
+}
+
+// usage prints the help of the command, fs is nil for groups
+func (c *command) usage(w io.Writer, path []string, fs *flag.FlagSet) {
+	name := strings.Join(path, " ")
+	if len(path) == 1 {
+		fmt.Fprintf(w, "Usage: %s [-c config] <command>\n\n", name)
+	} else if len(c.sub) > 0 {
+		fmt.Fprintf(w, "Usage: %s <command>\n\n", name)
+	} else {
+		fmt.Fprintf(w, "Usage: %s [flags] %s\n\n", name, c.args)
+	}
+	if c.summary != "" {
+		fmt.Fprintf(w, "%s\n\n", c.summary)
+	}
+
+	if len(c.sub) > 0 {
+		fmt.Fprintf(w, "Commands:\n")
+		tw := tabwriter.NewWriter(w, 0, 4, 3, ' ', 0)
+		for _, s := range c.sub {
+			fmt.Fprintf(tw, "  %s\t%s\n", s.name, s.summary)
+		}
+		tw.Flush()
+		if len(path) == 1 {
+			fmt.Fprintf(w, "\nFlags:\n  -c string\n    \tConfig file: JSON, YAML or TOML (default \"./config.json\")\n")
+		}
+		helpPath := append([]string{path[0], "help"}, path[1:]...)
+		fmt.Fprintf(w, "\nRun \"%s <command>\" for the help of a command.\n", strings.Join(helpPath, " "))
+		return
+	}
+
+	if fs != nil && hasFlags(fs) {
+		fmt.Fprintf(w, "Flags:\n")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+		fs.SetOutput(os.Stderr)
+	}
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	n := 0
+	fs.VisitAll(func(*flag.Flag) { n++ })
+	return n > 0
+}
+
+// help prints the help of the command in path
+func help(root *command, path []string) error {
+	c := root
+	full := []string{root.name}
+	for _, name := range path {
+		s := c.find(name)
+		if s == nil {
+			return fmt.Errorf("unknown command \"%s\"", strings.Join(append(full, name), " "))
+		}
+		c = s
+		full = append(full, name)
+	}
+	if len(c.sub) > 0 {
+		c.usage(os.Stdout, full, nil)
+		return nil
+	}
+	fs, _ := c.newFlagSet(full)
+	c.usage(os.Stdout, full, fs)
+	return nil
+}
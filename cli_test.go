@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// captureOutput returns what f writes to stdout, the usage printed to
+// stderr is discarded
+func captureOutput(t *testing.T, f func()) string {
+	stdout, stderr := os.Stdout, os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+
+	out := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		out <- string(data)
+	}()
+	os.Stdout, os.Stderr = w, devNull
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestParseInterspersed(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+		n    int
+		v    bool
+		err  bool
+	}{
+		{"no arguments", nil, []string{}, 0, false, false},
+		{"flags first", []string{"-n", "3", "-v", "a", "b"}, []string{"a", "b"}, 3, true, false},
+		{"flags last", []string{"a", "b", "-n", "3", "-v"}, []string{"a", "b"}, 3, true, false},
+		{"flags between", []string{"a", "-n=3", "b", "-v"}, []string{"a", "b"}, 3, true, false},
+		{"double dash", []string{"a", "--", "-n", "3"}, []string{"a", "-n", "3"}, 0, false, false},
+		{"unknown flag", []string{"a", "-x"}, nil, 0, false, true},
+		{"missing value", []string{"a", "-n"}, nil, 0, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(ioutil.Discard)
+			n := fs.Int("n", 0, "")
+			v := fs.Bool("v", false, "")
+
+			args, err := parseInterspersed(fs, test.args)
+			if test.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(args, test.want) || *n != test.n || *v != test.v {
+				t.Errorf("got %q -n %d -v %t, want %q -n %d -v %t", args, *n, *v, test.want, test.n, test.v)
+			}
+		})
+	}
+}
+
+// testCommands is a command tree whose commands record how they ran
+func testCommands(ran *[]string) *command {
+	record := func(name string) func(fs *flag.FlagSet) func(args []string) error {
+		return func(fs *flag.FlagSet) func(args []string) error {
+			target := fs.String("target", "", "")
+			return func(args []string) error {
+				*ran = append(*ran, name+" "+strings.Join(args, ",")+" "+*target)
+				return nil
+			}
+		}
+	}
+	return &command{
+		name: "gohole",
+		sub: []*command{
+			{name: "stop", noConfig: true, flags: record("stop")},
+			{name: "why", args: "<domain>", nargs: 1, noConfig: true, flags: record("why")},
+			{name: "query", args: "<name> [type]", nargs: 1, optArgs: 1, noConfig: true, flags: record("query")},
+			{name: "hosts", args: "[domain...]", nargs: -1, noConfig: true, flags: record("hosts")},
+			{name: "pause", sub: []*command{
+				{name: "start", args: "<minutes>", nargs: 1, noConfig: true, flags: record("pause start")},
+			}},
+		},
+	}
+}
+
+// errOther stands for any error other than errUsage
+var errOther = errors.New("any other error")
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		ran  string // empty if the command must not run
+		err  error  // errUsage, or errOther for any other error
+	}{
+		{"no arguments", []string{"stop"}, "stop  ", nil},
+		{"one argument", []string{"why", "example.com"}, "why example.com ", nil},
+		{"optional argument", []string{"query", "example.com", "AAAA"}, "query example.com,AAAA ", nil},
+		{"optional argument missing", []string{"query", "example.com"}, "query example.com ", nil},
+		{"any arguments", []string{"hosts", "a.com", "b.com"}, "hosts a.com,b.com ", nil},
+		{"any arguments, none", []string{"hosts"}, "hosts  ", nil},
+		{"subcommand", []string{"pause", "start", "15"}, "pause start 15 ", nil},
+		{"flag after the arguments", []string{"pause", "start", "15", "-target", "kids"}, "pause start 15 kids", nil},
+		{"flag before the arguments", []string{"pause", "start", "-target", "kids", "15"}, "pause start 15 kids", nil},
+		{"too many arguments", []string{"stop", "now"}, "", errUsage},
+		{"missing argument", []string{"why"}, "", errUsage},
+		{"too many optional arguments", []string{"query", "example.com", "A", "B"}, "", errUsage},
+		{"missing subcommand argument", []string{"pause", "start", "-target", "kids"}, "", errUsage},
+		{"unknown flag", []string{"why", "example.com", "-x"}, "", errUsage},
+		{"no command", nil, "", errUsage},
+		{"group without subcommand", []string{"pause"}, "", errUsage},
+		{"group help", []string{"pause", "-h"}, "", nil},
+		{"command help", []string{"why", "-h"}, "", nil},
+		{"unknown command", []string{"pause", "nope"}, "", errOther},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ran []string
+			var err error
+			captureOutput(t, func() {
+				err = runCommand(testCommands(&ran), test.args)
+			})
+			switch {
+			case test.err == errOther:
+				if err == nil || err == errUsage {
+					t.Errorf("got error %v, want another error", err)
+				}
+			case err != test.err:
+				t.Errorf("got error %v, want %v", err, test.err)
+			}
+			got := strings.Join(ran, ";")
+			if got != test.ran {
+				t.Errorf("ran %q, want %q", got, test.ran)
+			}
+		})
+	}
+}
+
+// the arity of the real commands is checked before loading the config
+func TestCommandsArity(t *testing.T) {
+	tests := [][]string{
+		{"why"},
+		{"why", "a.com", "b.com"},
+		{"query"},
+		{"query", "example.com", "A", "extra"},
+		{"block", "add"},
+		{"pause", "start"},
+		{"completion"},
+		{"version", "now"},
+	}
+	for _, args := range tests {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			var err error
+			captureOutput(t, func() {
+				err = runCommand(commands(), args)
+			})
+			if err != errUsage {
+				t.Errorf("got error %v, want %v", err, errUsage)
+			}
+		})
+	}
+}
+
+func TestListPrint(t *testing.T) {
+	type row struct {
+		Domain string
+		Hits   int
+	}
+	l := newList([]row{{"example.com", 3}, {"a,b.com", 1}}, "Domain", "Hits")
+	l.add("example.com", "3")
+	l.add("a,b.com", "1")
+
+	tests := []struct {
+		format string
+		want   []string // lines of the output
+		err    bool
+	}{
+		{"json", []string{`[`, `  {`, `    "Domain": "example.com",`, `    "Hits": 3`, `  },`, `  {`, `    "Domain": "a,b.com",`, `    "Hits": 1`, `  }`, `]`}, false},
+		{"csv", []string{"Domain,Hits", "example.com,3", `"a,b.com",1`}, false},
+		{"table", []string{"+-------------+------+", "|   DOMAIN    | HITS |", "+-------------+------+", "| example.com |    3 |", "| a,b.com     |    1 |", "+-------------+------+"}, false},
+		{"xml", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			var err error
+			out := captureOutput(t, func() {
+				err = l.print(test.format)
+			})
+			if test.err {
+				if err == nil {
+					t.Errorf("got %q, want an error", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := strings.Split(strings.TrimRight(out, "\n"), "\n")
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  []string
+		err   bool
+	}{
+		{"bash", []string{"complete -o default -F _gohole gohole", `"pause start") words="-target"`, `"block") words="add remove list"`}, false},
+		{"zsh", []string{"bashcompinit", "complete -o default -F _gohole gohole"}, false},
+		{"fish", []string{"-a pause", "__fish_seen_subcommand_from pause; and __fish_seen_subcommand_from start' -o target"}, false},
+		{"powershell", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.shell, func(t *testing.T) {
+			var err error
+			out := captureOutput(t, func() {
+				err = completion(commands(), test.shell)
+			})
+			if test.err {
+				if err == nil {
+					t.Error("got no error for an unknown shell")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("%s completion does not contain %q", test.shell, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"GoHole/blocking"
+	"GoHole/config"
+	"GoHole/control"
+	"GoHole/dnscache"
+	"GoHole/dnsserver"
+	"GoHole/encryption"
+	"GoHole/logs"
+	"GoHole/parser"
+)
+
+// commands returns the gohole command tree
+func commands() *command {
+	root := &command{
+		name:    "gohole",
+		summary: "GoHole is a DNS server that blocks ads and malware domains.",
+		sub: []*command{
+			{name: "serve", summary: "Start the DNS server", flags: serveCommand},
+			{name: "stop", summary: "Stop the running server", flags: stopCommand},
+			{name: "reload", summary: "Reload the config of the running server", flags: reloadCommand},
+			{name: "stats", summary: "Show the query counters of the running server", flags: statsCommand},
+			{name: "why", args: "<domain>", nargs: 1, summary: "Explain why a domain is (or is not) blocked for a client", flags: whyCommand},
+			{name: "query", args: "<name> [type]", nargs: 1, optArgs: 1, summary: "Resolve a name like dig and show how GoHole answered it", flags: queryCommand},
+			{name: "block", summary: "Block and unblock single domains", sub: []*command{
+				{name: "add", args: "<domain>", nargs: 1, summary: "Block a domain", flags: blockAddCommand},
+				{name: "remove", args: "<domain>", nargs: 1, summary: "Unblock a domain", flags: blockRemoveCommand},
+				{name: "list", summary: "List the blocked domains", flags: blockListCommand},
+			}},
+			{name: "lists", summary: "Load blacklists of domains", sub: []*command{
+				{name: "add", args: "<file or URL>", nargs: 1, summary: "Block the domains of a blacklist (hosts file format)", flags: listsAddCommand},
+				{name: "update", args: "<file>", nargs: 1, summary: "Block the domains of every blacklist in a file, one file or URL per line", flags: listsUpdateCommand},
+			}},
+			{name: "cache", summary: "Manage the domains cache", sub: []*command{
+				{name: "flush", summary: "Flush the cache, including the blocked domains", flags: cacheFlushCommand},
+				{name: "stats", summary: "Show the cache statistics of the running server", flags: cacheStatsCommand},
+			}},
+			{name: "log", summary: "Search the query log", sub: []*command{
+				{name: "query", summary: "Show the latest queries, by client and/or domain", flags: logQueryCommand},
+				{name: "clients", summary: "Show the clients and their number of queries", flags: logClientsCommand},
+				{name: "top", summary: "Show the most queried domains", flags: logTopCommand},
+				{name: "tail", summary: "Show the queries of the running server as they are answered", flags: logTailCommand},
+				{name: "flush", summary: "Delete the query log", flags: logFlushCommand},
+			}},
+			{name: "pause", summary: "Pause blocking on the running server", sub: []*command{
+				{name: "start", args: "<minutes>", nargs: 1, summary: "Pause blocking, it is enabled again automatically", flags: pauseStartCommand},
+				{name: "stop", summary: "Enable blocking again", flags: pauseStopCommand},
+				{name: "list", summary: "Show the active pauses", flags: pauseListCommand},
+			}},
+			{name: "config", summary: "Check and show the config", sub: []*command{
+				{name: "check", summary: "Check the config file and the GOHOLE_* environment variables", noConfig: true, flags: configCheckCommand},
+				{name: "dump", summary: "Show the effective config and where each value came from", flags: configDumpCommand},
+			}},
+			{name: "key", summary: "Manage the encryption key of the secure DNS port", sub: []*command{
+				{name: "gen", summary: "Generate a new encryption key", noConfig: true, flags: keyGenCommand},
+			}},
+			{name: "completion", args: "<bash|zsh|fish>", nargs: 1, summary: "Print the shell completion script", noConfig: true},
+			{name: "version", summary: "Show the GoHole version", noConfig: true, flags: versionCommand},
+			{name: "help", args: "[command...]", nargs: -1, summary: "Show the help of a command", noConfig: true},
+		},
+	}
+
+	// these commands need the tree itself
+	root.find("completion").flags = func(fs *flag.FlagSet) func(args []string) error {
+		return func(args []string) error {
+			return completion(root, args[0])
+		}
+	}
+	root.find("help").flags = func(fs *flag.FlagSet) func(args []string) error {
+		return func(args []string) error {
+			return help(root, args)
+		}
+	}
+	return root
+}
+
+func serveCommand(fs *flag.FlagSet) func(args []string) error {
+	port := fs.String("p", "", "DNS server port (overrides DNSPort)")
+	lists := fs.String("lists", "", "Block the domains of the blacklists in this file before starting")
+	genKey := fs.Bool("gen-key", false, "Generate the EncryptionKey file if it does not exist")
+	return func(args []string) error {
+		if *port != "" {
+			config.Override("DNSPort", func(c *config.MyConfig) {
+				c.DNSPort = *port
+			})
+		}
+
+		keyFile := config.GetInstance().EncryptionKey
+		if *genKey {
+			if _, err := os.Stat(keyFile); os.IsNotExist(err) {
+				err = writeKey(keyFile)
+				if err != nil {
+					return err
+				}
+				log.Printf("Encryption key generated in %s\n", keyFile)
+			}
+		}
+		encryption.CreateInstance()
+		encryption.ImportKeyFromFile(keyFile)
+
+		if *lists != "" {
+			err := editBlocked(false, "", nil, func() error {
+				return parser.ParseBlacklistsListFile(*lists)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		dnsserver.ListenAndServe()
+		return nil
+	}
+}
+
+func stopCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		err := dnsserver.Stop()
+		if err == nil {
+			log.Printf("DNS server stopping")
+		}
+		return err
+	}
+}
+
+func reloadCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		err := control.Call("reload", nil, nil)
+		if err == nil {
+			log.Printf("Config reloaded")
+		}
+		return err
+	}
+}
+
+func statsCommand(fs *flag.FlagSet) func(args []string) error {
+	output := outputFlag(fs)
+	return func(args []string) error {
+		if err := validOutput(*output); err != nil {
+			return err
+		}
+		var stats logs.Statistics
+		err := control.Call("stats", nil, &stats)
+		if err != nil {
+			return err
+		}
+		l := newList(stats, "Statistic", "Value")
+		l.add("Queries", strconv.Itoa(stats.Total))
+		l.add("Blocked", strconv.Itoa(stats.Blocked))
+		l.add("Not blocked", strconv.Itoa(stats.NonBlocked))
+		l.add("Cached", strconv.Itoa(stats.Cached))
+		l.add("Not cached", strconv.Itoa(stats.NonCached))
+		l.add("IPv4", strconv.Itoa(stats.Ipv4))
+		l.add("IPv6", strconv.Itoa(stats.Ipv6))
+		l.add("Rewritten", strconv.Itoa(stats.Rewritten))
+		l.add("Prefetched", strconv.Itoa(stats.Prefetched))
+		l.add("Coalesced", strconv.Itoa(stats.Coalesced))
+		return l.print(*output)
+	}
+}
+
+// whyResult is the JSON output of gohole why
+type whyResult struct {
+	Domain   string
+	Client   string
+	Groups   []string
+	Time     time.Time
+	Lists    []blocking.Trace
+	Decision blocking.Decision
+}
+
+func whyCommand(fs *flag.FlagSet) func(args []string) error {
+	client := fs.String("client", "127.0.0.1", "Client IP")
+	at := fs.String("at", "", "Local time (YYYY-MM-DD HH:MM) (default now)")
+	output := outputFlag(fs)
+	return func(args []string) error {
+		if err := validOutput(*output); err != nil {
+			return err
+		}
+		t := time.Now()
+		if *at != "" {
+			var err error
+			t, err = time.ParseInLocation("2006-01-02 15:04", *at, time.Local)
+			if err != nil {
+				return fmt.Errorf("invalid time %s", *at)
+			}
+		}
+
+		// the running server knows the blocklists added through the API
+		// and does not download them again
+		var e blocking.Explanation
+		if control.Running() {
+			cmdArgs := map[string]string{"client": *client, "domain": args[0], "time": t.Format(time.RFC3339)}
+			err := control.Call("why", cmdArgs, &e)
+			if err != nil {
+				return err
+			}
+		} else {
+			err := blocking.Load()
+			if err != nil {
+				return err
+			}
+			e = blocking.Why(*client, args[0], t)
+		}
+
+		r := whyResult{Domain: args[0], Client: *client, Groups: e.Groups, Time: t, Lists: e.Lists, Decision: e.Decision}
+
+		if *output == "table" {
+			fmt.Printf("Domain: %s\nClient: %s (groups: %s)\nTime: %s\n", r.Domain, r.Client, strings.Join(r.Groups, ", "), t.Format(time.RFC1123))
+		}
+		l := newList(r, "Blocklist", "Matched", "Group", "Schedule", "Active", "Blocked")
+		for _, tr := range r.Lists {
+			l.add(tr.List, tr.Matched, tr.Group, tr.Schedule, strconv.FormatBool(tr.ScheduleActive), strconv.FormatBool(tr.Blocked))
+		}
+		err := l.print(*output)
+		if err != nil || *output != "table" {
+			return err
+		}
+		if r.Decision.Blocked {
+			fmt.Printf("Blocked by list %s\n", r.Decision.List)
+		} else {
+			fmt.Println("Not blocked by any blocklist")
+		}
+		return nil
+	}
+}
+
+func blockAddCommand(fs *flag.FlagSet) func(args []string) error {
+	ipv4 := fs.String("ip4", "0.0.0.0", "IPv4 address to answer for the domain")
+	ipv6 := fs.String("ip6", "::1", "IPv6 address to answer for the domain")
+	return func(args []string) error {
+		domain := args[0]
+		daemon := control.Running()
+		cmdArgs := map[string]string{"domain": domain, "ipv4": *ipv4, "ipv6": *ipv6}
+		return editBlocked(daemon, "block", cmdArgs, func() error {
+			dnscache.AddDomainIPv4(domain, *ipv4, false)
+			dnscache.AddDomainIPv6(domain, *ipv6, false)
+			return nil
+		})
+	}
+}
+
+func blockRemoveCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		domain := args[0]
+		daemon := control.Running()
+		return editBlocked(daemon, "unblock", map[string]string{"domain": domain}, func() error {
+			dnscache.DeleteDomainIPv4(domain)
+			dnscache.DeleteDomainIPv6(domain)
+			return nil
+		})
+	}
+}
+
+func blockListCommand(fs *flag.FlagSet) func(args []string) error {
+	output := outputFlag(fs)
+	return func(args []string) error {
+		if err := validOutput(*output); err != nil {
+			return err
+		}
+		var domains []dnscache.Entry
+		if control.Running() {
+			err := control.Call("blocked", nil, &domains)
+			if err != nil {
+				return err
+			}
+		} else {
+			_, err := dnscache.LoadBlocked()
+			if err != nil {
+				return err
+			}
+			domains = dnscache.GetBlockedDomains()
+		}
+		if domains == nil {
+			domains = []dnscache.Entry{}
+		}
+
+		l := newList(domains, "Domain", "IPv4", "IPv6")
+		for _, e := range domains {
+			l.add(e.Domain, e.IPv4, e.IPv6)
+		}
+		return l.print(*output)
+	}
+}
+
+func listsAddCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		path := args[0]
+		daemon := control.Running()
+		return editBlocked(daemon, "blacklist", map[string]string{"path": absPath(path)}, func() error {
+			return parser.ParseBlacklistFile(path)
+		})
+	}
+}
+
+func listsUpdateCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		path := args[0]
+		daemon := control.Running()
+		return editBlocked(daemon, "blacklists", map[string]string{"path": absPath(path)}, func() error {
+			return parser.ParseBlacklistsListFile(path)
+		})
+	}
+}
+
+func cacheFlushCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		var err error
+		if control.Running() {
+			err = control.Call("flush", nil, nil)
+		} else {
+			err = flushOffline()
+		}
+		if err == nil {
+			log.Printf("Cache flushed!")
+		}
+		return err
+	}
+}
+
+func cacheStatsCommand(fs *flag.FlagSet) func(args []string) error {
+	output := outputFlag(fs)
+	return func(args []string) error {
+		if err := validOutput(*output); err != nil {
+			return err
+		}
+		var stats dnscache.Stats
+		err := control.Call("cache", nil, &stats)
+		if err != nil {
+			return err
+		}
+		l := newList(stats, "Statistic", "Value")
+		l.add("Policy", stats.Policy)
+		l.add("Entries", fmt.Sprintf("%d / %d", stats.Entries, stats.MaxEntries))
+		l.add("Memory (bytes)", fmt.Sprintf("%d / %d", stats.Bytes, stats.MaxBytes))
+		l.add("Blocked entries (never evicted)", strconv.Itoa(stats.Pinned))
+		l.add("Blocked entries memory (bytes)", strconv.Itoa(stats.PinnedBytes))
+		l.add("Hits", strconv.FormatUint(stats.Hits, 10))
+		l.add("Misses", strconv.FormatUint(stats.Misses, 10))
+		l.add("Hit ratio", fmt.Sprintf("%.1f%%", stats.HitRatio*100))
+		l.add("Evictions", strconv.FormatUint(stats.Evictions, 10))
+		l.add("Rejected (cache full of blocked entries)", strconv.FormatUint(stats.Rejected, 10))
+		return l.print(*output)
+	}
+}
+
+// the logs DB can only be opened by one process, while the server is
+// running the log commands read it through the control socket
+
+func logQueryCommand(fs *flag.FlagSet) func(args []string) error {
+	client := fs.String("client", "", "Only the queries of this client IP")
+	domain := fs.String("domain", "", "Only the queries of domains containing this text")
+	since := fs.Duration("since", 0, "Only the queries of the last duration, e.g. 30m or 24h")
+	limit := fs.Int("limit", 100, "Max number of queries")
+	output := outputFlag(fs)
+	return func(args []string) error {
+		if err := validOutput(*output); err != nil {
+			return err
+		}
+		filter := logs.QueryFilter{ClientIp: *client, Domain: *domain, Limit: *limit}
+		if *since > 0 {
+			filter.Since = time.Now().Add(-*since)
+		}
+
+		var queries []logs.QueryLog
+		var err error
+		if control.Running() {
+			cmdArgs := map[string]string{"client": filter.ClientIp, "domain": filter.Domain, "limit": strconv.Itoa(filter.Limit)}
+			if !filter.Since.IsZero() {
+				cmdArgs["since"] = filter.Since.Format(time.RFC3339)
+			}
+			err = control.Call("queries", cmdArgs, &queries)
+		} else {
+			queries, err = logs.SearchQueries(filter)
+		}
+		if err != nil {
+			return err
+		}
+		if queries == nil {
+			queries = []logs.QueryLog{}
+		}
+
+		l := newList(queries, "Date", "Client IP", "Domain", "Status")
+		for _, q := range queries {
+			l.add(q.Timestamp.Format(time.RFC1123), q.ClientIp, q.Domain, queryStatus(q))
+		}
+		return l.print(*output)
+	}
+}
+
+func queryStatus(q logs.QueryLog) string {
+	switch {
+	case q.Blocked:
+		return "blocked"
+	case q.Rewritten:
+		return "rewritten"
+	case q.Cached:
+		return "cached"
+	}
+	return "forwarded"
+}
+
+func logClientsCommand(fs *flag.FlagSet) func(args []string) error {
+	output := outputFlag(fs)
+	return func(args []string) error {
+		if err := validOutput(*output); err != nil {
+			return err
+		}
+		var clients []logs.ClientLog
+		var err error
+		if control.Running() {
+			err = control.Call("clients", nil, &clients)
+		} else {
+			clients, err = logs.GetClients()
+		}
+		if err != nil {
+			return err
+		}
+		if clients == nil {
+			clients = []logs.ClientLog{}
+		}
+
+		l := newList(clients, "Client IP", "Num. Queries")
+		for _, c := range clients {
+			l.add(c.ClientIp, strconv.Itoa(c.Queries))
+		}
+		return l.print(*output)
+	}
+}
+
+func logTopCommand(fs *flag.FlagSet) func(args []string) error {
+	limit := fs.Int("limit", 10, "Number of domains")
+	output := outputFlag(fs)
+	return func(args []string) error {
+		if err := validOutput(*output); err != nil {
+			return err
+		}
+		var domains []logs.DomainLog
+		var err error
+		if control.Running() {
+			err = control.Call("topdomains", map[string]string{"limit": strconv.Itoa(*limit)}, &domains)
+		} else {
+			domains, err = logs.GetTopDomains(*limit)
+		}
+		if err != nil {
+			return err
+		}
+		if domains == nil {
+			domains = []logs.DomainLog{}
+		}
+
+		l := newList(domains, "Domain", "Num. Queries")
+		for _, d := range domains {
+			l.add(d.Domain, strconv.Itoa(d.Queries))
+		}
+		return l.print(*output)
+	}
+}
+
+func logTailCommand(fs *flag.FlagSet) func(args []string) error {
+	client := fs.String("client", "", "Only the queries of this client IP")
+	domain := fs.String("domain", "", "Only the domains containing this text, or matching a pattern like *.google.com")
+	blocked := fs.Bool("blocked", false, "Only the blocked queries")
+	qtype := fs.String("type", "", "Only the queries of this record type, e.g. AAAA")
+	output := outputFlag(fs)
+	return func(args []string) error {
+		if err := validOutput(*output); err != nil {
+			return err
+		}
+		if _, ok := dns.StringToType[strings.ToUpper(*qtype)]; *qtype != "" && !ok {
+			return fmt.Errorf("unknown record type %s", *qtype)
+		}
+		cmdArgs := map[string]string{"client": *client, "domain": *domain, "blocked": strconv.FormatBool(*blocked), "type": *qtype}
+
+		// the rows are printed as they arrive, so the table has fixed widths
+		csvWriter := csv.NewWriter(os.Stdout)
+		switch *output {
+		case "table":
+			fmt.Printf("%-8s  %-15s  %-5s  %-9s  %s\n", "TIME", "CLIENT IP", "TYPE", "STATUS", "DOMAIN")
+		case "csv":
+			csvWriter.Write([]string{"Time", "Client IP", "Type", "Status", "Domain", "List"})
+			csvWriter.Flush()
+		}
+
+		return control.Stream("tail", cmdArgs, func(data json.RawMessage) error {
+			var e logs.QueryEvent
+			err := json.Unmarshal(data, &e)
+			if err != nil {
+				return err
+			}
+			switch *output {
+			case "json":
+				fmt.Println(string(data))
+			case "csv":
+				csvWriter.Write([]string{e.Time.Format(time.RFC3339), e.ClientIp, e.Type, e.Status, e.Domain, e.List})
+				csvWriter.Flush()
+			default:
+				domain := e.Domain
+				if e.List != "" {
+					domain += " (" + e.List + ")"
+				}
+				fmt.Printf("%-8s  %-15s  %-5s  %-9s  %s\n", e.Time.Local().Format("15:04:05"), e.ClientIp, e.Type, e.Status, domain)
+			}
+			return nil
+		})
+	}
+}
+
+func logFlushCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		var err error
+		if control.Running() {
+			err = control.Call("flushlog", nil, nil)
+		} else {
+			err = logs.Flush()
+		}
+		if err == nil {
+			log.Printf("Query logs flushed!")
+		}
+		return err
+	}
+}
+
+func pauseStartCommand(fs *flag.FlagSet) func(args []string) error {
+	target := fs.String("target", "", "Client IP or group (default all clients)")
+	return func(args []string) error {
+		var p blocking.Pause
+		err := control.Call("pause", map[string]string{"minutes": args[0], "target": *target}, &p)
+		if err == nil {
+			log.Printf("Blocking paused until %s", p.Until.Format(time.RFC1123))
+		}
+		return err
+	}
+}
+
+func pauseStopCommand(fs *flag.FlagSet) func(args []string) error {
+	target := fs.String("target", "", "Client IP or group (default all clients)")
+	return func(args []string) error {
+		err := control.Call("resume", map[string]string{"target": *target}, nil)
+		if err == nil {
+			log.Printf("Blocking enabled again")
+		}
+		return err
+	}
+}
+
+func pauseListCommand(fs *flag.FlagSet) func(args []string) error {
+	output := outputFlag(fs)
+	return func(args []string) error {
+		if err := validOutput(*output); err != nil {
+			return err
+		}
+		var pauses []blocking.Pause
+		err := control.Call("pauses", nil, &pauses)
+		if err != nil {
+			return err
+		}
+		if pauses == nil {
+			pauses = []blocking.Pause{}
+		}
+
+		l := newList(pauses, "Target", "Paused until")
+		for _, p := range pauses {
+			target := p.Target
+			if target == "" {
+				target = "all clients"
+			}
+			l.add(target, p.Until.Format(time.RFC1123))
+		}
+		return l.print(*output)
+	}
+}
+
+func configCheckCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		for _, name := range config.UnknownEnv() {
+			fmt.Printf("Warning: unknown environment variable %s ignored\n", name)
+		}
+		problems := config.Check(cfgFile)
+		if len(problems) == 0 {
+			fmt.Printf("%s: config OK\n", cfgFile)
+			return nil
+		}
+		fmt.Printf("%s: %d problems found\n", cfgFile, len(problems))
+		for _, p := range problems {
+			fmt.Printf("  - %s\n", p)
+		}
+		return fmt.Errorf("%s is not valid", cfgFile)
+	}
+}
+
+func configDumpCommand(fs *flag.FlagSet) func(args []string) error {
+	output := outputFlag(fs)
+	return func(args []string) error {
+		if err := validOutput(*output); err != nil {
+			return err
+		}
+		fields := config.GetInstance().Dump()
+		l := newList(fields, "Field", "Value", "Source")
+		for _, f := range fields {
+			l.add(f.Name, f.Value, f.Source)
+		}
+		return l.print(*output)
+	}
+}
+
+func keyGenCommand(fs *flag.FlagSet) func(args []string) error {
+	file := fs.String("file", "enc.key", "File to write the key to")
+	return func(args []string) error {
+		err := writeKey(*file)
+		if err == nil {
+			log.Printf("Encryption key generated in %s\n", *file)
+		}
+		return err
+	}
+}
+
+func writeKey(file string) error {
+	k, err := encryption.GenerateRandomKey()
+	if err != nil {
+		return err
+	}
+	encryption.ExportKeyToFile(k, file)
+	return nil
+}
+
+func versionCommand(fs *flag.FlagSet) func(args []string) error {
+	return func(args []string) error {
+		showVersionInfo()
+		return nil
+	}
+}
+
+// editBlocked runs a command that changes the blocked domains on the
+// running server, or runs offline on the domains saved in the blocked
+// domains file and saves them again
+func editBlocked(daemon bool, command string, args map[string]string, offline func() error) error {
+	if daemon {
+		return control.Call(command, args, nil)
+	}
+	_, err := dnscache.LoadBlocked()
+	if err != nil {
+		return err
+	}
+	err = offline()
+	if err != nil {
+		return err
+	}
+	_, err = dnscache.SaveBlocked()
+	return err
+}
+
+// flushOffline removes the files the server fills the cache from on start
+func flushOffline() error {
+	err := dnscache.RemoveBlocked()
+	if err != nil {
+		return err
+	}
+	snapshot := config.GetInstance().CacheSnapshot
+	if snapshot != "" {
+		err = os.Remove(snapshot)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// absPath makes the path of a local file absolute so the running server,
+// started from another directory, finds it
+func absPath(path string) string {
+	if strings.HasPrefix(path, "http") {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
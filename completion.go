@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// completion prints the completion script of a shell, generated from the
+// command tree so it never gets out of date
+func completion(root *command, shell string) error {
+	switch shell {
+	case "bash":
+		fmt.Print(bashCompletion(root))
+	case "zsh":
+		// zsh runs the bash completion through bashcompinit
+		fmt.Print("autoload -U +X bashcompinit && bashcompinit\n" + bashCompletion(root))
+	case "fish":
+		fmt.Print(fishCompletion(root))
+	default:
+		return fmt.Errorf("unknown shell %s, use bash, zsh or fish", shell)
+	}
+	return nil
+}
+
+// walk calls f for every command of the tree with its path, e.g.
+// ["block", "add"], and its flags (nil for the groups)
+func walk(c *command, path []string, f func(c *command, path []string, fs *flag.FlagSet)) {
+	var fs *flag.FlagSet
+	if len(c.sub) == 0 {
+		fs, _ = c.newFlagSet(append([]string{"gohole"}, path...))
+		fs.SetOutput(os.Stderr)
+	}
+	f(c, path, fs)
+	for _, s := range c.sub {
+		walk(s, append(append([]string{}, path...), s.name), f)
+	}
+}
+
+func flagNames(fs *flag.FlagSet) []string {
+	names := []string{}
+	if fs != nil {
+		fs.VisitAll(func(fl *flag.Flag) {
+			names = append(names, "-"+fl.Name)
+		})
+	}
+	sort.Strings(names)
+	return names
+}
+
+func bashCompletion(root *command) string {
+	var paths []string
+	var cases strings.Builder
+	walk(root, nil, func(c *command, path []string, fs *flag.FlagSet) {
+		p := strings.Join(path, " ")
+		if p != "" {
+			paths = append(paths, fmt.Sprintf("%q", p))
+		}
+		words := flagNames(fs)
+		for _, s := range c.sub {
+			words = append(words, s.name)
+		}
+		if p == "" {
+			words = append(words, "-c")
+		}
+		fmt.Fprintf(&cases, "        %q) words=%q ;;\n", p, strings.Join(words, " "))
+	})
+
+	return `# bash completion for gohole, generated by "gohole completion bash"
+_gohole() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local path="" next words i
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case "${COMP_WORDS[i]}" in
+            -c) ((i++)); continue ;;
+            -*) continue ;;
+        esac
+        next="${path:+$path }${COMP_WORDS[i]}"
+        case "$next" in
+            ` + strings.Join(paths, "|") + `) path="$next" ;;
+        esac
+    done
+    case "$path" in
+` + cases.String() + `        *) words="" ;;
+    esac
+    COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+complete -o default -F _gohole gohole
+`
+}
+
+func fishCompletion(root *command) string {
+	var b strings.Builder
+	b.WriteString("# fish completion for gohole, generated by \"gohole completion fish\"\n")
+	b.WriteString("complete -c gohole -n __fish_use_subcommand -o c -r -d 'Config file'\n")
+	walk(root, nil, func(c *command, path []string, fs *flag.FlagSet) {
+		condition := "__fish_use_subcommand"
+		if len(path) > 0 {
+			conds := []string{}
+			for _, p := range path {
+				conds = append(conds, "__fish_seen_subcommand_from "+p)
+			}
+			condition = strings.Join(conds, "; and ")
+		}
+		for _, s := range c.sub {
+			fmt.Fprintf(&b, "complete -c gohole -f -n '%s' -a %s -d %s\n", condition, s.name, fishQuote(s.summary))
+		}
+		if fs != nil {
+			fs.VisitAll(func(fl *flag.Flag) {
+				fmt.Fprintf(&b, "complete -c gohole -n '%s' -o %s -d %s\n", condition, fl.Name, fishQuote(fl.Usage))
+			})
+		}
+	})
+	return b.String()
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
@@ -2,25 +2,90 @@ package config
 
 import (
     "encoding/json"
-    "io/ioutil"
     "log"
+    "os"
+    "reflect"
+    "sync"
+    "time"
 )
 
 // MyConfig struct
 // This is the struct that the config.json must have
 type MyConfig struct {
-    Interface string // the external interface to listen on
+    Interface string // the external interface to listen on, if ListenAddresses is empty
+    ListenAddresses []string // IPs, interface names (all their addresses) or "*" (all IPv4 and IPv6 addresses)
     ServerIP string // the DNS server IP to redirect blocked ads
     DNSPort string // listen on port
     SecureDNSPort string // listen port for encrypted DNS Server
     EncryptionKey string // Path to the encryption key file
+    ControlSocket string // Path to the unix socket used by the CLI to manage the running server
+    PidFile string // Path to the file with the PID of the running server, used by gohole stop
+    APIAddress string // address of the HTTP admin API, e.g. "127.0.0.1:8080" (empty disables it)
+    APIToken string // token the API clients must send in the "Authorization: Bearer" header
+    Dashboard bool // serve the web dashboard at http://go.hole (ServerIP)
+    DashboardPort string // port of the web dashboard (default 80)
+    DashboardPassword string // password to log in to the web dashboard
 
     // Graphite info
     Graphite GraphiteConfig
 
+    ResolverMode string // "forward" to UpstreamDNSServer (default) or "recursive" from the root servers
+    RootHints []string // root servers for the recursive mode ("ip" or "ip:port", default IANA root servers)
+    RecursorPort string // port of the name servers in the recursive mode, also used for the RootHints without a port (default 53)
     UpstreamDNSServer string
+    UpstreamDNSServers []string // fallback servers tried in order when UpstreamDNSServer fails ("ip" or "ip:port")
+    UpstreamTimeout int // time to wait for each upstream server (in milliseconds, default 2000)
+
+    // EDNS0
+    EDNSBufferSize int // max UDP answer size we advertise (default 1232)
+    EDNSClientSubnet string // "strip" (default) or "forward" the client subnet upstream
+    ECSPrefixV4 int // forwarded client subnets are truncated to these prefixes (default 24)
+    ECSPrefixV6 int // (default 56)
+    EDNSCookies bool // DNS cookies (RFC 7873)
+    EDNSCookiesRequired bool // answer BADCOOKIE to UDP clients without a valid server cookie
+
+    DNSSEC bool // validate upstream answers
+    DNSSECTrustAnchors []string // DS records in zone file format (default: root zone KSKs)
+
+    ConfigWatch bool // reload the config when this file changes (it is also reloaded on SIGHUP)
+
     DomainCacheTime int // time to save domains in cache (in seconds)
     DomainPurgeInterval int // interval at which expired domains are purged
+    CacheMaxEntries int // max number of resolved domains in cache (default 10000), blocked domains are never evicted
+    CacheMaxBytes int // approximate max memory used by the resolved domains (default 4 MB)
+    CachePolicy string // eviction policy when the cache is full: "lru" (default) or "lfu"
+    CacheSnapshot string // file the cache is saved to on shutdown and restored from on start (empty disables it)
+    CacheSnapshotInterval int // interval at which the cache snapshot is saved (in seconds, default 300)
+    BlockedDomainsFile string // file the domains blocked with -ad, -ab and the API are saved to (default ~/gohole.blocked)
+    AllowedDomainsFile string // file the domains allowed through the API are saved to (default ~/gohole.allowed)
+    BlocklistsFile string // file the blocklists added through the API are saved to (default ~/gohole.blocklists)
+    Prefetch bool // refresh popular domains in the background before they expire
+    PrefetchBefore int // seconds before expiry at which domains are refreshed (default 60)
+    PrefetchMinHits int // min hits since the domain was cached to refresh it (default 5)
+    ServeStale bool // answer with expired domains when the upstream servers fail (RFC 8767)
+    StaleWindow int // time expired domains are kept to be served stale (in seconds, default 86400)
+    StaleTTL int // TTL of the stale answers (in seconds, default 30)
+
+    // Parental controls: client groups, time schedules and
+    // blocklists that are only enforced for some groups/times
+    Groups []GroupConfig
+    Schedules []ScheduleConfig
+    Blocklists []BlocklistConfig
+    Allowlist []string // domains (and their subdomains) never blocked
+
+    // local DNS records, answered authoritatively
+    LocalHosts []string // hosts files
+    LocalZones []LocalZoneConfig // zone files
+    LocalRecords []string // records in zone file format, e.g. "nas.lan. 300 IN A 192.168.1.10"
+
+    // rewrites (split horizon, aliases) checked before forwarding upstream
+    Rewrites []RewriteConfig
+
+    // built-in safe search rule sets enforced for all clients:
+    // "google", "bing", "duckduckgo", "youtube" or "youtube-moderate"
+    SafeSearch []string
+
+    sources map[string]string // where the fields were set: "file", "env GOHOLE_..." or "command line"
 }
 
 // Graphite Config
@@ -29,45 +94,213 @@ type GraphiteConfig struct {
     Port int
 }
 
+// Group Config
+// A named set of clients, identified by IP or CIDR range
+type GroupConfig struct {
+    Name string
+    Clients []string
+    Schedule string // optional, the group's blocklists are only enforced while it is active
+    SafeSearch []string // built-in safe search rule sets enforced for the group
+}
+
+// Schedule Config
+type ScheduleConfig struct {
+    Name string
+    Timezone string // IANA zone name, e.g. "Europe/Madrid" (empty means local time)
+    Windows []ScheduleWindow
+}
+
+// A time window of a schedule. Windows where From is later than To
+// wrap past midnight, i.e. "21:00"-"07:00" ends the next morning.
+type ScheduleWindow struct {
+    Days []string // day the window starts on: "mon", "tue"... (empty means every day)
+    From string // "HH:MM"
+    To string // "HH:MM"
+}
+
+// Local Zone Config
+type LocalZoneConfig struct {
+    Origin string // e.g. "lan."
+    File string // path to the zone file (RFC 1035 format)
+}
+
+// Rewrite Config
+type RewriteConfig struct {
+    Domain string // exact name or wildcard, e.g. "*.example.com"
+    Target string // IP address, or name to answer with a CNAME
+    Groups []string // groups the rewrite applies to (empty means all clients)
+}
+
+// Blocklist Config
+type BlocklistConfig struct {
+    Name string
+    Sources []string // files or URLs, hosts or one domain per line format
+    Domains []string // extra domains to block
+    Groups []string // groups the list applies to (empty means all clients)
+    Schedule string // optional, the list is only enforced while it is active
+}
+
 var instance *MyConfig = nil
+var instanceLock sync.RWMutex
+
+// file the config was read from, and the changes made by the command
+// line that are applied again on every reload
+var configFile string = ""
+var overrides []func(*MyConfig)
 
 func CreateInstance(filename string) *MyConfig {
+    instanceLock.Lock()
+    defer instanceLock.Unlock()
+
+    configFile = filename
     var err error
     instance, err = loadConfig(filename)
     if err != nil {
-        log.Printf("Error loading config file: %s\nUsing default config.", err)
-        // use defaults
-        instance = &MyConfig{
-            Interface: "wlan0",
-            ServerIP: "0.0.0.0",
-            DNSPort: "53",
-            SecureDNSPort: "443",
-            EncryptionKey: "enc.key",
-            UpstreamDNSServer: "8.8.8.8",
-            DomainCacheTime: 1800,
-            DomainPurgeInterval: 600,
-            Graphite: GraphiteConfig{
-                Host: "localhost",
-                Port: 2003,
-            },
-        }
+        log.Fatalf("Invalid config file %s:\n%s\nRun \"gohole -c %s config check\" to check it.", filename, err, filename)
     }
+    warnUnknownEnv()
 
     return instance
 }
 
+func warnUnknownEnv() {
+    for _, name := range UnknownEnv() {
+        log.Printf("Warning: unknown environment variable %s ignored", name)
+    }
+}
+
+// defaultConfig returns the values used for the fields missing in the
+// config file
+func defaultConfig() *MyConfig {
+    return &MyConfig{
+        ServerIP: "0.0.0.0",
+        DNSPort: "53",
+        SecureDNSPort: "443",
+        EncryptionKey: "enc.key",
+        ControlSocket: "/tmp/gohole.sock",
+        PidFile: "/tmp/gohole.pid",
+        DashboardPort: "80",
+        ResolverMode: "forward",
+        RecursorPort: "53",
+        UpstreamDNSServer: "8.8.8.8",
+        UpstreamTimeout: 2000,
+        EDNSBufferSize: 1232,
+        EDNSClientSubnet: "strip",
+        ECSPrefixV4: 24,
+        ECSPrefixV6: 56,
+        EDNSCookies: true,
+        DomainCacheTime: 1800,
+        DomainPurgeInterval: 600,
+        CacheMaxEntries: 10000,
+        CacheMaxBytes: 4194304,
+        CachePolicy: "lru",
+        CacheSnapshotInterval: 300,
+        PrefetchBefore: 60,
+        PrefetchMinHits: 5,
+        StaleWindow: 86400,
+        StaleTTL: 30,
+        Graphite: GraphiteConfig{
+            Host: "localhost",
+            Port: 2003,
+        },
+    }
+}
+
+// Check reads a config file and returns all its problems
+// (empty if it is valid)
+func Check(filename string) []string {
+    _, err := loadConfig(filename)
+    if problems, ok := err.(Problems); ok {
+        return problems
+    }
+    if err != nil {
+        return []string{err.Error()}
+    }
+    return nil
+}
+
 func GetInstance() *MyConfig {
+    instanceLock.RLock()
+    defer instanceLock.RUnlock()
     return instance
 }
 
+// Override changes a field of the config, now and after every reload
+// (e.g. the port given in the command line)
+func Override(field string, f func(*MyConfig)) {
+    instanceLock.Lock()
+    defer instanceLock.Unlock()
+    override := func(c *MyConfig) {
+        f(c)
+        c.sources[field] = "command line"
+    }
+    overrides = append(overrides, override)
+    override(instance)
+}
+
+// Read reads the config file again, with the command line overrides,
+// without applying it: the caller swaps it in with Swap once everything
+// built from it is ready
+func Read() (*MyConfig, error) {
+    c, err := loadConfig(configFile)
+    if err != nil {
+        return nil, err
+    }
+    warnUnknownEnv()
+
+    instanceLock.RLock()
+    defer instanceLock.RUnlock()
+    for _, f := range overrides {
+        f(c)
+    }
+    return c, nil
+}
+
+// Swap replaces the current config, e.g. with the one returned by Read
+func Swap(c *MyConfig) {
+    instanceLock.Lock()
+    defer instanceLock.Unlock()
+    instance = c
+}
+
+// ModTime returns the last time the config file was modified
+func ModTime() (time.Time, error) {
+    info, err := os.Stat(configFile)
+    if err != nil {
+        return time.Time{}, err
+    }
+    return info.ModTime(), nil
+}
+
+// loadConfig reads the config file over the defaults and overlays the
+// GOHOLE_* environment variables. Without a config file only the
+// environment variables are used.
 func loadConfig(filename string) (*MyConfig, error){
-    var s *MyConfig
+    raw, err := readConfig(filename)
+    if os.IsNotExist(err) {
+        log.Printf("Config file %s not found, using the default config and the environment.", filename)
+        raw = map[string]interface{}{}
+    } else if err != nil {
+        return nil, err
+    }
 
-    bytes, err := ioutil.ReadFile(filename)
+    sources := map[string]string{}
+    markSources(raw, reflect.TypeOf(MyConfig{}), "", sources, "file")
+    problems := applyEnv(raw, sources)
+
+    data, err := json.Marshal(raw)
     if err != nil {
-        return s, err
+        return nil, err
+    }
+    c, err := parseConfig(data)
+    if p, ok := err.(Problems); ok {
+        problems = append(problems, p...)
+    } else if err != nil {
+        return nil, err
+    }
+    if len(problems) > 0 {
+        return nil, problems
     }
-    // Unmarshal json
-    err = json.Unmarshal(bytes, &s)
-    return s, err
+    c.sources = sources
+    return c, nil
 }
@@ -0,0 +1,51 @@
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+    "reflect"
+)
+
+// A field of the effective config and where its value came from
+type Field struct {
+    Name string
+    Value string
+    Source string // "default", "file", "env GOHOLE_..." or "command line"
+}
+
+// Dump lists every field of the config, nested fields like
+// "Graphite.Host" included
+func (c *MyConfig) Dump() []Field {
+    return c.dump(reflect.ValueOf(*c), "")
+}
+
+func (c *MyConfig) dump(v reflect.Value, path string) []Field {
+    var fields []Field
+    t := v.Type()
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        if f.PkgPath != "" {
+            continue // unexported
+        }
+        name := path + f.Name
+        if f.Type.Kind() == reflect.Struct {
+            fields = append(fields, c.dump(v.Field(i), name+".")...)
+            continue
+        }
+
+        value := fmt.Sprint(v.Field(i).Interface())
+        if f.Type.Kind() == reflect.Slice {
+            b, _ := json.Marshal(v.Field(i).Interface())
+            value = string(b)
+        }
+        if (name == "APIToken" || name == "DashboardPassword") && value != "" {
+            value = "********" // never show secrets
+        }
+        source, ok := c.sources[name]
+        if !ok {
+            source = "default"
+        }
+        fields = append(fields, Field{Name: name, Value: value, Source: source})
+    }
+    return fields
+}
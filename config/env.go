@@ -0,0 +1,154 @@
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Prefix of the environment variables that override the config fields,
+// e.g. GOHOLE_DNS_PORT or GOHOLE_GRAPHITE_HOST
+const envPrefix = "GOHOLE_"
+
+type envField struct {
+    path []string // field names, e.g. ["Graphite", "Host"]
+    kind reflect.Type
+}
+
+// envFields maps the name of every field, in upper case and without
+// underscores, to the field (GRAPHITEHOST -> Graphite.Host)
+func envFields(t reflect.Type, path []string, fields map[string]envField) {
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        if f.PkgPath != "" {
+            continue // unexported
+        }
+        p := append(append([]string{}, path...), f.Name)
+        if f.Type.Kind() == reflect.Struct {
+            envFields(f.Type, p, fields)
+            continue
+        }
+        fields[strings.ToUpper(strings.Join(p, ""))] = envField{path: p, kind: f.Type}
+    }
+}
+
+// lookupEnv returns the field set by an environment variable
+func lookupEnv(fields map[string]envField, name string) (envField, bool) {
+    f, ok := fields[strings.ToUpper(strings.Replace(strings.TrimPrefix(name, envPrefix), "_", "", -1))]
+    return f, ok
+}
+
+// UnknownEnv returns the GOHOLE_* environment variables that don't set
+// any field, they are ignored (e.g. variables of other GoHole tools)
+func UnknownEnv() []string {
+    fields := map[string]envField{}
+    envFields(reflect.TypeOf(MyConfig{}), nil, fields)
+
+    var unknown []string
+    for _, kv := range os.Environ() {
+        name := strings.SplitN(kv, "=", 2)[0]
+        if _, ok := lookupEnv(fields, name); strings.HasPrefix(name, envPrefix) && !ok {
+            unknown = append(unknown, name)
+        }
+    }
+    sort.Strings(unknown)
+    return unknown
+}
+
+// applyEnv overlays the GOHOLE_* environment variables onto the fields
+// read from the config file. Underscores in the names are ignored, so
+// GOHOLE_DNS_PORT and GOHOLE_DNSPORT both set DNSPort. Lists are
+// separated by commas, or written in JSON like the lists of objects.
+// Unknown variables are skipped, see UnknownEnv.
+func applyEnv(raw map[string]interface{}, sources map[string]string) Problems {
+    fields := map[string]envField{}
+    envFields(reflect.TypeOf(MyConfig{}), nil, fields)
+
+    env := os.Environ()
+    sort.Strings(env)
+    var problems Problems
+    for _, kv := range env {
+        if !strings.HasPrefix(kv, envPrefix) {
+            continue
+        }
+        name := strings.SplitN(kv, "=", 2)[0]
+        text := strings.TrimPrefix(kv, name+"=")
+        f, ok := lookupEnv(fields, name)
+        if !ok {
+            continue
+        }
+        value, err := envValue(text, f.kind)
+        if err != nil {
+            problems = append(problems, fmt.Sprintf("environment variable %s: %s", name, err))
+            continue
+        }
+        setField(raw, f.path, value)
+        sources[strings.Join(f.path, ".")] = "env " + name
+    }
+    return problems
+}
+
+func envValue(text string, t reflect.Type) (interface{}, error) {
+    switch t.Kind() {
+    case reflect.String:
+        return text, nil
+    case reflect.Int:
+        n, err := strconv.Atoi(text)
+        if err != nil {
+            return nil, fmt.Errorf("%q must be a number", text)
+        }
+        return n, nil
+    case reflect.Bool:
+        b, err := strconv.ParseBool(text)
+        if err != nil {
+            return nil, fmt.Errorf("%q must be true or false", text)
+        }
+        return b, nil
+    }
+
+    // lists
+    var value interface{}
+    if strings.HasPrefix(strings.TrimSpace(text), "[") {
+        err := json.Unmarshal([]byte(text), &value)
+        if err != nil {
+            return nil, fmt.Errorf("invalid JSON list: %s", err)
+        }
+        return value, nil
+    }
+    if t.Elem().Kind() != reflect.String {
+        return nil, fmt.Errorf("must be a JSON list of objects")
+    }
+    items := []interface{}{}
+    for _, item := range strings.Split(text, ",") {
+        if strings.TrimSpace(item) != "" {
+            items = append(items, strings.TrimSpace(item))
+        }
+    }
+    return items, nil
+}
+
+// setField sets a field of the decoded config file, replacing the
+// key written in any case
+func setField(raw map[string]interface{}, path []string, value interface{}) {
+    for key, v := range raw {
+        if !strings.EqualFold(key, path[0]) {
+            continue
+        }
+        if m, ok := v.(map[string]interface{}); ok && len(path) > 1 {
+            setField(m, path[1:], value)
+            return
+        }
+        delete(raw, key)
+    }
+    if len(path) > 1 {
+        m := map[string]interface{}{}
+        raw[path[0]] = m
+        setField(m, path[1:], value)
+        return
+    }
+    raw[path[0]] = value
+}
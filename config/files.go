@@ -0,0 +1,30 @@
+package config
+
+import (
+    "io/ioutil"
+    "os"
+    "os/user"
+)
+
+// HomeFile returns path, or the file name in the home directory of the
+// user when path is empty (in the working directory if there is none)
+func HomeFile(path, name string) string {
+    if path != "" {
+        return path
+    }
+    usr, err := user.Current()
+    if err != nil {
+        return "./" + name
+    }
+    return usr.HomeDir + "/" + name
+}
+
+// WriteFile writes to a temporary file first so a crash never leaves a
+// half written file
+func WriteFile(path string, data []byte) error {
+    err := ioutil.WriteFile(path+".tmp", data, 0600)
+    if err != nil {
+        return err
+    }
+    return os.Rename(path+".tmp", path)
+}
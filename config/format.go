@@ -0,0 +1,121 @@
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "strings"
+
+    "github.com/BurntSushi/toml"
+    "gopkg.in/yaml.v3"
+)
+
+// readConfig decodes a JSON, YAML (.yaml, .yml) or TOML (.toml) config
+// file into a map with the same structure as MyConfig
+func readConfig(filename string) (map[string]interface{}, error) {
+    data, err := ioutil.ReadFile(filename)
+    if err != nil {
+        return nil, err
+    }
+
+    raw := map[string]interface{}{}
+    switch strings.ToLower(filepath.Ext(filename)) {
+    case ".yaml", ".yml":
+        err = yaml.Unmarshal(data, &raw)
+        if err != nil {
+            return nil, Problems{err.Error()}
+        }
+        if raw == nil {
+            raw = map[string]interface{}{} // empty file
+        }
+        fromText(raw, reflect.TypeOf(MyConfig{}))
+    case ".toml":
+        _, err = toml.Decode(string(data), &raw)
+        if err != nil {
+            return nil, Problems{err.Error()}
+        }
+        fromText(raw, reflect.TypeOf(MyConfig{}))
+    default:
+        err = json.Unmarshal(data, &raw)
+        if e, ok := err.(*json.SyntaxError); ok {
+            line, col := position(data, e.Offset)
+            return nil, Problems{fmt.Sprintf("syntax error at line %d, column %d: %s", line, col, e)}
+        }
+        if err != nil {
+            return nil, Problems{"the config must be a JSON object: " + err.Error()}
+        }
+    }
+    return raw, nil
+}
+
+// fromText converts the numbers and booleans written for text fields to
+// text, as YAML and TOML read "DNSPort: 53" as a number
+func fromText(raw map[string]interface{}, t reflect.Type) {
+    for key, value := range raw {
+        f, ok := fieldByName(t, key)
+        if !ok {
+            continue
+        }
+        switch {
+        case f.Type.Kind() == reflect.String:
+            raw[key] = toText(value)
+        case f.Type.Kind() == reflect.Struct:
+            if m, ok := value.(map[string]interface{}); ok {
+                fromText(m, f.Type)
+            }
+        case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.String:
+            if items, ok := value.([]interface{}); ok {
+                for i, item := range items {
+                    items[i] = toText(item)
+                }
+            }
+        case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct:
+            for _, item := range objects(value) {
+                fromText(item, f.Type.Elem())
+            }
+        }
+    }
+}
+
+func toText(value interface{}) interface{} {
+    switch value.(type) {
+    case int, int64, uint64, float64, bool:
+        return fmt.Sprint(value)
+    }
+    return value
+}
+
+// objects returns the items of a list of objects, as decoded by the
+// JSON, YAML or TOML libraries
+func objects(value interface{}) []map[string]interface{} {
+    var items []map[string]interface{}
+    switch list := value.(type) {
+    case []map[string]interface{}:
+        items = list
+    case []interface{}:
+        for _, item := range list {
+            if m, ok := item.(map[string]interface{}); ok {
+                items = append(items, m)
+            }
+        }
+    }
+    return items
+}
+
+// markSources records the fields set in raw as coming from source
+func markSources(raw map[string]interface{}, t reflect.Type, path string, sources map[string]string, source string) {
+    for key, value := range raw {
+        f, ok := fieldByName(t, key)
+        if !ok {
+            continue
+        }
+        m, isObject := value.(map[string]interface{})
+        if f.Type.Kind() == reflect.Struct && isObject {
+            markSources(m, f.Type, path+f.Name+".", sources, source)
+            continue
+        }
+        sources[path+f.Name] = source
+    }
+}
@@ -0,0 +1,385 @@
+package config
+
+import (
+    "encoding/json"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Problems found in a config file, every one explains what to change
+type Problems []string
+
+func (p Problems) Error() string {
+    return strings.Join(p, "\n")
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseConfig reads a config in JSON over the defaults, so missing
+// fields keep their default value. It reports unknown fields, wrong
+// types and invalid values.
+func parseConfig(data []byte) (*MyConfig, error) {
+    c := defaultConfig()
+    var problems Problems
+
+    // each field is decoded on its own so all the wrong types are reported
+    var raw map[string]json.RawMessage
+    err := json.Unmarshal(data, &raw)
+    if err != nil {
+        return nil, Problems{err.Error()}
+    }
+    keys := make([]string, 0, len(raw))
+    for key := range raw {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    v := reflect.ValueOf(c).Elem()
+    for _, key := range keys {
+        f, ok := fieldByName(v.Type(), key)
+        if !ok {
+            continue // reported by unknownFields
+        }
+        err := json.Unmarshal(raw[key], v.FieldByIndex(f.Index).Addr().Interface())
+        if e, ok := err.(*json.UnmarshalTypeError); ok {
+            field := f.Name
+            if e.Field != "" {
+                for _, part := range strings.Split(e.Field, ".") {
+                    if _, err := strconv.Atoi(part); err == nil {
+                        field += "[" + part + "]"
+                    } else {
+                        field += "." + part
+                    }
+                }
+            }
+            problems = append(problems, fmt.Sprintf("%s must be a %s, not a %s", field, e.Type, e.Value))
+        } else if err != nil {
+            problems = append(problems, f.Name+": "+err.Error())
+        }
+    }
+
+    problems = append(problems, unknownFields(data, reflect.TypeOf(*c), "")...)
+    problems = append(problems, c.problems()...)
+    if len(problems) > 0 {
+        return c, problems
+    }
+    return c, nil
+}
+
+func position(data []byte, offset int64) (int, int) {
+    if offset > int64(len(data)) {
+        offset = int64(len(data))
+    }
+    before := string(data[0:offset])
+    line := strings.Count(before, "\n") + 1
+    col := len(before) - strings.LastIndex(before, "\n")
+    return line, col
+}
+
+// unknownFields returns the fields of a JSON object that are not in the
+// struct type t (encoding/json silently ignores them)
+func unknownFields(data []byte, t reflect.Type, path string) Problems {
+    var raw map[string]json.RawMessage
+    if json.Unmarshal(data, &raw) != nil {
+        return nil // wrong types are reported by Unmarshal
+    }
+
+    var problems Problems
+    for key, value := range raw {
+        f, ok := fieldByName(t, key)
+        if !ok {
+            problems = append(problems, "unknown field "+path+key)
+            continue
+        }
+        switch {
+        case f.Type.Kind() == reflect.Struct:
+            problems = append(problems, unknownFields(value, f.Type, path+f.Name+".")...)
+        case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct:
+            var items []json.RawMessage
+            json.Unmarshal(value, &items)
+            for i, item := range items {
+                problems = append(problems, unknownFields(item, f.Type.Elem(), fmt.Sprintf("%s%s[%d].", path, f.Name, i))...)
+            }
+        }
+    }
+    sort.Strings(problems)
+    return problems
+}
+
+// fieldByName finds a field like encoding/json does, ignoring the case
+func fieldByName(t reflect.Type, name string) (reflect.StructField, bool) {
+    for i := 0; i < t.NumField(); i++ {
+        if t.Field(i).PkgPath == "" && strings.EqualFold(t.Field(i).Name, name) {
+            return t.Field(i), true
+        }
+    }
+    return reflect.StructField{}, false
+}
+
+// Validate checks the values of the config
+func (c *MyConfig) Validate() error {
+    problems := c.problems()
+    if len(problems) > 0 {
+        return problems
+    }
+    return nil
+}
+
+func (c *MyConfig) problems() Problems {
+    var p Problems
+    add := func(format string, args ...interface{}) {
+        p = append(p, fmt.Sprintf(format, args...))
+    }
+
+    // listeners
+    checkPort := func(field, port string, optional bool) {
+        if port == "" && optional {
+            return
+        }
+        n, err := strconv.Atoi(port)
+        if err != nil || n <= 0 || n > 65535 {
+            add("%s %q must be a port number between 1 and 65535", field, port)
+        }
+    }
+    checkPort("DNSPort", c.DNSPort, false)
+    checkPort("SecureDNSPort", c.SecureDNSPort, true)
+    if c.ServerIP != "" && net.ParseIP(c.ServerIP) == nil {
+        add("ServerIP %q must be an IP address", c.ServerIP)
+    }
+    for _, a := range c.ListenAddresses {
+        if a == "" {
+            add("ListenAddresses can not have empty entries, use an IP, an interface name or \"*\"")
+        }
+    }
+
+    if c.APIAddress != "" {
+        if _, port, err := net.SplitHostPort(c.APIAddress); err != nil {
+            add("APIAddress %q must be host:port, e.g. \"127.0.0.1:8080\"", c.APIAddress)
+        } else {
+            checkPort("APIAddress", port, false)
+        }
+        if len(c.APIToken) < 16 {
+            add("APIToken must be at least 16 characters long when APIAddress is set, e.g. generate one with \"openssl rand -hex 16\"")
+        }
+    }
+
+    if c.Dashboard {
+        checkPort("DashboardPort", c.DashboardPort, false)
+        if len(c.DashboardPassword) < 8 {
+            add("DashboardPassword must be at least 8 characters long when Dashboard is enabled")
+        }
+    }
+
+    // upstream servers
+    checkServer := func(field, server string) {
+        host := server
+        if h, port, err := net.SplitHostPort(server); err == nil {
+            host = h
+            checkPort(field, port, false)
+        }
+        if net.ParseIP(host) == nil {
+            add("%s %q must be an IP address, optionally with a port (ip:port)", field, server)
+        }
+    }
+    if c.ResolverMode != "recursive" {
+        checkServer("UpstreamDNSServer", c.UpstreamDNSServer)
+    }
+    for _, s := range c.UpstreamDNSServers {
+        checkServer("UpstreamDNSServers", s)
+    }
+    checkPort("RecursorPort", c.RecursorPort, true)
+    for _, s := range c.RootHints {
+        checkServer("RootHints", s)
+    }
+    switch c.ResolverMode {
+    case "", "forward", "recursive":
+    default:
+        add("ResolverMode %q must be \"forward\" or \"recursive\"", c.ResolverMode)
+    }
+
+    // EDNS0
+    if c.EDNSBufferSize < 512 || c.EDNSBufferSize > 65535 {
+        add("EDNSBufferSize %d must be between 512 and 65535 bytes (1232 recommended)", c.EDNSBufferSize)
+    }
+    switch c.EDNSClientSubnet {
+    case "", "strip", "forward":
+    default:
+        add("EDNSClientSubnet %q must be \"strip\" or \"forward\"", c.EDNSClientSubnet)
+    }
+    if c.ECSPrefixV4 < 0 || c.ECSPrefixV4 > 32 {
+        add("ECSPrefixV4 %d must be between 0 and 32", c.ECSPrefixV4)
+    }
+    if c.ECSPrefixV6 < 0 || c.ECSPrefixV6 > 128 {
+        add("ECSPrefixV6 %d must be between 0 and 128", c.ECSPrefixV6)
+    }
+
+    // durations and sizes
+    positive := map[string]int{
+        "DomainCacheTime": c.DomainCacheTime,
+        "DomainPurgeInterval": c.DomainPurgeInterval,
+    }
+    notNegative := map[string]int{
+        "UpstreamTimeout": c.UpstreamTimeout,
+        "CacheMaxEntries": c.CacheMaxEntries,
+        "CacheMaxBytes": c.CacheMaxBytes,
+        "CacheSnapshotInterval": c.CacheSnapshotInterval,
+        "PrefetchBefore": c.PrefetchBefore,
+        "PrefetchMinHits": c.PrefetchMinHits,
+        "StaleWindow": c.StaleWindow,
+        "StaleTTL": c.StaleTTL,
+    }
+    for _, name := range sortedKeys(positive) {
+        if positive[name] <= 0 {
+            add("%s %d must be greater than 0 (in seconds)", name, positive[name])
+        }
+    }
+    for _, name := range sortedKeys(notNegative) {
+        if notNegative[name] < 0 {
+            add("%s %d can not be negative", name, notNegative[name])
+        }
+    }
+    if c.Prefetch && c.PrefetchBefore >= c.DomainCacheTime && c.DomainCacheTime > 0 {
+        add("PrefetchBefore %d must be lower than DomainCacheTime %d, or domains are prefetched as soon as they are cached", c.PrefetchBefore, c.DomainCacheTime)
+    }
+    switch c.CachePolicy {
+    case "", "lru", "lfu":
+    default:
+        add("CachePolicy %q must be \"lru\" or \"lfu\"", c.CachePolicy)
+    }
+    if c.Graphite.Port < 0 || c.Graphite.Port > 65535 {
+        add("Graphite.Port %d must be a port number between 1 and 65535", c.Graphite.Port)
+    }
+
+    // files read and written by the server
+    checkFile := func(field, path string) {
+        if _, err := os.Stat(path); err != nil {
+            add("%s file %s can not be read: %s", field, path, err)
+        }
+    }
+    checkDir := func(field, path string) {
+        if path == "" {
+            return
+        }
+        if info, err := os.Stat(filepath.Dir(path)); err != nil || !info.IsDir() {
+            add("%s %s: directory %s does not exist", field, path, filepath.Dir(path))
+        }
+    }
+    checkDir("ControlSocket", c.ControlSocket)
+    checkDir("PidFile", c.PidFile)
+    checkDir("CacheSnapshot", c.CacheSnapshot)
+    checkDir("BlockedDomainsFile", c.BlockedDomainsFile)
+    checkDir("AllowedDomainsFile", c.AllowedDomainsFile)
+    checkDir("BlocklistsFile", c.BlocklistsFile)
+    for _, path := range c.LocalHosts {
+        checkFile("LocalHosts", path)
+    }
+    for i, z := range c.LocalZones {
+        if z.Origin == "" {
+            add("LocalZones[%d] needs an Origin, e.g. \"lan.\"", i)
+        }
+        checkFile("LocalZones", z.File)
+    }
+
+    // parental controls
+    schedules := map[string]bool{}
+    for i, s := range c.Schedules {
+        if s.Name == "" {
+            add("Schedules[%d] needs a Name", i)
+        }
+        schedules[s.Name] = true
+        if s.Timezone != "" {
+            if _, err := time.LoadLocation(s.Timezone); err != nil {
+                add("schedule %s: unknown Timezone %q, use an IANA name like \"Europe/Madrid\"", s.Name, s.Timezone)
+            }
+        }
+        if len(s.Windows) == 0 {
+            add("schedule %s needs at least one window", s.Name)
+        }
+        for _, w := range s.Windows {
+            for _, t := range []string{w.From, w.To} {
+                if _, err := time.Parse("15:04", t); err != nil {
+                    add("schedule %s: invalid time %q, expected HH:MM", s.Name, t)
+                }
+            }
+            for _, d := range w.Days {
+                if !validDay(d) {
+                    add("schedule %s: invalid day %q, use mon, tue, wed, thu, fri, sat or sun", s.Name, d)
+                }
+            }
+        }
+    }
+    groups := map[string]bool{}
+    for i, g := range c.Groups {
+        if g.Name == "" {
+            add("Groups[%d] needs a Name", i)
+        }
+        groups[g.Name] = true
+        if g.Schedule != "" && !schedules[g.Schedule] {
+            add("group %s: unknown schedule %s", g.Name, g.Schedule)
+        }
+        for _, client := range g.Clients {
+            if net.ParseIP(client) == nil {
+                if _, _, err := net.ParseCIDR(client); err != nil {
+                    add("group %s: client %q must be an IP or a CIDR range", g.Name, client)
+                }
+            }
+        }
+    }
+    for i, b := range c.Blocklists {
+        if b.Name == "" {
+            add("Blocklists[%d] needs a Name", i)
+        }
+        if b.Schedule != "" && !schedules[b.Schedule] {
+            add("blocklist %s: unknown schedule %s", b.Name, b.Schedule)
+        }
+        for _, g := range b.Groups {
+            if !groups[g] {
+                add("blocklist %s: unknown group %s", b.Name, g)
+            }
+        }
+        for _, src := range b.Sources {
+            if !strings.HasPrefix(src, "http") {
+                checkFile("blocklist "+b.Name+" source", src)
+            }
+        }
+    }
+    for _, r := range c.Rewrites {
+        if r.Domain == "" || r.Target == "" {
+            add("rewrite %q -> %q needs a Domain and a Target", r.Domain, r.Target)
+        }
+        for _, g := range r.Groups {
+            if !groups[g] {
+                add("rewrite %s: unknown group %s", r.Domain, g)
+            }
+        }
+    }
+    return p
+}
+
+func validDay(d string) bool {
+    day := strings.ToLower(d)
+    if len(day) > 3 {
+        day = day[0:3]
+    }
+    for _, wd := range weekdays {
+        if day == wd {
+            return true
+        }
+    }
+    return false
+}
+
+func sortedKeys(m map[string]int) []string {
+    var keys []string
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
@@ -0,0 +1,248 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"GoHole/config"
+)
+
+// Request sent by the CLI to the running server
+type Request struct {
+	Command string
+	Args    map[string]string
+}
+
+// Response sent back by the running server
+type Response struct {
+	Error string
+	Data  json.RawMessage
+}
+
+// Handler runs a command on the running server and returns its result
+type Handler func(args map[string]string) (interface{}, error)
+
+// StreamHandler runs a command that sends results until it fails, or
+// until done is closed when the CLI disconnects
+type StreamHandler func(args map[string]string, send func(data interface{}) error, done <-chan struct{}) error
+
+var handlers = map[string]Handler{}
+var streamHandlers = map[string]StreamHandler{}
+var handlersLock sync.RWMutex
+
+// max time to wait for the request of a client
+const requestTimeout = 5 * time.Second
+
+var listener net.Listener = nil
+var listenerLock sync.Mutex
+
+// Handle registers the handler for a command
+func Handle(command string, h Handler) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	handlers[command] = h
+}
+
+// HandleStream registers the handler for a command that streams results
+func HandleStream(command string, h StreamHandler) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	streamHandlers[command] = h
+}
+
+func socketPath() string {
+	path := config.GetInstance().ControlSocket
+	if path == "" {
+		path = os.TempDir() + "/gohole.sock"
+	}
+	return path
+}
+
+// ListenAndServe accepts control connections on the unix socket,
+// only the user running the server can connect to it
+func ListenAndServe() {
+	path := socketPath()
+	if Running() {
+		log.Printf("Failed to start control socket: another server is listening on %s\n", path)
+		return
+	}
+	os.Remove(path) // remove the socket left by a previous run
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		log.Printf("Failed to start control socket: %s\n", err)
+		return
+	}
+	// no connection is accepted before only our user can connect
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		log.Printf("Failed to start control socket: %s\n", err)
+		l.Close()
+		return
+	}
+
+	listenerLock.Lock()
+	listener = l
+	listenerLock.Unlock()
+
+	log.Printf("Control socket at %s\n", path)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			listenerLock.Lock()
+			closed := listener == nil
+			listenerLock.Unlock()
+			if closed {
+				return
+			}
+			continue
+		}
+		go serve(conn)
+	}
+}
+
+// Close stops accepting control connections and removes the socket
+func Close() {
+	listenerLock.Lock()
+	defer listenerLock.Unlock()
+	if listener != nil {
+		listener.Close()
+		listener = nil
+		os.Remove(socketPath())
+	}
+}
+
+func serve(conn net.Conn) {
+	defer conn.Close()
+
+	var req Request
+	var res Response
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	err := json.NewDecoder(reader).Decode(&req)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		res.Error = "invalid request: " + err.Error()
+	} else {
+		handlersLock.RLock()
+		h, ok := handlers[req.Command]
+		sh, stream := streamHandlers[req.Command]
+		handlersLock.RUnlock()
+
+		if stream {
+			serveStream(conn, reader, sh, req.Args)
+			return
+		}
+
+		if !ok {
+			res.Error = "unknown command " + req.Command
+		} else {
+			data, err := h(req.Args)
+			if err != nil {
+				res.Error = err.Error()
+			} else {
+				res.Data, _ = json.Marshal(data)
+			}
+		}
+	}
+
+	json.NewEncoder(conn).Encode(&res)
+}
+
+// serveStream sends a Response for every result of a stream handler,
+// and a last one with the error that ended it
+func serveStream(conn net.Conn, reader *bufio.Reader, h StreamHandler, args map[string]string) {
+	// the CLI sends nothing else, reading fails when it disconnects
+	done := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, reader)
+		close(done)
+	}()
+
+	enc := json.NewEncoder(conn)
+	err := h(args, func(data interface{}) error {
+		res := Response{}
+		res.Data, _ = json.Marshal(data)
+		return enc.Encode(&res)
+	}, done)
+	if err != nil {
+		enc.Encode(&Response{Error: err.Error()})
+	}
+}
+
+// Running reports whether a server is listening on the control socket
+func Running() bool {
+	conn, err := net.DialTimeout("unix", socketPath(), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Call runs a command on the running server, decoding its result into out
+func Call(command string, args map[string]string, out interface{}) error {
+	conn, err := net.DialTimeout("unix", socketPath(), 2*time.Second)
+	if err != nil {
+		return errors.New("cannot connect to the running server: " + err.Error())
+	}
+	defer conn.Close()
+
+	err = json.NewEncoder(conn).Encode(&Request{Command: command, Args: args})
+	if err != nil {
+		return err
+	}
+
+	var res Response
+	err = json.NewDecoder(conn).Decode(&res)
+	if err != nil {
+		return err
+	}
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	if out != nil && res.Data != nil {
+		return json.Unmarshal(res.Data, out)
+	}
+	return nil
+}
+
+// Stream runs a streaming command on the running server, calling f with
+// every result until f or the command fail
+func Stream(command string, args map[string]string, f func(data json.RawMessage) error) error {
+	conn, err := net.DialTimeout("unix", socketPath(), 2*time.Second)
+	if err != nil {
+		return errors.New("cannot connect to the running server: " + err.Error())
+	}
+	defer conn.Close()
+
+	err = json.NewEncoder(conn).Encode(&Request{Command: command, Args: args})
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(conn)
+	for {
+		var res Response
+		err = dec.Decode(&res)
+		if err != nil {
+			return err
+		}
+		if res.Error != "" {
+			return errors.New(res.Error)
+		}
+		err = f(res.Data)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package dashboard
+
+// The web dashboard is embedded in the binary: a single page that polls
+// the API every few seconds
+
+const loginHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>GoHole</title>
+<link rel="stylesheet" href="/app.css">
+</head>
+<body class="login">
+<form method="POST" action="/login">
+<h1>GoHole</h1>
+{{if .}}<p class="error">{{.}}</p>{{end}}
+<input type="password" name="password" placeholder="Password" autofocus required>
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`
+
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>GoHole</title>
+<link rel="stylesheet" href="/app.css">
+<script src="/app.js" defer></script>
+</head>
+<body>
+<header>
+<h1>GoHole</h1>
+<form method="POST" action="/logout"><button type="submit">Log out</button></form>
+</header>
+<main>
+<section class="cards">
+<div class="card"><span id="total">-</span>Queries</div>
+<div class="card blocked"><span id="blocked">-</span>Blocked</div>
+<div class="card"><span id="ratio">-</span>Blocked ratio</div>
+<div class="card"><span id="hitratio">-</span>Cache hit ratio</div>
+<div class="card"><span id="cached">-</span>Domains in cache</div>
+</section>
+<section>
+<h2>Last 24 hours</h2>
+<canvas id="history" width="960" height="180"></canvas>
+<p class="legend"><i class="total"></i>Queries <i class="blocked"></i>Blocked</p>
+</section>
+<section class="columns">
+<div>
+<h2>Top domains</h2>
+<table id="domains"><thead><tr><th>Domain</th><th>Queries</th><th></th></tr></thead><tbody></tbody></table>
+</div>
+<div>
+<h2>Top clients</h2>
+<table id="clients"><thead><tr><th>Client</th><th>Queries</th></tr></thead><tbody></tbody></table>
+</div>
+</section>
+<section>
+<h2>Query log</h2>
+<input id="filter" type="search" placeholder="Filter by domain">
+<table id="queries"><thead><tr><th>Time</th><th>Client</th><th>Domain</th><th>Status</th><th></th></tr></thead><tbody></tbody></table>
+</section>
+</main>
+<div id="message"></div>
+</body>
+</html>
+`
+
+const appCSS = `
+body { font-family: sans-serif; margin: 0; background: #f4f5f7; color: #222; }
+header { display: flex; justify-content: space-between; align-items: center; background: #222; color: #fff; padding: 0 1em; }
+header h1 { font-size: 1.3em; }
+main { padding: 1em; max-width: 1100px; margin: auto; }
+section { background: #fff; border-radius: 4px; padding: 1em; margin-bottom: 1em; }
+h2 { font-size: 1.1em; margin-top: 0; }
+.cards { display: flex; flex-wrap: wrap; gap: 1em; background: none; padding: 0; }
+.card { flex: 1; min-width: 140px; background: #fff; border-radius: 4px; padding: 1em; color: #666; }
+.card span { display: block; font-size: 1.8em; color: #222; }
+.card.blocked span { color: #c0392b; }
+.columns { display: flex; flex-wrap: wrap; gap: 2em; }
+.columns > div { flex: 1; min-width: 300px; }
+canvas { width: 100%; height: 180px; }
+.legend i { display: inline-block; width: 1em; height: 1em; margin: 0 .3em 0 1em; vertical-align: middle; }
+.legend i.total { background: #9aa5b1; }
+.legend i.blocked { background: #c0392b; }
+table { width: 100%; border-collapse: collapse; }
+th, td { text-align: left; padding: .3em; border-bottom: 1px solid #eee; word-break: break-all; }
+td.status-blocked { color: #c0392b; }
+button { cursor: pointer; border: 1px solid #ccc; background: #fff; border-radius: 3px; padding: .2em .6em; }
+header button { background: none; color: #fff; border-color: #666; }
+#filter { width: 100%; padding: .4em; margin-bottom: .5em; box-sizing: border-box; }
+#message { position: fixed; bottom: 1em; right: 1em; background: #222; color: #fff; padding: .6em 1em; border-radius: 4px; display: none; }
+body.login { display: flex; justify-content: center; align-items: center; height: 100vh; }
+body.login form { background: #fff; padding: 2em; border-radius: 4px; display: flex; flex-direction: column; gap: 1em; }
+.error { color: #c0392b; margin: 0; }
+`
+
+const appJS = `
+"use strict";
+
+function api(method, path, body) {
+	var opts = {method: method, credentials: "same-origin", headers: {"X-GoHole-Dashboard": "1"}};
+	if (body) {
+		opts.headers["Content-Type"] = "application/json";
+		opts.body = JSON.stringify(body);
+	}
+	return fetch("/api/v1/" + path, opts).then(function (r) {
+		if (r.status === 401) {
+			location.href = "/login";
+			throw new Error("not logged in");
+		}
+		if (r.status === 204) {
+			return null;
+		}
+		return r.json().then(function (data) {
+			if (!r.ok) {
+				throw new Error(data.Error);
+			}
+			return data;
+		});
+	});
+}
+
+function show(text) {
+	var m = document.getElementById("message");
+	m.textContent = text;
+	m.style.display = "block";
+	setTimeout(function () { m.style.display = "none"; }, 3000);
+}
+
+// cell builds a table cell, always as text: the domains come from the clients
+function cell(row, text, className) {
+	var td = row.insertCell();
+	td.textContent = text;
+	if (className) {
+		td.className = className;
+	}
+	return td;
+}
+
+function button(row, label, action) {
+	var b = document.createElement("button");
+	b.textContent = label;
+	b.onclick = action;
+	row.insertCell().appendChild(b);
+}
+
+function block(domain) {
+	api("POST", "blocked", {Domain: domain}).then(function () {
+		show(domain + " blocked");
+		refresh();
+	}).catch(function (e) { show(e.message); });
+}
+
+function allow(domain) {
+	api("POST", "allowed", {Domain: domain}).then(function () {
+		show(domain + " allowed");
+		refresh();
+	}).catch(function (e) { show(e.message); });
+}
+
+function fill(id, rows, add) {
+	var body = document.querySelector("#" + id + " tbody");
+	body.innerHTML = "";
+	rows.forEach(function (r) { add(body.insertRow(), r); });
+}
+
+function status(q) {
+	if (q.Blocked) { return "Blocked"; }
+	if (q.Rewritten) { return "Rewritten"; }
+	if (q.Cached) { return "Cached"; }
+	return "Forwarded";
+}
+
+function drawHistory(points) {
+	var canvas = document.getElementById("history");
+	var ctx = canvas.getContext("2d");
+	var w = canvas.width, h = canvas.height - 20;
+	ctx.clearRect(0, 0, canvas.width, canvas.height);
+	var max = 1;
+	points.forEach(function (p) { max = Math.max(max, p.Total); });
+	var bar = w / points.length;
+	points.forEach(function (p, i) {
+		var th = p.Total / max * h, bh = p.Blocked / max * h;
+		ctx.fillStyle = "#9aa5b1";
+		ctx.fillRect(i * bar, h - th, bar - 1, th);
+		ctx.fillStyle = "#c0392b";
+		ctx.fillRect(i * bar, h - bh, bar - 1, bh);
+		var t = new Date(p.Time);
+		if (t.getMinutes() === 0 && t.getHours() % 4 === 0) {
+			ctx.fillStyle = "#666";
+			ctx.fillText(t.getHours() + ":00", i * bar, canvas.height - 5);
+		}
+	});
+}
+
+function refresh() {
+	api("GET", "stats").then(function (s) {
+		var q = s.Queries;
+		document.getElementById("total").textContent = q.Total;
+		document.getElementById("blocked").textContent = q.Blocked;
+		document.getElementById("ratio").textContent = q.Total ? (q.Blocked / q.Total * 100).toFixed(1) + "%" : "-";
+		document.getElementById("hitratio").textContent = (s.Cache.HitRatio * 100).toFixed(1) + "%";
+		document.getElementById("cached").textContent = s.Cache.Entries;
+	}).catch(function () {});
+	api("GET", "stats/history").then(drawHistory).catch(function () {});
+	api("GET", "domains/top?limit=10").then(function (domains) {
+		fill("domains", domains, function (row, d) {
+			cell(row, d.Domain);
+			cell(row, d.Queries);
+			button(row, "Block", function () { block(d.Domain); });
+		});
+	}).catch(function () {});
+	api("GET", "clients").then(function (clients) {
+		fill("clients", clients.slice(0, 10), function (row, c) {
+			cell(row, c.ClientIp);
+			cell(row, c.Queries);
+		});
+	}).catch(function () {});
+	var filter = document.getElementById("filter").value.trim();
+	api("GET", "queries?limit=50&domain=" + encodeURIComponent(filter)).then(function (queries) {
+		fill("queries", queries, function (row, q) {
+			cell(row, new Date(q.Timestamp).toLocaleTimeString());
+			cell(row, q.ClientIp);
+			cell(row, q.Domain);
+			cell(row, status(q), "status-" + status(q).toLowerCase());
+			if (q.Blocked) {
+				button(row, "Allow", function () { allow(q.Domain); });
+			} else {
+				button(row, "Block", function () { block(q.Domain); });
+			}
+		});
+	}).catch(function () {});
+}
+
+document.getElementById("filter").oninput = refresh;
+refresh();
+setInterval(refresh, 5000);
+`
@@ -0,0 +1,199 @@
+package dashboard
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"GoHole/api"
+	"GoHole/config"
+)
+
+// login sessions expire after a day
+const (
+	sessionCookie   = "gohole_session"
+	sessionDuration = 24 * time.Hour
+)
+
+// header the dashboard sends in the requests that change something, a
+// page of another site can not send it (CSRF protection)
+const dashboardHeader = "X-GoHole-Dashboard"
+
+var sessions = map[string]time.Time{}
+var sessionsLock sync.Mutex
+
+var server *http.Server = nil
+var serverLock sync.Mutex
+
+var loginTemplate = template.Must(template.New("login").Parse(loginHTML))
+
+// Handler returns the HTTP handler of the dashboard: the web page, the
+// login and the API used by the page, authorized by the login session
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/", api.NewHandler(authorized))
+	mux.HandleFunc("/login", login)
+	mux.HandleFunc("/logout", logout)
+	mux.HandleFunc("/app.js", asset("application/javascript", appJS))
+	mux.HandleFunc("/app.css", asset("text/css", appCSS))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if !validSession(r) {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		asset("text/html; charset=utf-8", indexHTML)(w, r)
+	})
+	return secureHeaders(mux)
+}
+
+// ListenAndServe serves the dashboard on ServerIP (go.hole) until Close
+// is called, it does nothing if the dashboard is disabled
+func ListenAndServe() {
+	cfg := config.GetInstance()
+	if !cfg.Dashboard {
+		return
+	}
+	port := cfg.DashboardPort
+	if port == "" {
+		port = "80"
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(cfg.ServerIP, port))
+	if err != nil {
+		log.Printf("Failed to start web dashboard: %s\n", err)
+		return
+	}
+
+	serverLock.Lock()
+	server = &http.Server{Handler: Handler()}
+	s := server
+	serverLock.Unlock()
+
+	log.Printf("Web dashboard at http://go.hole:%s/ (%s)\n", port, l.Addr())
+	err = s.Serve(l)
+	if err != nil && err != http.ErrServerClosed {
+		log.Printf("Web dashboard stopped: %s\n", err)
+	}
+}
+
+// Close stops the dashboard
+func Close() {
+	serverLock.Lock()
+	defer serverLock.Unlock()
+	if server != nil {
+		server.Close()
+		server = nil
+	}
+}
+
+func login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		loginTemplate.Execute(w, "")
+		return
+	}
+
+	password := config.GetInstance().DashboardPassword
+	sent := r.PostFormValue("password")
+	if password == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(password)) != 1 {
+		log.Printf("Failed dashboard login from %s\n", r.RemoteAddr)
+		time.Sleep(time.Second) // slow down password guessing
+		w.WriteHeader(http.StatusUnauthorized)
+		loginTemplate.Execute(w, "Wrong password")
+		return
+	}
+
+	token, err := newSession()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionDuration / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		sessionsLock.Lock()
+		delete(sessions, c.Value)
+		sessionsLock.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func newSession() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	now := time.Now()
+	for t, expires := range sessions {
+		if now.After(expires) {
+			delete(sessions, t)
+		}
+	}
+	sessions[token] = now.Add(sessionDuration)
+	return token, nil
+}
+
+func validSession(r *http.Request) bool {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+	expires, ok := sessions[c.Value]
+	return ok && time.Now().Before(expires)
+}
+
+// authorized lets the logged in dashboard use the API, the requests
+// that change something must come from the dashboard page
+func authorized(r *http.Request) bool {
+	if r.Method != "GET" && r.Header.Get(dashboardHeader) == "" {
+		return false
+	}
+	return validSession(r)
+}
+
+func asset(contentType, content string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(content))
+	}
+}
+
+func secureHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "no-store")
+		h.ServeHTTP(w, r)
+	})
+}
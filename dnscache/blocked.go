@@ -0,0 +1,74 @@
+package dnscache
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"GoHole/config"
+)
+
+// BlockedFile returns the file the domains blocked with -ad, -ab and the
+// API are saved to, so they are kept across restarts and can be edited
+// while the server is not running
+func BlockedFile() string {
+	return config.HomeFile(config.GetInstance().BlockedDomainsFile, "gohole.blocked")
+}
+
+// SaveBlocked writes the blocked domains in cache to BlockedFile, one
+// "domain ipv4 ipv6" line per domain
+func SaveBlocked() (int, error) {
+	domains := GetBlockedDomains()
+	var b strings.Builder
+	for _, e := range domains {
+		fmt.Fprintf(&b, "%s %s %s\n", e.Domain, orDash(e.IPv4), orDash(e.IPv6))
+	}
+	return len(domains), config.WriteFile(BlockedFile(), []byte(b.String()))
+}
+
+// LoadBlocked adds the domains saved in BlockedFile to the cache
+func LoadBlocked() (int, error) {
+	file, err := os.Open(BlockedFile())
+	if os.IsNotExist(err) {
+		return 0, nil // nothing blocked yet
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	loaded := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[1] != "-" {
+			AddDomainIPv4(fields[0], fields[1], false)
+		}
+		if fields[2] != "-" {
+			AddDomainIPv6(fields[0], fields[2], false)
+		}
+		loaded++
+	}
+	return loaded, scanner.Err()
+}
+
+// RemoveBlocked deletes BlockedFile, e.g. when the cache is flushed
+// while the server is not running
+func RemoveBlocked() error {
+	err := os.Remove(BlockedFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
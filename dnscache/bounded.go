@@ -0,0 +1,341 @@
+package dnscache
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// approximate memory used by an entry besides its key and value
+// (map bucket, heap slot, entry struct and string headers)
+const entryOverhead = 96
+
+// clock returns the current time, the tests replace it
+var clock = time.Now
+
+// Policy chooses the entries evicted when the cache is full
+type Policy int
+
+const (
+	LRU Policy = iota // least recently used
+	LFU               // least frequently used, the least recently used on ties
+)
+
+func (p Policy) String() string {
+	if p == LFU {
+		return "lfu"
+	}
+	return "lru"
+}
+
+type entry struct {
+	key     string
+	value   string
+	expires time.Time // zero for entries that never expire
+	hits    int
+	used    time.Time
+	index   int // position in the eviction heap, -1 if pinned
+}
+
+func (e *entry) size() int {
+	return len(e.key) + len(e.value) + entryOverhead
+}
+
+// evictionHeap keeps the next entry to evict on top
+type evictionHeap struct {
+	entries []*entry
+	policy  Policy
+}
+
+func (h *evictionHeap) Len() int { return len(h.entries) }
+
+func (h *evictionHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	if h.policy == LFU && a.hits != b.hits {
+		return a.hits < b.hits
+	}
+	return a.used.Before(b.used)
+}
+
+func (h *evictionHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *evictionHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *evictionHeap) Pop() interface{} {
+	n := len(h.entries)
+	e := h.entries[n-1]
+	h.entries[n-1] = nil
+	h.entries = h.entries[0 : n-1]
+	e.index = -1
+	return e
+}
+
+// Stats of the cache occupancy and efficiency
+type Stats struct {
+	Entries     int // expiring entries (resolved domains)
+	Bytes       int // approximate memory used by the expiring entries
+	MaxEntries  int
+	MaxBytes    int
+	Pinned      int // entries that never expire (blocked domains)
+	PinnedBytes int
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Rejected    uint64 // entries not added because the pinned entries fill MaxBytes
+	HitRatio    float64
+	Policy      string
+}
+
+// Cache is a cache of strings bounded by a number of entries and an
+// approximate size in bytes. Entries without expiration (blocked
+// domains) are pinned: they are never evicted and don't count for the
+// entries limit, but they do count for the bytes limit. When the pinned
+// entries alone fill it, new entries are rejected.
+type Cache struct {
+	lock        sync.Mutex
+	entries     map[string]*entry
+	heap        evictionHeap
+	ttl         time.Duration
+	maxEntries  int
+	maxBytes    int
+	bytes       int
+	pinned      int
+	pinnedBytes int
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	rejected    uint64
+}
+
+// NewCache creates a cache whose entries expire after ttl, purging the
+// expired entries every purge interval (if not 0)
+func NewCache(ttl, purge time.Duration, maxEntries, maxBytes int, policy Policy) *Cache {
+	c := &Cache{
+		entries:    map[string]*entry{},
+		heap:       evictionHeap{policy: policy},
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+	}
+	if purge > 0 {
+		go func() {
+			for {
+				time.Sleep(purge)
+				c.DeleteExpired()
+			}
+		}()
+	}
+	return c
+}
+
+// Set adds or replaces an entry, evicting other entries if the cache is full
+func (c *Cache) Set(key, value string, expires bool) {
+	if expires {
+		c.SetUntil(key, value, clock().Add(c.ttl))
+	} else {
+		c.SetUntil(key, value, time.Time{})
+	}
+}
+
+// SetUntil is like Set with the expiration time of the entry,
+// zero if it never expires
+func (c *Cache) SetUntil(key, value string, expires time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e := &entry{key: key, value: value, expires: expires, used: clock(), index: -1}
+	if old, ok := c.entries[key]; ok {
+		e.hits = old.hits
+		c.remove(old)
+	}
+
+	pinned := expires.IsZero()
+	for c.heap.Len() > 0 && c.full(e.size(), pinned) {
+		c.remove(c.heap.entries[0])
+		c.evictions += 1
+	}
+	if c.full(e.size(), pinned) {
+		c.rejected += 1
+		return
+	}
+
+	c.entries[key] = e
+	if pinned {
+		c.pinned += 1
+		c.pinnedBytes += e.size()
+		return
+	}
+	heap.Push(&c.heap, e)
+	c.bytes += e.size()
+}
+
+// SetLimits changes the time new entries expire after, the size limits
+// and the eviction policy, evicting entries if the cache is too big now
+func (c *Cache) SetLimits(ttl time.Duration, maxEntries, maxBytes int, policy Policy) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.ttl = ttl
+	c.maxEntries = maxEntries
+	c.maxBytes = maxBytes
+	if c.heap.policy != policy {
+		c.heap.policy = policy
+		heap.Init(&c.heap)
+	}
+	for c.heap.Len() > 0 && (c.heap.Len() > maxEntries && maxEntries > 0 || c.bytes+c.pinnedBytes > maxBytes && maxBytes > 0) {
+		c.remove(c.heap.entries[0])
+		c.evictions += 1
+	}
+}
+
+// full reports whether an entry of size bytes does not fit in the
+// cache, pinned entries only count for the bytes limit
+func (c *Cache) full(size int, pinned bool) bool {
+	if c.bytes+c.pinnedBytes+size > c.maxBytes && c.maxBytes > 0 {
+		return true
+	}
+	return !pinned && c.heap.Len()+1 > c.maxEntries && c.maxEntries > 0
+}
+
+func (c *Cache) remove(e *entry) {
+	delete(c.entries, e.key)
+	if e.index < 0 {
+		c.pinned -= 1
+		c.pinnedBytes -= e.size()
+		return
+	}
+	heap.Remove(&c.heap, e.index)
+	c.bytes -= e.size()
+}
+
+// lookup returns the entry if it exists and has not expired
+func (c *Cache) lookup(key string, now time.Time) (*entry, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !e.expires.IsZero() && now.After(e.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	return e, true
+}
+
+// Get returns the value of an entry and its expiration (zero if it
+// never expires), counting the hit or miss
+func (c *Cache) Get(key string) (string, time.Time, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := clock()
+	e, ok := c.lookup(key, now)
+	if !ok {
+		c.misses += 1
+		return "", time.Time{}, false
+	}
+	c.hits += 1
+	e.hits += 1
+	e.used = now
+	if e.index >= 0 {
+		heap.Fix(&c.heap, e.index)
+	}
+	return e.value, e.expires, true
+}
+
+// Peek is like Get but it does not count as a use of the entry
+func (c *Cache) Peek(key string) (string, time.Time, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.lookup(key, clock())
+	if !ok {
+		return "", time.Time{}, false
+	}
+	return e.value, e.expires, true
+}
+
+// Delete removes an entry
+func (c *Cache) Delete(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+}
+
+// DeleteExpired removes the expired entries
+func (c *Cache) DeleteExpired() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := clock()
+	for _, e := range c.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			c.remove(e)
+		}
+	}
+}
+
+// Item is a cache entry, Expires is zero if it never expires
+type Item struct {
+	Value   string
+	Expires time.Time
+}
+
+// Items returns the entries that have not expired
+func (c *Cache) Items() map[string]Item {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := clock()
+	items := map[string]Item{}
+	for key, e := range c.entries {
+		if e.expires.IsZero() || now.Before(e.expires) {
+			items[key] = Item{Value: e.value, Expires: e.expires}
+		}
+	}
+	return items
+}
+
+// Flush removes all the entries
+func (c *Cache) Flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = map[string]*entry{}
+	c.heap.entries = nil
+	c.bytes, c.pinned, c.pinnedBytes = 0, 0, 0
+}
+
+// Stats returns the occupancy, hits and evictions of the cache
+func (c *Cache) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s := Stats{
+		Entries:     c.heap.Len(),
+		Bytes:       c.bytes,
+		MaxEntries:  c.maxEntries,
+		MaxBytes:    c.maxBytes,
+		Pinned:      c.pinned,
+		PinnedBytes: c.pinnedBytes,
+		Hits:        c.hits,
+		Misses:      c.misses,
+		Evictions:   c.evictions,
+		Rejected:    c.rejected,
+		Policy:      c.heap.policy.String(),
+	}
+	if c.hits+c.misses > 0 {
+		s.HitRatio = float64(c.hits) / float64(c.hits+c.misses)
+	}
+	return s
+}
@@ -0,0 +1,166 @@
+package dnscache
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// every entry of the tests uses a 2 bytes key and a 1 byte value
+const testEntrySize = 3 + entryOverhead
+
+// fakeClock makes clock advance a millisecond on every call, so the
+// uses of the entries are always ordered
+func fakeClock(t *testing.T) *time.Time {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	clock = func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}
+	t.Cleanup(func() { clock = time.Now })
+	return &now
+}
+
+// run applies the operations of a test to the cache: "+k" sets k,
+// "!k" pins k and "k" gets k
+func run(c *Cache, ops string) {
+	for _, op := range strings.Fields(ops) {
+		switch op[0] {
+		case '+':
+			c.Set(op[1:], "v", true)
+		case '!':
+			c.Set(op[1:], "v", false)
+		default:
+			c.Get(op)
+		}
+	}
+}
+
+func keys(c *Cache) []string {
+	list := []string{}
+	for key := range c.Items() {
+		list = append(list, key)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func TestCacheEviction(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     Policy
+		maxEntries int
+		maxBytes   int
+		ops        string
+		want       string // keys left
+		evictions  uint64
+		rejected   uint64
+	}{
+		{"lru evicts the least recently used", LRU, 3, 0, "+k1 +k2 +k3 k1 +k4", "k1 k3 k4", 1, 0},
+		{"lru counts the sets as uses", LRU, 3, 0, "+k1 +k2 +k3 +k1 +k4", "k1 k3 k4", 1, 0},
+		{"lfu evicts the least frequently used", LFU, 3, 0, "+k1 +k2 +k3 k1 k1 k2 k3 k2 +k4", "k1 k2 k4", 1, 0},
+		{"lfu ties evict the least recently used", LFU, 3, 0, "+k1 +k2 +k3 k2 k1 k3 +k4", "k1 k3 k4", 1, 0},
+		{"lfu keeps the hits when replaced", LFU, 2, 0, "+k1 k1 +k1 +k2 +k3", "k1 k3", 1, 0},
+		{"entries limit", LRU, 2, 0, "+k1 +k2 +k3 +k4", "k3 k4", 2, 0},
+		{"bytes limit", LRU, 0, 3 * testEntrySize, "+k1 +k2 +k3 +k4 +k5", "k3 k4 k5", 2, 0},
+		{"both limits, bytes first", LRU, 5, 2 * testEntrySize, "+k1 +k2 +k3", "k2 k3", 1, 0},
+		{"pinned entries do not count for the entries limit", LRU, 2, 0, "!p1 !p2 !p3 +k1 +k2", "k1 k2 p1 p2 p3", 0, 0},
+		{"pinned entries evict for the bytes limit", LRU, 0, 3 * testEntrySize, "+k1 +k2 +k3 !p1 !p2", "k3 p1 p2", 2, 0},
+		{"pinned entries are never evicted", LRU, 0, 2 * testEntrySize, "!p1 !p2 +k1", "p1 p2", 0, 1},
+		{"pinned entries are rejected when full", LRU, 0, 2 * testEntrySize, "!p1 +k1 !p2 !p3", "p1 p2", 1, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeClock(t)
+			c := NewCache(time.Hour, 0, test.maxEntries, test.maxBytes, test.policy)
+			run(c, test.ops)
+
+			if got := keys(c); !reflect.DeepEqual(got, strings.Fields(test.want)) {
+				t.Errorf("got keys %v, want %v", got, test.want)
+			}
+			s := c.Stats()
+			if s.Evictions != test.evictions || s.Rejected != test.rejected {
+				t.Errorf("got %d evictions and %d rejected, want %d and %d", s.Evictions, s.Rejected, test.evictions, test.rejected)
+			}
+			if test.maxBytes > 0 && s.Bytes+s.PinnedBytes > test.maxBytes {
+				t.Errorf("%d bytes used, the limit is %d", s.Bytes+s.PinnedBytes, test.maxBytes)
+			}
+		})
+	}
+}
+
+func TestCacheSetLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     Policy
+		maxEntries int
+		maxBytes   int
+		want       string
+		evictions  uint64
+	}{
+		{"same limits", LRU, 4, 0, "k1 k2 k3 k4 p1", 0},
+		{"fewer entries", LRU, 2, 0, "k1 k4 p1", 2},
+		{"fewer bytes", LRU, 0, 3 * testEntrySize, "k1 k4 p1", 2},
+		{"fewer bytes than the pinned entries", LRU, 0, testEntrySize / 2, "p1", 4},
+		{"fewer entries with lfu", LFU, 2, 0, "k1 k3 p1", 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeClock(t)
+			c := NewCache(time.Hour, 0, 4, 0, LRU)
+			run(c, "+k1 +k2 +k3 +k4 !p1 k3 k3 k2 k4 k1")
+			c.SetLimits(time.Hour, test.maxEntries, test.maxBytes, test.policy)
+
+			if got := keys(c); !reflect.DeepEqual(got, strings.Fields(test.want)) {
+				t.Errorf("got keys %v, want %v", got, test.want)
+			}
+			s := c.Stats()
+			if s.Evictions != test.evictions {
+				t.Errorf("got %d evictions, want %d", s.Evictions, test.evictions)
+			}
+			if s.MaxEntries != test.maxEntries || s.MaxBytes != test.maxBytes || s.Policy != test.policy.String() {
+				t.Errorf("got limits %d %d %s", s.MaxEntries, s.MaxBytes, s.Policy)
+			}
+		})
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	now := fakeClock(t)
+	c := NewCache(time.Minute, 0, 10, 0, LRU)
+	run(c, "+k1 +k2 !p1 k1 k1 k2 p1 k3 k4")
+
+	want := Stats{
+		Entries:     2,
+		Bytes:       2 * testEntrySize,
+		MaxEntries:  10,
+		Pinned:      1,
+		PinnedBytes: testEntrySize,
+		Hits:        4,
+		Misses:      2,
+		HitRatio:    4.0 / 6.0,
+		Policy:      "lru",
+	}
+	if got := c.Stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// the expired entries are misses, the pinned ones never expire
+	*now = now.Add(2 * time.Minute)
+	run(c, "k1 p1")
+	s := c.Stats()
+	if s.Entries != 1 || s.Hits != 5 || s.Misses != 3 {
+		t.Errorf("after expiring got %d entries, %d hits and %d misses", s.Entries, s.Hits, s.Misses)
+	}
+	c.DeleteExpired()
+	if s := c.Stats(); s.Entries != 0 || s.Bytes != 0 || s.Pinned != 1 {
+		t.Errorf("after deleting the expired entries got %+v", s)
+	}
+
+	c.Flush()
+	if s := c.Stats(); s.Entries != 0 || s.Pinned != 0 || s.PinnedBytes != 0 {
+		t.Errorf("after flushing got %+v", s)
+	}
+}
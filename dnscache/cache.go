@@ -1,25 +1,81 @@
 package dnscache
 
 import (
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/patrickmn/go-cache"
 	"errors"
 	"GoHole/config"
 )
 
-var instance *cache.Cache = nil
+// cache defaults
+const (
+	defaultCacheMaxEntries = 10000
+	defaultCacheMaxBytes = 4 * 1024 * 1024
+)
+
+var instance *Cache = nil
+var instanceLock sync.Mutex // the cache is created by the first query or API request
 
-func GetInstance() *cache.Cache {
+func GetInstance() *Cache {
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
 	if instance == nil {
 		expireTime := time.Duration(config.GetInstance().DomainCacheTime)
 		purgeTime := time.Duration(config.GetInstance().DomainPurgeInterval)
-    	instance = cache.New(expireTime*time.Second, purgeTime*time.Second)
+		maxEntries, maxBytes, policy := cacheLimits()
+    	instance = NewCache(expireTime*time.Second, purgeTime*time.Second, maxEntries, maxBytes, policy)
     }
 
     return instance
 }
 
+// cacheLimits returns the size limits and the eviction policy from the
+// config. With ServeStale the limits are split between the cache and the
+// stale cache, so both together use at most CacheMaxBytes.
+func cacheLimits() (int, int, Policy) {
+	maxEntries := config.GetInstance().CacheMaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	maxBytes := config.GetInstance().CacheMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheMaxBytes
+	}
+	if config.GetInstance().ServeStale {
+		maxEntries, maxBytes = (maxEntries+1)/2, (maxBytes+1)/2
+	}
+	policy := LRU
+	if config.GetInstance().CachePolicy == "lfu" {
+		policy = LFU
+	}
+	return maxEntries, maxBytes, policy
+}
+
+// Configure applies the cache time and limits of the config to the
+// cache, e.g. after it is reloaded
+func Configure() {
+	expireTime := time.Duration(config.GetInstance().DomainCacheTime)
+	maxEntries, maxBytes, policy := cacheLimits()
+	GetInstance().SetLimits(expireTime*time.Second, maxEntries, maxBytes, policy)
+	if stale := existingStale(); stale != nil {
+		stale.SetLimits(expireTime*time.Second+staleWindow(), maxEntries, maxBytes, policy)
+		if !config.GetInstance().ServeStale {
+			stale.Flush()
+		}
+	}
+	if !config.GetInstance().Prefetch {
+		flushHits()
+	}
+}
+
+// GetStats returns the occupancy, hit ratio and evictions of the cache
+func GetStats() Stats {
+	return GetInstance().Stats()
+}
+
 func IPv4Preffix() string{
 	return "ipv4:"
 }
@@ -29,35 +85,40 @@ func IPv6Preffix() string{
 
 
 func AddDomainIPv4(domain, ip string, expires bool) {
+	resetHits(IPv4Preffix() + domain)
+	GetInstance().Set(IPv4Preffix() + domain, ip, expires)
 	if expires {
-		GetInstance().Set(IPv4Preffix() + domain, ip, cache.DefaultExpiration)
-	} else {
-		GetInstance().Set(IPv4Preffix() + domain, ip, cache.NoExpiration)
+		addStale(IPv4Preffix() + domain, ip)
 	}
 }
 
 func AddDomainIPv6(domain, ip string, expires bool) {
+	resetHits(IPv6Preffix() + domain)
+	GetInstance().Set(IPv6Preffix() + domain, ip, expires)
 	if expires {
-		GetInstance().Set(IPv6Preffix()+domain, ip, cache.DefaultExpiration)
-	} else {
-		GetInstance().Set(IPv6Preffix()+domain, ip, cache.NoExpiration)
+		addStale(IPv6Preffix() + domain, ip)
 	}
 }
 
 func DeleteDomainIPv4(domain string) (error){
 	GetInstance().Delete(IPv4Preffix() + domain)
+	deleteStale(IPv4Preffix() + domain)
 	return nil
 }
 
 func DeleteDomainIPv6(domain string) (error){
 	GetInstance().Delete(IPv6Preffix() + domain)
+	deleteStale(IPv6Preffix() + domain)
 	return nil
 }
 
 func GetDomainIPv4(domain string) (string, bool, error){
-	ip, exp, found := GetInstance().GetWithExpiration(IPv4Preffix() + domain)
+	ip, exp, found := GetInstance().Get(IPv4Preffix() + domain)
 	if found == true {
-		return ip.(string), exp.IsZero(), nil
+		if !exp.IsZero() {
+			recordHit(IPv4Preffix() + domain)
+		}
+		return ip, exp.IsZero(), nil
 	} else {
 		return "", false, errors.New("domain " + domain + " not found")
 	}
@@ -66,9 +127,12 @@ func GetDomainIPv4(domain string) (string, bool, error){
 
 
 func GetDomainIPv6(domain string) (string, bool, error){
-	ip, exp, found := GetInstance().GetWithExpiration(IPv6Preffix() + domain)
+	ip, exp, found := GetInstance().Get(IPv6Preffix() + domain)
 	if found == true {
-		return ip.(string), exp.IsZero(), nil
+		if !exp.IsZero() {
+			recordHit(IPv6Preffix() + domain)
+		}
+		return ip, exp.IsZero(), nil
 	} else {
 		return "", false, errors.New("domain " + domain + " not found")
 	}
@@ -76,5 +140,91 @@ func GetDomainIPv6(domain string) (string, bool, error){
 
 func Flush() {
 	GetInstance().Flush()
+	flushHits()
+	flushStale()
 }
 
+// Entry is a domain in cache with its IPs
+type Entry struct {
+	Domain  string
+	IPv4    string
+	IPv6    string
+	Blocked bool      // blocked domains never expire
+	Expires time.Time // earliest expiration of the IPs of a resolved domain
+}
+
+// GetEntry returns the cached IPs of a domain, without counting a hit
+func GetEntry(domain string) (Entry, bool) {
+	e := Entry{Domain: domain}
+	found := false
+	for _, prefix := range []string{IPv4Preffix(), IPv6Preffix()} {
+		ip, exp, ok := GetInstance().Peek(prefix + domain)
+		if !ok {
+			continue
+		}
+		found = true
+		if prefix == IPv4Preffix() {
+			e.IPv4 = ip
+		} else {
+			e.IPv6 = ip
+		}
+		e.Blocked = exp.IsZero()
+		if !exp.IsZero() && (e.Expires.IsZero() || exp.Before(e.Expires)) {
+			e.Expires = exp
+		}
+	}
+	return e, found
+}
+
+// PeekDomain returns the cached IPv4 (or IPv6) of a domain and when it
+// expires (zero for blocked domains), without counting a hit
+func PeekDomain(domain string, ipv6 bool) (string, time.Time, bool) {
+	prefix := IPv4Preffix()
+	if ipv6 {
+		prefix = IPv6Preffix()
+	}
+	return GetInstance().Peek(prefix + domain)
+}
+
+// GetBlockedDomains returns the domains blocked in cache (by -ad or the
+// blacklist files), sorted by name
+func GetBlockedDomains() []Entry {
+	domains := map[string]*Entry{}
+	for key, item := range GetInstance().Items() {
+		if !item.Expires.IsZero() {
+			continue
+		}
+		domain := strings.TrimPrefix(strings.TrimPrefix(key, IPv4Preffix()), IPv6Preffix())
+		e, ok := domains[domain]
+		if !ok {
+			e = &Entry{Domain: domain, Blocked: true}
+			domains[domain] = e
+		}
+		if strings.HasPrefix(key, IPv4Preffix()) {
+			e.IPv4 = item.Value
+		} else {
+			e.IPv6 = item.Value
+		}
+	}
+
+	list := []Entry{}
+	for _, e := range domains {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Domain < list[j].Domain })
+	return list
+}
+
+// FlushResolved removes the resolved domains, keeping the blocked ones
+func FlushResolved() int {
+	removed := 0
+	for key, item := range GetInstance().Items() {
+		if !item.Expires.IsZero() {
+			GetInstance().Delete(key)
+			removed++
+		}
+	}
+	flushHits()
+	flushStale()
+	return removed
+}
@@ -0,0 +1,82 @@
+package dnscache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"GoHole/config"
+)
+
+var testDir string
+
+func TestMain(m *testing.M) {
+	var err error
+	testDir, err = ioutil.TempDir("", "gohole-dnscache")
+	if err != nil {
+		panic(err)
+	}
+	cfg := `{
+		"BlockedDomainsFile": "` + testDir + `/gohole.blocked"
+	}`
+	path := filepath.Join(testDir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(cfg), 0600); err != nil {
+		panic(err)
+	}
+	config.CreateInstance(path)
+
+	code := m.Run()
+	os.RemoveAll(testDir)
+	os.Exit(code)
+}
+
+// withConfig changes the config during a test
+func withConfig(t *testing.T, f func(c *config.MyConfig)) {
+	old := config.GetInstance()
+	c := *old
+	f(&c)
+	config.Swap(&c)
+	t.Cleanup(func() { config.Swap(old) })
+}
+
+func TestCacheLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		serveStale  bool
+		maxEntries  int
+		maxBytes    int
+		wantEntries int
+		wantBytes   int
+	}{
+		{"configured", false, 100, 10000, 100, 10000},
+		{"defaults", false, 0, 0, defaultCacheMaxEntries, defaultCacheMaxBytes},
+		{"halved with serve stale", true, 100, 10000, 50, 5000},
+		{"odd limits rounded up", true, 101, 10001, 51, 5001},
+		{"defaults halved with serve stale", true, 0, 0, defaultCacheMaxEntries / 2, defaultCacheMaxBytes / 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withConfig(t, func(c *config.MyConfig) {
+				c.ServeStale = test.serveStale
+				c.CacheMaxEntries, c.CacheMaxBytes = test.maxEntries, test.maxBytes
+			})
+			Configure()
+			addStale("ipv4:example.com", "192.0.2.1") // creates the stale cache
+
+			entries, bytes, _ := cacheLimits()
+			if entries != test.wantEntries || bytes != test.wantBytes {
+				t.Errorf("got limits %d and %d, want %d and %d", entries, bytes, test.wantEntries, test.wantBytes)
+			}
+			if s := GetStats(); s.MaxEntries != test.wantEntries || s.MaxBytes != test.wantBytes {
+				t.Errorf("the cache got limits %d and %d", s.MaxEntries, s.MaxBytes)
+			}
+			if test.serveStale {
+				s := getStaleInstance().Stats()
+				if s.MaxEntries != test.wantEntries || s.MaxBytes != test.wantBytes {
+					t.Errorf("the stale cache got limits %d and %d", s.MaxEntries, s.MaxBytes)
+				}
+			}
+		})
+	}
+}
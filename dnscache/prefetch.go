@@ -0,0 +1,72 @@
+package dnscache
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"GoHole/config"
+)
+
+// Candidate is a cached domain that should be refreshed before it expires
+type Candidate struct {
+	Domain string
+	IPv6   bool
+}
+
+// hits of the expiring entries since they were (re)cached, so only
+// domains used during the current cache time are prefetched. They are
+// only counted with Prefetch on, PrefetchCandidates prunes the entries
+// no longer cached.
+var hits = map[string]int{}
+var hitsLock sync.Mutex
+
+func recordHit(key string) {
+	if !config.GetInstance().Prefetch {
+		return
+	}
+	hitsLock.Lock()
+	defer hitsLock.Unlock()
+	hits[key] += 1
+}
+
+func resetHits(key string) {
+	hitsLock.Lock()
+	defer hitsLock.Unlock()
+	delete(hits, key)
+}
+
+func flushHits() {
+	hitsLock.Lock()
+	defer hitsLock.Unlock()
+	hits = map[string]int{}
+}
+
+// PrefetchCandidates returns the entries that expire within before and
+// have been hit at least minHits times since they were cached. Each
+// entry is returned once, its hits count again when it is cached again
+func PrefetchCandidates(before time.Duration, minHits int) []Candidate {
+	hitsLock.Lock()
+	defer hitsLock.Unlock()
+
+	var candidates []Candidate
+	now := time.Now()
+	for key, count := range hits {
+		_, exp, found := GetInstance().Peek(key)
+		if !found || exp.IsZero() {
+			// expired, deleted or blocked: nothing to refresh
+			delete(hits, key)
+			continue
+		}
+		if exp.Sub(now) > before || count < minHits {
+			continue
+		}
+		delete(hits, key)
+		if strings.HasPrefix(key, IPv4Preffix()) {
+			candidates = append(candidates, Candidate{Domain: strings.TrimPrefix(key, IPv4Preffix())})
+		} else {
+			candidates = append(candidates, Candidate{Domain: strings.TrimPrefix(key, IPv6Preffix()), IPv6: true})
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,62 @@
+package dnscache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"GoHole/config"
+)
+
+// snapshotEntry is a resolved domain saved to disk, with its absolute
+// expiration so the time the server was down is discounted from its TTL
+type snapshotEntry struct {
+	Key     string
+	Value   string
+	Expires time.Time
+}
+
+// SaveSnapshot writes the resolved domains in cache to path, blocked
+// domains are not saved because they are loaded from the blocklists
+func SaveSnapshot(path string) (int, error) {
+	var entries []snapshotEntry
+	for key, item := range GetInstance().Items() {
+		if !item.Expires.IsZero() {
+			entries = append(entries, snapshotEntry{Key: key, Value: item.Value, Expires: item.Expires})
+		}
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), config.WriteFile(path, data)
+}
+
+// LoadSnapshot restores the resolved domains saved in path that have not
+// expired yet, the expired ones can still be served stale
+func LoadSnapshot(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var entries []snapshotEntry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	now := clock()
+	for _, e := range entries {
+		if e.Expires.IsZero() {
+			continue
+		}
+		addStaleUntil(e.Key, e.Value, e.Expires)
+		if now.Before(e.Expires) {
+			GetInstance().SetUntil(e.Key, e.Value, e.Expires)
+			restored += 1
+		}
+	}
+	return restored, nil
+}
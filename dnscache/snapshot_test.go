@@ -0,0 +1,92 @@
+package dnscache
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	tests := []struct {
+		name     string
+		down     time.Duration // time between saving and loading the snapshot
+		restored int
+		fresh    string // domains restored to the cache
+		stale    string // domains restored to be served stale
+	}{
+		{"restart", time.Second, 2, "long.example.com short.example.com", "long.example.com short.example.com"},
+		{"short entry expired", 30 * time.Second, 1, "long.example.com", "long.example.com short.example.com"},
+		{"all expired", 2 * time.Minute, 0, "", "long.example.com short.example.com"},
+		{"after the stale window", 2 * time.Hour, 0, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := fakeClock(t)
+			staleConfig(t, true)
+			AddDomainIPv4("long.example.com", "192.0.2.1", true)
+			GetInstance().SetUntil(IPv4Preffix()+"short.example.com", "192.0.2.2", now.Add(10*time.Second))
+			addStaleUntil(IPv4Preffix()+"short.example.com", "192.0.2.2", now.Add(10*time.Second))
+			AddDomainIPv4("blocked.example.com", "0.0.0.0", false)
+			_, longExpires, _ := GetInstance().Peek(IPv4Preffix() + "long.example.com")
+
+			path := filepath.Join(testDir, "snapshot.json")
+			saved, err := SaveSnapshot(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved != 2 {
+				t.Errorf("saved %d domains, want 2 (blocked domains are not saved)", saved)
+			}
+
+			// the server restarts with empty caches
+			GetInstance().Flush()
+			flushStale()
+			*now = now.Add(test.down)
+			restored, err := LoadSnapshot(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored != test.restored {
+				t.Errorf("restored %d domains, want %d", restored, test.restored)
+			}
+
+			for _, domain := range []string{"long.example.com", "short.example.com", "blocked.example.com"} {
+				_, expires, fresh := GetInstance().Peek(IPv4Preffix() + domain)
+				if want := contains(test.fresh, domain); fresh != want {
+					t.Errorf("%s in cache: %t, want %t", domain, fresh, want)
+				}
+				if fresh && domain == "long.example.com" && !expires.Equal(longExpires) {
+					t.Errorf("%s expires at %s, want %s", domain, expires, longExpires)
+				}
+				_, err := GetStaleDomainIPv4(domain)
+				if want := contains(test.stale, domain); (err == nil) != want {
+					t.Errorf("%s stale: %t, want %t", domain, err == nil, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadSnapshotErrors(t *testing.T) {
+	invalid := filepath.Join(testDir, "invalid.json")
+	if err := ioutil.WriteFile(invalid, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{invalid, filepath.Join(testDir, "missing.json")} {
+		if _, err := LoadSnapshot(path); err == nil {
+			t.Errorf("%s: got no error", path)
+		}
+	}
+}
+
+// contains reports whether list, separated by spaces, has s
+func contains(list, s string) bool {
+	for _, l := range strings.Fields(list) {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package dnscache
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"GoHole/config"
+)
+
+// default time expired domains are kept to be served stale (RFC 8767)
+const defaultStaleWindow = 86400
+
+var staleInstance *Cache = nil
+var staleLock sync.Mutex
+
+// getStaleInstance returns the cache of the domains that can be served
+// when the upstream servers are unreachable, its entries live for the
+// cache time plus the stale window
+func getStaleInstance() *Cache {
+	staleLock.Lock()
+	defer staleLock.Unlock()
+	if staleInstance == nil {
+		expireTime := time.Duration(config.GetInstance().DomainCacheTime)*time.Second + staleWindow()
+		purgeTime := time.Duration(config.GetInstance().DomainPurgeInterval)
+		maxEntries, maxBytes, policy := cacheLimits()
+		staleInstance = NewCache(expireTime, purgeTime*time.Second, maxEntries, maxBytes, policy)
+	}
+	return staleInstance
+}
+
+// existingStale returns the stale cache, or nil if nothing was cached
+// to be served stale yet
+func existingStale() *Cache {
+	staleLock.Lock()
+	defer staleLock.Unlock()
+	return staleInstance
+}
+
+func staleWindow() time.Duration {
+	window := config.GetInstance().StaleWindow
+	if window <= 0 {
+		window = defaultStaleWindow
+	}
+	return time.Duration(window) * time.Second
+}
+
+func addStale(key, ip string) {
+	if config.GetInstance().ServeStale {
+		getStaleInstance().Set(key, ip, true)
+	}
+}
+
+// addStaleUntil keeps an entry that expires (or expired) at expires
+// to be served stale until the end of the stale window
+func addStaleUntil(key, ip string, expires time.Time) {
+	if config.GetInstance().ServeStale && clock().Before(expires.Add(staleWindow())) {
+		getStaleInstance().SetUntil(key, ip, expires.Add(staleWindow()))
+	}
+}
+
+func deleteStale(key string) {
+	if stale := existingStale(); stale != nil {
+		stale.Delete(key)
+	}
+}
+
+func getStale(key, domain string) (string, error) {
+	if config.GetInstance().ServeStale {
+		if ip, _, found := getStaleInstance().Peek(key); found {
+			return ip, nil
+		}
+	}
+	return "", errors.New("no stale entry for domain " + domain)
+}
+
+// GetStaleDomainIPv4 returns the last IPv4 cached for domain, even if it
+// expired, as long as it is within the stale window
+func GetStaleDomainIPv4(domain string) (string, error) {
+	return getStale(IPv4Preffix()+domain, domain)
+}
+
+// GetStaleDomainIPv6 returns the last IPv6 cached for domain, even if it
+// expired, as long as it is within the stale window
+func GetStaleDomainIPv6(domain string) (string, error) {
+	return getStale(IPv6Preffix()+domain, domain)
+}
+
+func flushStale() {
+	if stale := existingStale(); stale != nil {
+		stale.Flush()
+	}
+}
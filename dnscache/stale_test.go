@@ -0,0 +1,70 @@
+package dnscache
+
+import (
+	"testing"
+	"time"
+
+	"GoHole/config"
+)
+
+// staleConfig caches the domains for a minute and serves them stale for
+// an hour after
+func staleConfig(t *testing.T, serveStale bool) {
+	withConfig(t, func(c *config.MyConfig) {
+		c.ServeStale, c.DomainCacheTime, c.StaleWindow = serveStale, 60, 3600
+	})
+	Configure()
+	GetInstance().Flush()
+	flushStale()
+}
+
+func TestServeStale(t *testing.T) {
+	tests := []struct {
+		name       string
+		serveStale bool
+		blocked    bool
+		elapsed    time.Duration
+		fresh      bool // found in the cache
+		stale      bool // found to be served stale
+	}{
+		{"fresh", true, false, 30 * time.Second, true, true},
+		{"expired", true, false, 2 * time.Minute, false, true},
+		{"end of the stale window", true, false, time.Minute + time.Hour - time.Second, false, true},
+		{"after the stale window", true, false, time.Minute + time.Hour + time.Second, false, false},
+		{"serve stale off", false, false, 2 * time.Minute, false, false},
+		{"serve stale off, fresh", false, false, 30 * time.Second, true, false},
+		{"blocked domains are not stale", true, true, 2 * time.Minute, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := fakeClock(t)
+			staleConfig(t, test.serveStale)
+			AddDomainIPv4("example.com", "192.0.2.1", !test.blocked)
+			*now = now.Add(test.elapsed)
+
+			_, _, err := GetDomainIPv4("example.com")
+			if fresh := err == nil; fresh != test.fresh {
+				t.Errorf("found in cache: %t, want %t", fresh, test.fresh)
+			}
+			ip, err := GetStaleDomainIPv4("example.com")
+			if stale := err == nil; stale != test.stale || stale && ip != "192.0.2.1" {
+				t.Errorf("got stale %q (%t), want %t", ip, stale, test.stale)
+			}
+		})
+	}
+}
+
+// deleted domains are not served stale either
+func TestDeleteStale(t *testing.T) {
+	now := fakeClock(t)
+	staleConfig(t, true)
+	AddDomainIPv6("example.com", "2001:db8::1", true)
+	*now = now.Add(2 * time.Minute)
+	if _, err := GetStaleDomainIPv6("example.com"); err != nil {
+		t.Fatal(err)
+	}
+	DeleteDomainIPv6("example.com")
+	if ip, err := GetStaleDomainIPv6("example.com"); err == nil {
+		t.Errorf("got stale %s after deleting it", ip)
+	}
+}
@@ -0,0 +1,281 @@
+package dnssec
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// compareNames compares two names in DNSSEC canonical order (RFC 4034, 6.1)
+func compareNames(a, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := bytes.Compare([]byte(la[i]), []byte(lb[j])); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// covers reports whether name is between the owner and the next name of
+// an NSEC record, the last NSEC of a zone wraps to its apex
+func covers(nsec *dns.NSEC, name string) bool {
+	owner := nsec.Hdr.Name
+	if compareNames(owner, name) >= 0 {
+		return false
+	}
+	return compareNames(nsec.NextDomain, owner) <= 0 || compareNames(name, nsec.NextDomain) < 0
+}
+
+func hasType(bitmap []uint16, qtype uint16) bool {
+	for _, t := range bitmap {
+		if t == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// commonAncestor returns the longest name both a and b are below
+func commonAncestor(a, b string) string {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	n := 0
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0 && la[i] == lb[j]; i, j = i-1, j-1 {
+		n++
+	}
+	return dns.Fqdn(strings.Join(la[len(la)-n:], "."))
+}
+
+// delegation reports whether a bitmap is the one of a zone cut seen from
+// the parent zone: NS without SOA
+func delegation(bitmap []uint16) bool {
+	return hasType(bitmap, dns.TypeNS) && !hasType(bitmap, dns.TypeSOA)
+}
+
+// nodataBitmap reports whether a bitmap of the name itself denies the
+// type. The DS records live in the parent, so they are only denied by
+// the parent side of a zone cut (SOA clear), and the other types of a
+// delegation are only denied by the child zone.
+func nodataBitmap(bitmap []uint16, qtype uint16) bool {
+	if hasType(bitmap, qtype) || hasType(bitmap, dns.TypeCNAME) {
+		return false
+	}
+	if qtype == dns.TypeDS {
+		return !hasType(bitmap, dns.TypeSOA)
+	}
+	return !delegation(bitmap)
+}
+
+// denies reports whether the (already validated) NSEC/NSEC3 records of
+// zone in the authority section prove that the name (NXDOMAIN) or the
+// type (NODATA) does not exist, with the closest encloser and wildcard
+// proofs of RFC 4035 5.4 and RFC 5155 8
+func denies(zone, name string, qtype uint16, msg *dns.Msg) bool {
+	var nsecs []*dns.NSEC
+	var nsec3s []*dns.NSEC3
+	for _, rr := range msg.Ns {
+		if !dns.IsSubDomain(zone, rr.Header().Name) {
+			continue
+		}
+		switch r := rr.(type) {
+		case *dns.NSEC:
+			nsecs = append(nsecs, r)
+		case *dns.NSEC3:
+			nsec3s = append(nsec3s, r)
+		}
+	}
+
+	if msg.Rcode == dns.RcodeNameError {
+		return nxdomainNSEC(zone, name, nsecs) || nxdomainNSEC3(zone, name, nsec3s)
+	}
+	return nodataNSEC(zone, name, qtype, nsecs) || nodataNSEC3(zone, name, qtype, nsec3s)
+}
+
+// coveringNSEC returns the NSEC proving that name does not exist
+func coveringNSEC(name string, nsecs []*dns.NSEC) *dns.NSEC {
+	for _, r := range nsecs {
+		if !covers(r, name) {
+			continue
+		}
+		// a delegation above the name hides the names below it
+		if dns.IsSubDomain(r.Hdr.Name, name) && delegation(r.TypeBitMap) {
+			continue
+		}
+		return r
+	}
+	return nil
+}
+
+func matchingNSEC(name string, nsecs []*dns.NSEC) *dns.NSEC {
+	for _, r := range nsecs {
+		if compareNames(r.Hdr.Name, name) == 0 {
+			return r
+		}
+	}
+	return nil
+}
+
+// closestEncloserNSEC returns the closest existing name above name, from
+// the NSEC proving it does not exist
+func closestEncloserNSEC(zone string, cover *dns.NSEC, name string) (string, bool) {
+	ce := commonAncestor(name, cover.Hdr.Name)
+	if next := commonAncestor(name, cover.NextDomain); dns.CountLabel(next) > dns.CountLabel(ce) {
+		ce = next
+	}
+	return ce, dns.IsSubDomain(zone, ce)
+}
+
+func nxdomainNSEC(zone, name string, nsecs []*dns.NSEC) bool {
+	cover := coveringNSEC(name, nsecs)
+	if cover == nil {
+		return false
+	}
+	ce, ok := closestEncloserNSEC(zone, cover, name)
+	if !ok {
+		return false
+	}
+	// no wildcard could have answered instead
+	return coveringNSEC("*."+ce, nsecs) != nil
+}
+
+func nodataNSEC(zone, name string, qtype uint16, nsecs []*dns.NSEC) bool {
+	if r := matchingNSEC(name, nsecs); r != nil {
+		return nodataBitmap(r.TypeBitMap, qtype)
+	}
+
+	cover := coveringNSEC(name, nsecs)
+	if cover == nil {
+		return false
+	}
+	// empty non-terminal: the name has no records but names below it
+	if dns.IsSubDomain(name, cover.NextDomain) && compareNames(name, cover.NextDomain) != 0 {
+		return true
+	}
+	// the name does not exist, and the wildcard that answers it has no
+	// records of the type
+	ce, ok := closestEncloserNSEC(zone, cover, name)
+	if !ok {
+		return false
+	}
+	if r := matchingNSEC("*."+ce, nsecs); r != nil {
+		return nodataBitmap(r.TypeBitMap, qtype)
+	}
+	return false
+}
+
+func matchingNSEC3(name string, nsec3s []*dns.NSEC3) *dns.NSEC3 {
+	for _, r := range nsec3s {
+		if r.Match(name) {
+			return r
+		}
+	}
+	return nil
+}
+
+func coveringNSEC3(name string, nsec3s []*dns.NSEC3) *dns.NSEC3 {
+	for _, r := range nsec3s {
+		if r.Cover(name) {
+			return r
+		}
+	}
+	return nil
+}
+
+// closestEncloserNSEC3 finds the closest existing name above name and
+// the NSEC3 covering the next closer name (RFC 5155 8.3)
+func closestEncloserNSEC3(zone, name string, nsec3s []*dns.NSEC3) (string, *dns.NSEC3, bool) {
+	next := name
+	for ce := parentName(name); dns.IsSubDomain(zone, ce); ce = parentName(ce) {
+		if m := matchingNSEC3(ce, nsec3s); m != nil {
+			// the names below a delegation or a DNAME are not in the zone
+			if delegation(m.TypeBitMap) || hasType(m.TypeBitMap, dns.TypeDNAME) {
+				return "", nil, false
+			}
+			cover := coveringNSEC3(next, nsec3s)
+			return ce, cover, cover != nil
+		}
+		if ce == zone || ce == "." {
+			break
+		}
+		next = ce
+	}
+	return "", nil, false
+}
+
+func nxdomainNSEC3(zone, name string, nsec3s []*dns.NSEC3) bool {
+	ce, _, ok := closestEncloserNSEC3(zone, name, nsec3s)
+	return ok && coveringNSEC3("*."+ce, nsec3s) != nil
+}
+
+func nodataNSEC3(zone, name string, qtype uint16, nsec3s []*dns.NSEC3) bool {
+	if r := matchingNSEC3(name, nsec3s); r != nil {
+		return nodataBitmap(r.TypeBitMap, qtype)
+	}
+
+	ce, cover, ok := closestEncloserNSEC3(zone, name, nsec3s)
+	if !ok {
+		return false
+	}
+	// unsigned delegation inside an opt-out span
+	if qtype == dns.TypeDS && cover.Flags&1 == 1 {
+		return true
+	}
+	if r := matchingNSEC3("*."+ce, nsec3s); r != nil {
+		return nodataBitmap(r.TypeBitMap, qtype)
+	}
+	return false
+}
+
+// dsDenial tells from the (already validated) proof that child has no DS
+// records whether it is a delegation to an unsigned zone or a name
+// inside the zone
+func dsDenial(zone, child string, msg *dns.Msg) (int, bool) {
+	for _, rr := range msg.Ns {
+		if !dns.IsSubDomain(zone, rr.Header().Name) {
+			continue
+		}
+		switch r := rr.(type) {
+		case *dns.NSEC:
+			if compareNames(r.Hdr.Name, child) != 0 {
+				continue
+			}
+			return bitmapCut(r.TypeBitMap)
+		case *dns.NSEC3:
+			if !r.Match(child) {
+				continue
+			}
+			return bitmapCut(r.TypeBitMap)
+		}
+	}
+
+	if !denies(zone, child, dns.TypeDS, msg) {
+		return 0, false
+	}
+	// an empty non-terminal or an opt-out span: the NSEC3 opt-out can
+	// hide unsigned delegations
+	var nsec3s []*dns.NSEC3
+	for _, rr := range msg.Ns {
+		if r, ok := rr.(*dns.NSEC3); ok && dns.IsSubDomain(zone, r.Hdr.Name) {
+			nsec3s = append(nsec3s, r)
+		}
+	}
+	if _, cover, ok := closestEncloserNSEC3(zone, child, nsec3s); ok && cover.Flags&1 == 1 {
+		return insecureCut, true
+	}
+	return notCut, true
+}
+
+// bitmapCut classifies the name of the bitmap, which must not have DS
+// records, nor be the apex of a zone (SOA set, RFC 4035 5.2)
+func bitmapCut(bitmap []uint16) (int, bool) {
+	if hasType(bitmap, dns.TypeDS) || hasType(bitmap, dns.TypeSOA) || hasType(bitmap, dns.TypeCNAME) {
+		return 0, false
+	}
+	if hasType(bitmap, dns.TypeNS) {
+		return insecureCut, true
+	}
+	return notCut, true
+}
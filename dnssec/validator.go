@@ -0,0 +1,482 @@
+package dnssec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Result of the validation of an answer
+type Result int
+
+const (
+	Insecure Result = iota // the zone is not signed (or no chain of trust reaches it)
+	Secure                 // every RRset validated up to a trust anchor
+	Bogus                  // signatures are missing or do not validate
+)
+
+func (r Result) String() string {
+	switch r {
+	case Secure:
+		return "secure"
+	case Bogus:
+		return "bogus"
+	}
+	return "insecure"
+}
+
+// DefaultTrustAnchors are the DS records of the root zone KSKs
+var DefaultTrustAnchors = []string{
+	". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// max time the validated keys are cached
+const maxKeyTTL = time.Hour
+
+var errBogus = errors.New("bogus signature")
+
+// Exchange sends a query (with the DO bit set) and returns the answer
+type Exchange func(name string, qtype uint16) (*dns.Msg, error)
+
+type keyEntry struct {
+	keys    []*dns.DNSKEY // nil if the zone is provably insecure
+	expires time.Time
+}
+
+// Validator validates answers building the chain of trust from the
+// trust anchors, fetching the DS and DNSKEY records with exchange
+type Validator struct {
+	exchange Exchange
+	anchors  map[string][]*dns.DS
+
+	lock sync.Mutex
+	keys map[string]*keyEntry
+	cuts map[string]*cutEntry
+}
+
+type rrset struct {
+	name  string
+	rtype uint16
+	rrs   []dns.RR
+	sigs  []*dns.RRSIG
+}
+
+// NewValidator creates a validator for the trust anchors, DS records in
+// zone file format (DefaultTrustAnchors if empty)
+func NewValidator(exchange Exchange, anchors []string) (*Validator, error) {
+	if len(anchors) == 0 {
+		anchors = DefaultTrustAnchors
+	}
+
+	v := &Validator{exchange: exchange, anchors: map[string][]*dns.DS{}, keys: map[string]*keyEntry{}, cuts: map[string]*cutEntry{}}
+	for _, a := range anchors {
+		rr, err := dns.NewRR(a)
+		if err != nil {
+			return nil, fmt.Errorf("trust anchor %q: %s", a, err)
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			return nil, fmt.Errorf("trust anchor %q is not a DS record", a)
+		}
+		zone := canonical(ds.Hdr.Name)
+		v.anchors[zone] = append(v.anchors[zone], ds)
+	}
+	return v, nil
+}
+
+func canonical(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+// splitRRsets groups records by name and type, with their signatures
+func splitRRsets(rrs []dns.RR) []*rrset {
+	var sets []*rrset
+	find := func(name string, rtype uint16) *rrset {
+		for _, s := range sets {
+			if s.name == name && s.rtype == rtype {
+				return s
+			}
+		}
+		s := &rrset{name: name, rtype: rtype}
+		sets = append(sets, s)
+		return s
+	}
+
+	for _, rr := range rrs {
+		name := canonical(rr.Header().Name)
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			s := find(name, sig.TypeCovered)
+			s.sigs = append(s.sigs, sig)
+		} else if rr.Header().Rrtype != dns.TypeOPT {
+			s := find(name, rr.Header().Rrtype)
+			s.rrs = append(s.rrs, rr)
+		}
+	}
+	return sets
+}
+
+// Validate checks the answer to a query
+func (v *Validator) Validate(qname string, qtype uint16, msg *dns.Msg) Result {
+	if msg.Rcode != dns.RcodeSuccess && msg.Rcode != dns.RcodeNameError {
+		return Insecure
+	}
+
+	secure := true
+	name := canonical(qname)
+	answered := false
+	for _, set := range splitRRsets(msg.Answer) {
+		if len(set.rrs) == 0 {
+			continue
+		}
+		switch v.verifyRRset(set) {
+		case Bogus:
+			return Bogus
+		case Insecure:
+			secure = false
+		}
+
+		// follow the CNAME chain to know the name that has to be denied
+		if set.name == name && set.rtype == dns.TypeCNAME && qtype != dns.TypeCNAME {
+			name = canonical(set.rrs[0].(*dns.CNAME).Target)
+		} else if set.name == name && (set.rtype == qtype || qtype == dns.TypeANY) {
+			answered = true
+		}
+	}
+	if answered && msg.Rcode == dns.RcodeSuccess {
+		if secure {
+			return Secure
+		}
+		return Insecure
+	}
+
+	// negative answer: the SOA and NSEC/NSEC3 records must be signed by
+	// the zone of the name, and they must deny the name (NXDOMAIN) or
+	// the type (NODATA), the DS records are denied by the parent zone
+	zoneName := name
+	if qtype == dns.TypeDS && name != "." {
+		zoneName = parentName(name)
+	}
+	z, err := v.zoneFor(zoneName)
+	if err != nil {
+		return Bogus
+	}
+	if z.keys == nil {
+		return Insecure
+	}
+	if !verifyAll(msg.Ns, z) {
+		return Bogus
+	}
+	if !secure {
+		return Insecure
+	}
+	if !denies(z.name, name, qtype, msg) {
+		return Bogus
+	}
+	return Secure
+}
+
+// verifyRRset validates the signatures of an RRset with the keys of the
+// zone it belongs to (the parent zone for DS records)
+func (v *Validator) verifyRRset(set *rrset) Result {
+	name := set.name
+	if set.rtype == dns.TypeDS && name != "." {
+		name = parentName(name)
+	}
+	z, err := v.zoneFor(name)
+	if err != nil {
+		return Bogus
+	}
+	if z.keys == nil {
+		return Insecure
+	}
+	if verifyWith(set, z) {
+		return Secure
+	}
+	return Bogus
+}
+
+// verifyWith reports whether the RRset is signed by the zone
+func verifyWith(set *rrset, z zone) bool {
+	for _, sig := range set.sigs {
+		if canonical(sig.SignerName) == z.name && verify(sig, z.keys, set.rrs) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyAll reports whether every RRset of a section is signed by the zone
+func verifyAll(rrs []dns.RR, z zone) bool {
+	sets := splitRRsets(rrs)
+	if len(sets) == 0 {
+		return false
+	}
+	for _, set := range sets {
+		if len(set.rrs) == 0 || !verifyWith(set, z) {
+			return false
+		}
+	}
+	return true
+}
+
+func verify(sig *dns.RRSIG, keys []*dns.DNSKEY, rrs []dns.RR) bool {
+	if !sig.ValidityPeriod(time.Now()) {
+		return false
+	}
+	for _, key := range keys {
+		if key.KeyTag() == sig.KeyTag && key.Algorithm == sig.Algorithm && sig.Verify(key, rrs) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func verifySet(set *rrset, keys []*dns.DNSKEY) bool {
+	for _, sig := range set.sigs {
+		if verify(sig, keys, set.rrs) {
+			return true
+		}
+	}
+	return false
+}
+
+func parentName(zone string) string {
+	i, end := dns.NextLabel(zone, 0)
+	if end {
+		return "."
+	}
+	return zone[i:]
+}
+
+// childName returns the name below zone, one label longer, on the way
+// to name
+func childName(zone, name string) string {
+	labels := dns.SplitDomainName(name)
+	n := dns.CountLabel(zone) + 1
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+// zone is a zone cut found from a trust anchor, keys is nil if the zone
+// is provably insecure
+type zone struct {
+	name string
+	keys []*dns.DNSKEY
+}
+
+// zoneFor returns the zone the name belongs to. It walks down from the
+// closest trust anchor asking for the DS records of every name on the
+// way, and only trusts the zone cuts proven by the already validated
+// zones, so an attacker can not make a signed name look unsigned.
+func (v *Validator) zoneFor(name string) (zone, error) {
+	name = canonical(name)
+	anchor := ""
+	for a := range v.anchors {
+		if dns.IsSubDomain(a, name) && len(a) > len(anchor) {
+			anchor = a
+		}
+	}
+	if anchor == "" {
+		// no trust anchor above the name, there is no chain of trust
+		return zone{name: "."}, nil
+	}
+
+	keys, err := v.zoneKeys(anchor, v.anchors[anchor])
+	if err != nil {
+		return zone{}, err
+	}
+	z := zone{name: anchor, keys: keys}
+	for at := anchor; at != name && z.keys != nil; {
+		at = childName(at, name)
+		c, err := v.cut(z, at)
+		if err != nil {
+			return zone{}, err
+		}
+		switch c.kind {
+		case secureCut:
+			keys, err := v.zoneKeys(at, c.ds)
+			if err != nil {
+				return zone{}, err
+			}
+			z = zone{name: at, keys: keys}
+		case insecureCut:
+			return zone{name: at}, nil
+		case lastName:
+			return z, nil
+		}
+	}
+	return z, nil
+}
+
+// zoneKeys returns the DNSKEYs of a zone validated by its DS records, or
+// nil keys if none of the DS records can be validated (insecure zone)
+func (v *Validator) zoneKeys(name string, dsSet []*dns.DS) ([]*dns.DNSKEY, error) {
+	v.lock.Lock()
+	entry, ok := v.keys[name]
+	v.lock.Unlock()
+	if ok && entry.expires.After(time.Now()) {
+		return entry.keys, nil
+	}
+
+	if !supported(dsSet) {
+		v.cacheKeys(name, nil, maxKeyTTL)
+		return nil, nil
+	}
+
+	r, err := v.exchange(name, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	for _, set := range splitRRsets(r.Answer) {
+		if set.name != name || set.rtype != dns.TypeDNSKEY {
+			continue
+		}
+		var keys []*dns.DNSKEY
+		for _, rr := range set.rrs {
+			keys = append(keys, rr.(*dns.DNSKEY))
+		}
+
+		// the DNSKEY RRset must be signed by a key matching a DS record
+		for _, ds := range dsSet {
+			for _, key := range keys {
+				if key.KeyTag() != ds.KeyTag || key.Algorithm != ds.Algorithm {
+					continue
+				}
+				kds := key.ToDS(ds.DigestType)
+				if kds == nil || !strings.EqualFold(kds.Digest, ds.Digest) {
+					continue
+				}
+				if verifySet(set, []*dns.DNSKEY{key}) {
+					v.cacheKeys(name, keys, time.Duration(set.rrs[0].Header().Ttl)*time.Second)
+					return keys, nil
+				}
+			}
+		}
+	}
+	return nil, errBogus
+}
+
+// kinds of names found walking down to a zone
+const (
+	notCut      = iota // a name inside the zone
+	secureCut          // a delegation to a signed zone
+	insecureCut        // a delegation to an unsigned zone
+	lastName           // the name does not exist, there are no zones below
+)
+
+type cutEntry struct {
+	kind    int
+	ds      []*dns.DS
+	expires time.Time
+}
+
+// cut finds out from the DS records of child, signed by the zone above
+// it, whether child is a delegation to a signed or unsigned zone
+func (v *Validator) cut(z zone, child string) (*cutEntry, error) {
+	v.lock.Lock()
+	entry, ok := v.cuts[child]
+	v.lock.Unlock()
+	if ok && entry.expires.After(time.Now()) {
+		return entry, nil
+	}
+
+	r, err := v.exchange(child, dns.TypeDS)
+	if err != nil {
+		return nil, err
+	}
+	c, ttl, err := classifyDS(z, child, r)
+	if err != nil {
+		return nil, err
+	}
+	if ttl > maxKeyTTL {
+		ttl = maxKeyTTL
+	}
+	c.expires = time.Now().Add(ttl)
+	v.lock.Lock()
+	v.cuts[child] = c
+	v.lock.Unlock()
+	return c, nil
+}
+
+// classifyDS checks the answer to the DS query of child, with the TTL it
+// can be trusted for
+func classifyDS(z zone, child string, r *dns.Msg) (*cutEntry, time.Duration, error) {
+	if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return nil, 0, fmt.Errorf("DS query for %s failed: %s", child, dns.RcodeToString[r.Rcode])
+	}
+
+	if r.Rcode == dns.RcodeSuccess {
+		for _, set := range splitRRsets(r.Answer) {
+			if set.name != child || len(set.rrs) == 0 {
+				continue
+			}
+			if !verifyWith(set, z) {
+				return nil, 0, errBogus
+			}
+			ttl := time.Duration(set.rrs[0].Header().Ttl) * time.Second
+			switch set.rtype {
+			case dns.TypeDS:
+				c := &cutEntry{kind: secureCut}
+				for _, rr := range set.rrs {
+					c.ds = append(c.ds, rr.(*dns.DS))
+				}
+				return c, ttl, nil
+			case dns.TypeCNAME:
+				// an alias, there can be no zones below it
+				return &cutEntry{kind: lastName}, ttl, nil
+			}
+		}
+	}
+
+	// no DS records: the denial must be signed by the zone above child
+	if !verifyAll(r.Ns, z) {
+		return nil, 0, errBogus
+	}
+	ttl := maxKeyTTL
+	for _, rr := range r.Ns {
+		if t := time.Duration(rr.Header().Ttl) * time.Second; t < ttl {
+			ttl = t
+		}
+	}
+	if r.Rcode == dns.RcodeNameError {
+		if !denies(z.name, child, dns.TypeDS, r) {
+			return nil, 0, errBogus
+		}
+		return &cutEntry{kind: lastName}, ttl, nil
+	}
+	kind, ok := dsDenial(z.name, child, r)
+	if !ok {
+		return nil, 0, errBogus
+	}
+	return &cutEntry{kind: kind}, ttl, nil
+}
+
+func (v *Validator) cacheKeys(zone string, keys []*dns.DNSKEY, ttl time.Duration) {
+	if ttl > maxKeyTTL {
+		ttl = maxKeyTTL
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.keys[zone] = &keyEntry{keys: keys, expires: time.Now().Add(ttl)}
+}
+
+// supported reports whether at least one DS record uses algorithms we can validate
+func supported(dsSet []*dns.DS) bool {
+	for _, ds := range dsSet {
+		switch ds.Algorithm {
+		case dns.RSASHA1, dns.RSASHA1NSEC3SHA1, dns.RSASHA256, dns.RSASHA512,
+			dns.ECDSAP256SHA256, dns.ECDSAP384SHA384, dns.ED25519:
+		default:
+			continue
+		}
+		switch ds.DigestType {
+		case dns.SHA1, dns.SHA256, dns.SHA384:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,321 @@
+package dnssec
+
+import (
+	"crypto"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testZone is a zone signed with a single key
+type testZone struct {
+	name string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newTestZone(t *testing.T, name string) *testZone {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testZone{name: name, key: key, priv: priv.(crypto.Signer)}
+}
+
+func (z *testZone) ds() *dns.DS {
+	return z.key.ToDS(dns.SHA256)
+}
+
+// signValid returns the RRset with its signature, valid between the
+// inception and the expiration
+func (z *testZone) signValid(t *testing.T, inception, expiration time.Time, rrs ...dns.RR) []dns.RR {
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrs[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrs[0].Header().Ttl},
+		Algorithm:  z.key.Algorithm,
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+		Inception:  uint32(inception.Unix()),
+		Expiration: uint32(expiration.Unix()),
+	}
+	if err := sig.Sign(z.priv, rrs); err != nil {
+		t.Fatal(err)
+	}
+	return append(rrs, sig)
+}
+
+func (z *testZone) sign(t *testing.T, rrs ...dns.RR) []dns.RR {
+	return z.signValid(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), rrs...)
+}
+
+func (z *testZone) dnskey(t *testing.T) *dns.Msg {
+	return answer(z.sign(t, z.key)...)
+}
+
+func (z *testZone) soa(t *testing.T) []dns.RR {
+	return z.sign(t, newRR(t, z.name+" 300 IN SOA ns.invalid. admin.invalid. 1 3600 600 86400 300"))
+}
+
+func newRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func nsec(owner, next string, types ...uint16) *dns.NSEC {
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: owner, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+		NextDomain: next,
+		TypeBitMap: types,
+	}
+}
+
+func answer(rrs ...dns.RR) *dns.Msg {
+	m := new(dns.Msg)
+	m.Answer = rrs
+	return m
+}
+
+func negative(rcode int, rrs ...[]dns.RR) *dns.Msg {
+	m := new(dns.Msg)
+	m.Rcode = rcode
+	for _, r := range rrs {
+		m.Ns = append(m.Ns, r...)
+	}
+	return m
+}
+
+// testServer answers the queries of the validator from prepared messages
+type testServer map[string]*dns.Msg
+
+func (s testServer) exchange(name string, qtype uint16) (*dns.Msg, error) {
+	m, ok := s[name+" "+dns.TypeToString[qtype]]
+	if !ok {
+		return nil, errors.New("unexpected query " + name + " " + dns.TypeToString[qtype])
+	}
+	return m, nil
+}
+
+// testTree is a signed root with the signed zone example., which has a
+// host www.example. and an unsigned delegation insecure.example.
+type testTree struct {
+	root, example *testZone
+	server        testServer
+}
+
+func newTestTree(t *testing.T) *testTree {
+	root := newTestZone(t, ".")
+	example := newTestZone(t, "example.")
+	tree := &testTree{root: root, example: example, server: testServer{}}
+
+	apex := nsec("example.", "insecure.example.", dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY)
+	insecure := nsec("insecure.example.", "www.example.", dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC)
+	www := nsec("www.example.", "example.", dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC)
+
+	tree.server[". DNSKEY"] = root.dnskey(t)
+	tree.server["example. DS"] = answer(root.sign(t, example.ds())...)
+	tree.server["example. DNSKEY"] = example.dnskey(t)
+	tree.server["www.example. DS"] = negative(dns.RcodeSuccess, example.soa(t), example.sign(t, www))
+	tree.server["insecure.example. DS"] = negative(dns.RcodeSuccess, example.soa(t), example.sign(t, insecure))
+	tree.server["nope.example. DS"] = negative(dns.RcodeNameError, example.soa(t), example.sign(t, apex), example.sign(t, insecure))
+	return tree
+}
+
+func (tree *testTree) validator(t *testing.T) *Validator {
+	v, err := NewValidator(tree.server.exchange, []string{tree.root.ds().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func (tree *testTree) apexNSEC() *dns.NSEC {
+	return nsec("example.", "insecure.example.", dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY)
+}
+
+func TestValidateAnswer(t *testing.T) {
+	tree := newTestTree(t)
+	ex := tree.example
+	a := func() dns.RR { return newRR(t, "www.example. 300 IN A 192.0.2.1") }
+	tampered := ex.sign(t, a())
+	tampered[0].(*dns.A).A[3] = 2
+
+	tests := []struct {
+		name   string
+		qname  string
+		answer []dns.RR
+		want   Result
+	}{
+		{"secure", "www.example.", ex.sign(t, a()), Secure},
+		{"unsigned in signed zone", "www.example.", []dns.RR{a()}, Bogus},
+		{"bad signature", "www.example.", tampered, Bogus},
+		{"expired signature", "www.example.", ex.signValid(t, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour), a()), Bogus},
+		{"signed by the parent", "www.example.", tree.root.sign(t, a()), Bogus},
+		{"insecure delegation", "www.insecure.example.", []dns.RR{newRR(t, "www.insecure.example. 300 IN A 192.0.2.2")}, Insecure},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := tree.validator(t).Validate(test.qname, dns.TypeA, answer(test.answer...))
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// TestValidateDowngrade checks that the DS of a signed zone can not be
+// hidden to make its answers look insecure
+func TestValidateDowngrade(t *testing.T) {
+	tree := newTestTree(t)
+	root, ex := tree.root, tree.example
+	signed := ex.sign(t, newRR(t, "www.example. 300 IN A 192.0.2.1"))
+
+	tests := []struct {
+		name string
+		ds   *dns.Msg
+	}{
+		{"missing DS", negative(dns.RcodeSuccess)},
+		{"unsigned denial", negative(dns.RcodeSuccess, []dns.RR{newRR(t, ". 300 IN SOA a. b. 1 3600 600 86400 300"), nsec("example.", "f.", dns.TypeNS)})},
+		{"denial from the child", negative(dns.RcodeSuccess, ex.soa(t), ex.sign(t, tree.apexNSEC()))},
+		{"denial with SOA", negative(dns.RcodeSuccess, root.soa(t), root.sign(t, nsec("example.", "f.", dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC)))},
+		{"denial without NS", negative(dns.RcodeSuccess, root.soa(t), root.sign(t, nsec("example.", "f.", dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC)))},
+		{"unsigned DS", answer(ex.ds())},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tree.server["example. DS"] = test.ds
+			got := tree.validator(t).Validate("www.example.", dns.TypeA, answer(signed...))
+			if got != Bogus {
+				t.Errorf("got %s, want bogus", got)
+			}
+		})
+	}
+}
+
+func TestValidateDenial(t *testing.T) {
+	tree := newTestTree(t)
+	ex := tree.example
+	insecure := nsec("insecure.example.", "www.example.", dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC)
+	www := nsec("www.example.", "example.", dns.TypeA, dns.TypeRRSIG, dns.TypeNSEC)
+
+	tests := []struct {
+		name  string
+		qname string
+		qtype uint16
+		msg   *dns.Msg
+		want  Result
+	}{
+		{"nxdomain", "nope.example.", dns.TypeA, negative(dns.RcodeNameError, ex.soa(t), ex.sign(t, tree.apexNSEC()), ex.sign(t, insecure)), Secure},
+		{"nxdomain without wildcard proof", "nope.example.", dns.TypeA, negative(dns.RcodeNameError, ex.soa(t), ex.sign(t, insecure)), Bogus},
+		{"nxdomain without NSEC", "nope.example.", dns.TypeA, negative(dns.RcodeNameError, ex.soa(t)), Bogus},
+		{"nxdomain unsigned NSEC", "nope.example.", dns.TypeA, negative(dns.RcodeNameError, ex.soa(t), []dns.RR{tree.apexNSEC(), insecure}), Bogus},
+		{"nodata", "www.example.", dns.TypeAAAA, negative(dns.RcodeSuccess, ex.soa(t), ex.sign(t, www)), Secure},
+		{"nodata of an existing type", "www.example.", dns.TypeA, negative(dns.RcodeSuccess, ex.soa(t), ex.sign(t, www)), Bogus},
+		{"nodata from a delegation", "insecure.example.", dns.TypeDS, negative(dns.RcodeSuccess, ex.soa(t), ex.sign(t, insecure)), Secure},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := tree.validator(t).Validate(test.qname, test.qtype, test.msg)
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// nsec3Chain returns the signed NSEC3 chain of the names of a zone
+func nsec3Chain(t *testing.T, z *testZone, flags uint8, names map[string][]uint16) []dns.RR {
+	var hashes []string
+	types := map[string][]uint16{}
+	for name, bitmap := range names {
+		h := dns.HashName(name, dns.SHA1, 0, "")
+		hashes = append(hashes, h)
+		types[h] = bitmap
+	}
+	sort.Strings(hashes)
+
+	var rrs []dns.RR
+	for i, h := range hashes {
+		rr := &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: h + "." + z.name, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300},
+			Hash:       dns.SHA1,
+			Flags:      flags,
+			HashLength: 20,
+			NextDomain: hashes[(i+1)%len(hashes)],
+			TypeBitMap: types[h],
+		}
+		rrs = append(rrs, z.sign(t, rr)...)
+	}
+	return rrs
+}
+
+func TestValidateNSEC3(t *testing.T) {
+	for _, optOut := range []bool{false, true} {
+		z := newTestZone(t, "nsec3.test.")
+		flags := uint8(0)
+		if optOut {
+			flags = 1
+		}
+		names := map[string][]uint16{
+			"nsec3.test.":     {dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM},
+			"www.nsec3.test.": {dns.TypeA, dns.TypeRRSIG},
+		}
+		chain := nsec3Chain(t, z, flags, names)
+		www := nsec3Chain(t, z, flags, map[string][]uint16{"www.nsec3.test.": {dns.TypeA, dns.TypeRRSIG}})
+
+		server := testServer{
+			"nsec3.test. DNSKEY":    z.dnskey(t),
+			"www.nsec3.test. DS":    negative(dns.RcodeSuccess, z.soa(t), chain),
+			"nope.nsec3.test. DS":   negative(dns.RcodeNameError, z.soa(t), chain),
+			"optout.nsec3.test. DS": negative(dns.RcodeSuccess, z.soa(t), chain),
+		}
+		v, err := NewValidator(server.exchange, []string{z.ds().String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name  string
+			qname string
+			qtype uint16
+			msg   *dns.Msg
+			want  Result
+		}{
+			{"nxdomain", "nope.nsec3.test.", dns.TypeA, negative(dns.RcodeNameError, z.soa(t), chain), Secure},
+			{"nxdomain without closest encloser", "nope.nsec3.test.", dns.TypeA, negative(dns.RcodeNameError, z.soa(t), www), Bogus},
+			{"nodata", "www.nsec3.test.", dns.TypeAAAA, negative(dns.RcodeSuccess, z.soa(t), chain), Secure},
+			{"nodata of an existing type", "www.nsec3.test.", dns.TypeA, negative(dns.RcodeSuccess, z.soa(t), chain), Bogus},
+		}
+		// a name below an opt-out span may be an unsigned delegation
+		optOutResult := Bogus
+		if optOut {
+			optOutResult = Insecure
+		}
+		tests = append(tests, struct {
+			name  string
+			qname string
+			qtype uint16
+			msg   *dns.Msg
+			want  Result
+		}{"opt-out", "www.optout.nsec3.test.", dns.TypeA, answer(newRR(t, "www.optout.nsec3.test. 300 IN A 192.0.2.3")), optOutResult})
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				got := v.Validate(test.qname, test.qtype, test.msg)
+				if got != test.want {
+					t.Errorf("opt-out %v: got %s, want %s", optOut, got, test.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package dnsserver
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"GoHole/logs"
+)
+
+// flight is an upstream exchange in progress, shared by the identical
+// queries received while it runs
+type flight struct {
+	done chan struct{}
+	r      *dns.Msg
+	server string // upstream server that answered
+	err    error
+}
+
+var flights = map[string]*flight{}
+var flightsLock sync.Mutex
+
+// exchangeCoalesced forwards a question upstream, concurrent identical
+// questions (name, type and client subnet, the class is always IN) share
+// one exchange. It also returns the upstream server that answered.
+func exchangeCoalesced(q dns.Question, ecs *dns.EDNS0_SUBNET) (*dns.Msg, string, error) {
+	key := strings.ToLower(q.Name) + "/" + dns.TypeToString[q.Qtype]
+	if ecs != nil {
+		key += "/" + ecs.String()
+	}
+
+	flightsLock.Lock()
+	if f, ok := flights[key]; ok {
+		flightsLock.Unlock()
+		logs.AddCoalescedToGraphite()
+		<-f.done
+		return copyMsg(f.r), f.server, f.err
+	}
+	f := &flight{done: make(chan struct{})}
+	flights[key] = f
+	flightsLock.Unlock()
+
+	f.r, f.server, f.err = exchangeWithSubnet(q.Name, q.Qtype, ecs)
+
+	flightsLock.Lock()
+	delete(flights, key)
+	flightsLock.Unlock()
+	close(f.done)
+
+	return copyMsg(f.r), f.server, f.err
+}
+
+// copyMsg copies the shared answer, every query modifies its own
+func copyMsg(r *dns.Msg) *dns.Msg {
+	if r == nil {
+		return nil
+	}
+	return r.Copy()
+}
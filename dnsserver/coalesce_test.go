@@ -0,0 +1,135 @@
+package dnsserver
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"GoHole/config"
+	"GoHole/logs"
+)
+
+// fakeUpstream starts the upstream server of the test, which answers every
+// query with 192.0.2.1 once release is closed. It returns the number of
+// queries received.
+func fakeUpstream(t *testing.T, release chan struct{}) *int32 {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exchanges int32
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddInt32(&exchanges, 1)
+		<-release
+		m := new(dns.Msg)
+		m.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 192.0.2.1")
+		m.Answer = append(m.Answer, rr)
+		w.WriteMsg(m)
+	})}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+
+	withConfig(t, func(c *config.MyConfig) {
+		c.UpstreamDNSServer, c.UpstreamDNSServers = pc.LocalAddr().String(), nil
+	})
+	return &exchanges
+}
+
+// waitFor polls a condition until it is true, failing after a second
+func waitFor(t *testing.T, what string, f func() bool) {
+	for deadline := time.Now().Add(time.Second); !f(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+	}
+}
+
+func TestExchangeCoalesced(t *testing.T) {
+	tests := []struct {
+		name      string
+		questions []dns.Question
+		exchanges int
+	}{
+		{"same question", []dns.Question{
+			{Name: "a.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			{Name: "a.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			{Name: "a.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			{Name: "a.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			{Name: "a.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+		}, 1},
+		{"names in any case", []dns.Question{
+			{Name: "b.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			{Name: "B.Example.COM.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+		}, 1},
+		{"other types", []dns.Question{
+			{Name: "c.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			{Name: "c.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			{Name: "c.example.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET},
+		}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			release := make(chan struct{})
+			exchanges := fakeUpstream(t, release)
+			coalesced := logs.GetStats().Coalesced
+
+			var wg sync.WaitGroup
+			answers := make([]*dns.Msg, len(test.questions))
+			for i, q := range test.questions {
+				wg.Add(1)
+				go func(i int, q dns.Question) {
+					defer wg.Done()
+					answers[i], _, _ = exchangeCoalesced(q, nil)
+				}(i, q)
+			}
+			// every query is sent upstream or waits for the one in flight
+			// before the upstream answers
+			want := len(test.questions) - test.exchanges
+			waitFor(t, "the queries", func() bool {
+				return int(atomic.LoadInt32(exchanges)) == test.exchanges && logs.GetStats().Coalesced-coalesced == want
+			})
+			close(release)
+			wg.Wait()
+
+			if n := atomic.LoadInt32(exchanges); int(n) != test.exchanges {
+				t.Errorf("got %d exchanges, want %d", n, test.exchanges)
+			}
+			if n := logs.GetStats().Coalesced - coalesced; n != want {
+				t.Errorf("got %d coalesced, want %d", n, want)
+			}
+			for i, r := range answers {
+				if r == nil || len(r.Answer) != 1 {
+					t.Fatalf("query %d got answer %v", i, r)
+				}
+				if i > 0 && r == answers[0] {
+					t.Errorf("query %d got the same message as the first one", i)
+				}
+			}
+		})
+	}
+}
+
+// the queries of other classes are not sent upstream
+func TestOtherClasses(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	exchanges := fakeUpstream(t, release)
+
+	for _, class := range []uint16{dns.ClassCHAOS, dns.ClassHESIOD, dns.ClassANY} {
+		r := new(dns.Msg)
+		r.SetQuestion("version.bind.", dns.TypeTXT)
+		r.Question[0].Qclass = class
+		m := buildReply("127.0.0.1", r, false, false)
+		if m.Rcode != dns.RcodeNotImplemented {
+			t.Errorf("class %s got %s, want NOTIMP", dns.ClassToString[class], dns.RcodeToString[m.Rcode])
+		}
+	}
+	if n := atomic.LoadInt32(exchanges); n != 0 {
+		t.Errorf("got %d exchanges", n)
+	}
+}
@@ -0,0 +1,186 @@
+package dnsserver
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"GoHole/blocking"
+	"GoHole/control"
+	"GoHole/dnscache"
+	"GoHole/logs"
+	"GoHole/parser"
+)
+
+// registerControlHandlers adds the commands the CLI can run
+// against the running server
+func registerControlHandlers() {
+	// args: minutes, target (client IP or group, empty for everybody)
+	control.Handle("pause", func(args map[string]string) (interface{}, error) {
+		minutes, err := strconv.Atoi(args["minutes"])
+		if err != nil || minutes <= 0 {
+			return nil, errors.New("invalid number of minutes")
+		}
+		return blocking.PauseBlocking(args["target"], time.Duration(minutes)*time.Minute)
+	})
+
+	// args: target
+	control.Handle("resume", func(args map[string]string) (interface{}, error) {
+		blocking.ResumeBlocking(args["target"])
+		return nil, nil
+	})
+
+	control.Handle("pauses", func(args map[string]string) (interface{}, error) {
+		return blocking.GetPauses(), nil
+	})
+
+	control.Handle("cache", func(args map[string]string) (interface{}, error) {
+		return dnscache.GetStats(), nil
+	})
+
+	// args: client, name, type
+	// how the last query of the client for the question was answered
+	control.Handle("diagnose", func(args map[string]string) (interface{}, error) {
+		qtype, ok := dns.StringToType[strings.ToUpper(args["type"])]
+		if !ok {
+			return nil, errors.New("unknown record type " + args["type"])
+		}
+		d, ok := lastOutcome(args["client"], args["name"], qtype)
+		if !ok {
+			return nil, errors.New("no query for " + args["name"] + " from " + args["client"] + " was answered")
+		}
+		return d, nil
+	})
+
+	// args: client, domain, time (RFC 3339)
+	// how the blocklists of the server apply to a query
+	control.Handle("why", func(args map[string]string) (interface{}, error) {
+		t, err := time.Parse(time.RFC3339, args["time"])
+		if err != nil {
+			return nil, errors.New("invalid time " + args["time"])
+		}
+		return blocking.Why(args["client"], args["domain"], t), nil
+	})
+
+	control.Handle("stats", func(args map[string]string) (interface{}, error) {
+		return logs.GetStats(), nil
+	})
+
+	control.Handle("blocked", func(args map[string]string) (interface{}, error) {
+		return dnscache.GetBlockedDomains(), nil
+	})
+
+	// args: domain, ipv4, ipv6
+	control.Handle("block", func(args map[string]string) (interface{}, error) {
+		if args["domain"] == "" || args["ipv4"] == "" || args["ipv6"] == "" {
+			return nil, errors.New("domain, ipv4 and ipv6 are required")
+		}
+		dnscache.AddDomainIPv4(args["domain"], args["ipv4"], false)
+		dnscache.AddDomainIPv6(args["domain"], args["ipv6"], false)
+		return nil, saveBlocked()
+	})
+
+	// args: domain
+	control.Handle("unblock", func(args map[string]string) (interface{}, error) {
+		dnscache.DeleteDomainIPv4(args["domain"])
+		dnscache.DeleteDomainIPv6(args["domain"])
+		return nil, saveBlocked()
+	})
+
+	control.Handle("flush", func(args map[string]string) (interface{}, error) {
+		dnscache.Flush()
+		return nil, saveBlocked()
+	})
+
+	// args: path, a blacklist file (absolute path) or URL
+	control.Handle("blacklist", func(args map[string]string) (interface{}, error) {
+		err := parser.ParseBlacklistFile(args["path"])
+		if err != nil {
+			return nil, err
+		}
+		return nil, saveBlocked()
+	})
+
+	// args: path, a file with a blacklist file or URL per line
+	control.Handle("blacklists", func(args map[string]string) (interface{}, error) {
+		err := parser.ParseBlacklistsListFile(args["path"])
+		if err != nil {
+			return nil, err
+		}
+		return nil, saveBlocked()
+	})
+
+	// the logs DB can only be opened by one process, so the CLI
+	// reads it through the running server
+
+	// args: client, domain, since (RFC 3339), limit
+	control.Handle("queries", func(args map[string]string) (interface{}, error) {
+		filter := logs.QueryFilter{ClientIp: args["client"], Domain: args["domain"]}
+		filter.Limit, _ = strconv.Atoi(args["limit"])
+		if args["since"] != "" {
+			since, err := time.Parse(time.RFC3339, args["since"])
+			if err != nil {
+				return nil, errors.New("invalid time " + args["since"])
+			}
+			filter.Since = since
+		}
+		return logs.SearchQueries(filter)
+	})
+
+	// args: limit
+	control.Handle("topdomains", func(args map[string]string) (interface{}, error) {
+		limit, _ := strconv.Atoi(args["limit"])
+		return logs.GetTopDomains(limit)
+	})
+
+	control.Handle("clients", func(args map[string]string) (interface{}, error) {
+		return logs.GetClients()
+	})
+
+	control.Handle("flushlog", func(args map[string]string) (interface{}, error) {
+		return nil, logs.Flush()
+	})
+
+	// args: client, domain, blocked ("true"), type
+	control.HandleStream("tail", func(args map[string]string, send func(interface{}) error, done <-chan struct{}) error {
+		filter := logs.TailFilter{ClientIp: args["client"], Domain: args["domain"], Blocked: args["blocked"] == "true", Type: args["type"]}
+		events, cancel, err := logs.Subscribe(filter)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+		for {
+			select {
+			case e := <-events:
+				err = send(e)
+				if err != nil {
+					return nil // the CLI is gone
+				}
+			case <-done:
+				return nil
+			}
+		}
+	})
+
+	control.Handle("reload", func(args map[string]string) (interface{}, error) {
+		err := reload()
+		if err != nil {
+			log.Printf("Failed to reload config: %s\n", err)
+		}
+		return nil, err
+	})
+}
+
+// saveBlocked saves the blocked domains after they are changed, so
+// they are kept when the server restarts
+func saveBlocked() error {
+	_, err := dnscache.SaveBlocked()
+	if err != nil {
+		log.Printf("Failed to save blocked domains to %s: %s\n", dnscache.BlockedFile(), err)
+	}
+	return err
+}
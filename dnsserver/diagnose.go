@@ -0,0 +1,52 @@
+package dnsserver
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// Diagnosis is how the server answered the last query of a client for
+// a question, recorded by parseQuery and shown by gohole query
+type Diagnosis struct {
+	Decision string // local, blocked, rewritten, cached, forwarded, stale or failed
+	List     string // blocklist, group and schedule that blocked the domain
+	Group    string
+	Schedule string
+	Rewrite  string // target and rule set of the rewrite
+	Paused   bool   // blocking is paused for the client
+	Allowed  bool   // the domain is in the allowlist
+	TTL      int    // seconds until the cached answer expires, -1 if it never does
+	Upstream string // upstream server that answered the question
+}
+
+// how the last queries were answered, by client, name and type
+var outcomes = map[string]Diagnosis{}
+var outcomesLock sync.Mutex
+
+// maxOutcomes bounds outcomes, it is emptied when full
+const maxOutcomes = 1024
+
+func outcomeKey(clientIp, name string, qtype uint16) string {
+	return clientIp + "/" + strings.ToLower(dns.Fqdn(name)) + "/" + dns.TypeToString[qtype]
+}
+
+// recordOutcome records how a query of a client was answered
+func recordOutcome(clientIp, name string, qtype uint16, d Diagnosis) {
+	outcomesLock.Lock()
+	defer outcomesLock.Unlock()
+	if len(outcomes) >= maxOutcomes {
+		outcomes = map[string]Diagnosis{}
+	}
+	outcomes[outcomeKey(clientIp, name, qtype)] = d
+}
+
+// lastOutcome returns how the last query of a client for a question was
+// answered, if it was answered recently
+func lastOutcome(clientIp, name string, qtype uint16) (Diagnosis, bool) {
+	outcomesLock.Lock()
+	defer outcomesLock.Unlock()
+	d, ok := outcomes[outcomeKey(clientIp, name, qtype)]
+	return d, ok
+}
@@ -0,0 +1,227 @@
+package dnsserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"GoHole/config"
+)
+
+// EDNS0 defaults
+const (
+	defaultEDNSBufferSize = 1232 // avoids IP fragmentation (DNS flag day 2020)
+	defaultECSPrefixV4    = 24
+	defaultECSPrefixV6    = 56
+	paddingBlockSize      = 468 // recommended block size for responses (RFC 8467)
+)
+
+// server cookies are valid for one hour, and up to 5 minutes in the future
+const (
+	cookieLifetime = time.Hour
+	cookieSkew     = 5 * time.Minute
+)
+
+var cookieSecret []byte = nil
+var cookieSecretOnce sync.Once
+
+// ednsRequest is what the client sent in its OPT record
+type ednsRequest struct {
+	opt         *dns.OPT // nil if the client does not support EDNS0
+	ecs         *dns.EDNS0_SUBNET
+	cookie      *dns.EDNS0_COOKIE
+	validCookie bool // the server cookie sent by the client is ours and still valid
+	scope       uint8 // scope of the client subnet the upstream answer is valid for
+}
+
+// parseEdns reads the OPT record of a request, returning an error
+// rcode for malformed or unsupported options
+func parseEdns(r *dns.Msg, clientIp string) (*ednsRequest, int) {
+	req := &ednsRequest{opt: r.IsEdns0()}
+	if req.opt == nil {
+		return req, dns.RcodeSuccess
+	}
+	if req.opt.Version() != 0 {
+		return req, dns.RcodeBadVers
+	}
+
+	for _, o := range req.opt.Option {
+		switch e := o.(type) {
+		case *dns.EDNS0_SUBNET:
+			req.ecs = e
+		case *dns.EDNS0_COOKIE:
+			// 8 bytes client cookie + optional 8 to 32 bytes server cookie
+			n := len(e.Cookie) / 2
+			if n != 8 && (n < 16 || n > 40) {
+				return req, dns.RcodeFormatError
+			}
+			req.cookie = e
+			req.validCookie = n > 8 && validServerCookie(e.Cookie, clientIp)
+		}
+	}
+	return req, dns.RcodeSuccess
+}
+
+// udpSize returns the max size of an UDP answer for the client
+func (req *ednsRequest) udpSize() int {
+	if req.opt == nil {
+		return dns.MinMsgSize
+	}
+	size := ednsBufferSize()
+	if int(req.opt.UDPSize()) < size {
+		size = int(req.opt.UDPSize())
+	}
+	if size < dns.MinMsgSize {
+		size = dns.MinMsgSize
+	}
+	return size
+}
+
+func ednsBufferSize() int {
+	if config.GetInstance().EDNSBufferSize > 0 {
+		return config.GetInstance().EDNSBufferSize
+	}
+	return defaultEDNSBufferSize
+}
+
+// upstreamSubnet returns the client subnet to forward upstream, truncated
+// to the configured prefix, or nil when it must be stripped
+func (req *ednsRequest) upstreamSubnet() *dns.EDNS0_SUBNET {
+	if req.ecs == nil || config.GetInstance().EDNSClientSubnet != "forward" {
+		return nil
+	}
+
+	prefix := config.GetInstance().ECSPrefixV4
+	bits := 32
+	if prefix <= 0 {
+		prefix = defaultECSPrefixV4
+	}
+	if req.ecs.Family == 2 {
+		prefix = config.GetInstance().ECSPrefixV6
+		bits = 128
+		if prefix <= 0 {
+			prefix = defaultECSPrefixV6
+		}
+	}
+	if int(req.ecs.SourceNetmask) < prefix {
+		prefix = int(req.ecs.SourceNetmask)
+	}
+
+	return &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        req.ecs.Family,
+		SourceNetmask: uint8(prefix),
+		Address:       req.ecs.Address.Mask(net.CIDRMask(prefix, bits)),
+	}
+}
+
+// upstreamScope returns the scope of the client subnet the upstream
+// answer is valid for, 0 when it is valid for any client (RFC 7871)
+func upstreamScope(r *dns.Msg) uint8 {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return 0
+	}
+	for _, o := range opt.Option {
+		if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return e.SourceScope
+		}
+	}
+	return 0
+}
+
+// badCookie reports whether an UDP request must be answered with BADCOOKIE,
+// when cookies are required clients must first get a valid server cookie
+func (req *ednsRequest) badCookie() bool {
+	cfg := config.GetInstance()
+	return cfg.EDNSCookies && cfg.EDNSCookiesRequired && req.cookie != nil && !req.validCookie
+}
+
+// setEdns adds our OPT record to the reply if the client sent one,
+// with its cookie, the client subnet echo and padding on encrypted transports
+func setEdns(req *ednsRequest, m *dns.Msg, clientIp string, encrypted bool) {
+	if req.opt == nil {
+		return
+	}
+	m.SetEdns0(uint16(ednsBufferSize()), req.opt.Do())
+	opt := m.IsEdns0()
+
+	if req.ecs != nil {
+		// echo the scope of the upstream answer, 0 for the answers that
+		// are not tailored to the subnet (RFC 7871)
+		opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        req.ecs.Family,
+			SourceNetmask: req.ecs.SourceNetmask,
+			SourceScope:   req.scope,
+			Address:       req.ecs.Address,
+		})
+	}
+	if req.cookie != nil && config.GetInstance().EDNSCookies {
+		clientCookie := req.cookie.Cookie[0:16]
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{
+			Code:   dns.EDNS0COOKIE,
+			Cookie: clientCookie + serverCookie(clientCookie, clientIp, time.Now()),
+		})
+	}
+	if encrypted {
+		pad(m, opt)
+	}
+}
+
+// pad adds an EDNS0 padding option so the size of the answer is a
+// multiple of paddingBlockSize (RFC 7830, 8467)
+func pad(m *dns.Msg, opt *dns.OPT) {
+	size := m.Len() + 4 // option code and length
+	padding := (paddingBlockSize - size%paddingBlockSize) % paddingBlockSize
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, padding)})
+}
+
+func getCookieSecret() []byte {
+	cookieSecretOnce.Do(func() {
+		cookieSecret = make([]byte, 32)
+		rand.Read(cookieSecret)
+	})
+	return cookieSecret
+}
+
+// serverCookie builds a server cookie (RFC 7873) with the layout of
+// RFC 9018: version, reserved, timestamp and a hash of the client cookie
+// and IP. The hash is an HMAC-SHA256 (truncated) instead of SipHash.
+func serverCookie(clientCookie, clientIp string, t time.Time) string {
+	cookie := make([]byte, 8, 16)
+	cookie[0] = 1 // version
+	binary.BigEndian.PutUint32(cookie[4:8], uint32(t.Unix()))
+
+	mac := hmac.New(sha256.New, getCookieSecret())
+	client, _ := hex.DecodeString(clientCookie)
+	mac.Write(client)
+	mac.Write(cookie)
+	mac.Write(net.ParseIP(clientIp))
+	cookie = append(cookie, mac.Sum(nil)[0:8]...)
+
+	return hex.EncodeToString(cookie)
+}
+
+func validServerCookie(cookie, clientIp string) bool {
+	if len(cookie) != 48 {
+		return false
+	}
+	b, err := hex.DecodeString(cookie[16:])
+	if err != nil || b[0] != 1 {
+		return false
+	}
+	t := time.Unix(int64(binary.BigEndian.Uint32(b[4:8])), 0)
+	now := time.Now()
+	if t.Before(now.Add(-cookieLifetime)) || t.After(now.Add(cookieSkew)) {
+		return false
+	}
+	return hmac.Equal([]byte(serverCookie(cookie[0:16], clientIp, t)), []byte(cookie[16:]))
+}
@@ -0,0 +1,177 @@
+package dnsserver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"GoHole/config"
+)
+
+const testClientCookie = "0102030405060708"
+
+// ednsQuery returns a query with an OPT record and its options
+func ednsQuery(options ...dns.EDNS0) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetQuestion(serverDomain+".", dns.TypeA)
+	r.SetEdns0(1232, false)
+	opt := r.IsEdns0()
+	opt.Option = append(opt.Option, options...)
+	return r
+}
+
+func cookie(c string) *dns.EDNS0_COOKIE {
+	return &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: c}
+}
+
+func TestCookies(t *testing.T) {
+	now := time.Now()
+	valid := testClientCookie + serverCookie(testClientCookie, "192.0.2.10", now)
+	tests := []struct {
+		name     string
+		required bool
+		udp      bool
+		cookie   string // empty for no cookie option
+		rcode    int
+	}{
+		{"no cookie", true, true, "", dns.RcodeSuccess},
+		{"client cookie only", true, true, testClientCookie, dns.RcodeBadCookie},
+		{"client cookie only, not required", false, true, testClientCookie, dns.RcodeSuccess},
+		{"client cookie only over TCP", true, false, testClientCookie, dns.RcodeSuccess},
+		{"valid server cookie", true, true, valid, dns.RcodeSuccess},
+		{"server cookie of another client", true, true, testClientCookie + serverCookie(testClientCookie, "192.0.2.11", now), dns.RcodeBadCookie},
+		{"server cookie of another client cookie", true, true, "0807060504030201" + valid[16:], dns.RcodeBadCookie},
+		{"expired server cookie", true, true, testClientCookie + serverCookie(testClientCookie, "192.0.2.10", now.Add(-2*time.Hour)), dns.RcodeBadCookie},
+		{"server cookie from the future", true, true, testClientCookie + serverCookie(testClientCookie, "192.0.2.10", now.Add(time.Hour)), dns.RcodeBadCookie},
+		{"short client cookie", true, true, "01020304", dns.RcodeFormatError},
+		{"short server cookie", true, true, testClientCookie + "0102", dns.RcodeFormatError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withConfig(t, func(c *config.MyConfig) {
+				c.EDNSCookies, c.EDNSCookiesRequired = true, test.required
+			})
+			r := ednsQuery()
+			if test.cookie != "" {
+				r = ednsQuery(cookie(test.cookie))
+			}
+			// like buildReply, BADCOOKIE is only answered over UDP
+			req, rcode := parseEdns(r, "192.0.2.10")
+			if rcode == dns.RcodeSuccess && test.udp && req.badCookie() {
+				rcode = dns.RcodeBadCookie
+			}
+			if rcode != test.rcode {
+				t.Fatalf("got rcode %s, want %s", dns.RcodeToString[rcode], dns.RcodeToString[test.rcode])
+			}
+			if test.rcode == dns.RcodeFormatError || test.cookie == "" {
+				return
+			}
+			m := new(dns.Msg)
+			m.SetReply(r)
+			setEdns(req, m, "192.0.2.10", false)
+
+			// the reply always carries a new server cookie for the client
+			var got string
+			for _, o := range m.IsEdns0().Option {
+				if c, ok := o.(*dns.EDNS0_COOKIE); ok {
+					got = c.Cookie
+				}
+			}
+			if len(got) != 48 || got[0:16] != test.cookie[0:16] || !validServerCookie(got, "192.0.2.10") {
+				t.Errorf("got cookie %q", got)
+			}
+		})
+	}
+}
+
+func TestUpstreamSubnet(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		v4, v6   int // configured prefixes
+		family   uint16
+		address  string
+		netmask  uint8
+		want     string // empty if stripped
+		wantMask uint8
+	}{
+		{"stripped", "strip", 0, 0, 1, "192.0.2.10", 32, "", 0},
+		{"ipv4 truncated", "forward", 0, 0, 1, "192.0.2.10", 32, "192.0.2.0", 24},
+		{"ipv4 custom prefix", "forward", 20, 0, 1, "192.0.31.10", 32, "192.0.16.0", 20},
+		{"ipv4 shorter source", "forward", 0, 0, 1, "192.0.0.0", 16, "192.0.0.0", 16},
+		{"ipv6 truncated", "forward", 0, 0, 2, "2001:db8:1:2345::1", 128, "2001:db8:1:2300::", 56},
+		{"ipv6 custom prefix", "forward", 0, 48, 2, "2001:db8:1:2:3::1", 128, "2001:db8:1::", 48},
+		{"ipv6 shorter source", "forward", 0, 0, 2, "2001:db8::", 32, "2001:db8::", 32},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withConfig(t, func(c *config.MyConfig) {
+				c.EDNSClientSubnet, c.ECSPrefixV4, c.ECSPrefixV6 = test.mode, test.v4, test.v6
+			})
+			ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: test.family, SourceNetmask: test.netmask, Address: net.ParseIP(test.address)}
+			req, rcode := parseEdns(ednsQuery(ecs), "192.0.2.10")
+			if rcode != dns.RcodeSuccess {
+				t.Fatalf("got rcode %s", dns.RcodeToString[rcode])
+			}
+
+			got := req.upstreamSubnet()
+			if test.want == "" {
+				if got != nil {
+					t.Errorf("got subnet %s, want it stripped", got)
+				}
+				return
+			}
+			if got == nil || !got.Address.Equal(net.ParseIP(test.want)) || got.SourceNetmask != test.wantMask || got.Family != test.family {
+				t.Errorf("got subnet %v, want %s/%d", got, test.want, test.wantMask)
+			}
+		})
+	}
+}
+
+func TestPadding(t *testing.T) {
+	tests := []struct {
+		name      string
+		answers   int
+		encrypted bool
+	}{
+		{"no answers", 0, true},
+		{"one answer", 1, true},
+		{"over a block", 30, true},
+		{"not encrypted", 1, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := ednsQuery()
+			req, _ := parseEdns(r, "192.0.2.10")
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Compress = false
+			for i := 0; i < test.answers; i++ {
+				rr, _ := dns.NewRR("go.hole. 60 IN A 10.0.0.1")
+				m.Answer = append(m.Answer, rr)
+			}
+			setEdns(req, m, "192.0.2.10", test.encrypted)
+
+			var padding *dns.EDNS0_PADDING
+			for _, o := range m.IsEdns0().Option {
+				if p, ok := o.(*dns.EDNS0_PADDING); ok {
+					padding = p
+				}
+			}
+			if (padding != nil) != test.encrypted {
+				t.Fatalf("padded: %t", padding != nil)
+			}
+			if !test.encrypted {
+				return
+			}
+			if m.Len()%paddingBlockSize != 0 {
+				t.Errorf("got size %d, want a multiple of %d", m.Len(), paddingBlockSize)
+			}
+			if len(padding.Padding) >= paddingBlockSize {
+				t.Errorf("got %d bytes of padding, more than a block", len(padding.Padding))
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package dnsserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"GoHole/config"
+)
+
+// listenAddresses expands the ListenAddresses of the config (or the
+// Interface if there are none) to the IPs to listen on. Each entry is an
+// IP, "*" for all the IPv4 and IPv6 addresses, or an interface name for
+// all its addresses.
+func listenAddresses() ([]string, error) {
+	entries := config.GetInstance().ListenAddresses
+	if len(entries) == 0 && config.GetInstance().Interface != "" {
+		entries = []string{config.GetInstance().Interface}
+	}
+	if len(entries) == 0 {
+		entries = []string{"*"}
+	}
+
+	var ips []string
+	seen := map[string]bool{}
+	add := func(ip string) {
+		if !seen[ip] {
+			seen[ip] = true
+			ips = append(ips, ip)
+		}
+	}
+
+	for _, entry := range entries {
+		entry = strings.Trim(entry, "[]")
+		if entry == "*" {
+			add("0.0.0.0")
+			add("::")
+			continue
+		}
+		if net.ParseIP(entry) != nil {
+			add(entry)
+			continue
+		}
+
+		ief, err := net.InterfaceByName(entry)
+		if err != nil {
+			return nil, errors.New(entry + " is not an IP address or a network interface")
+		}
+		addrs, err := ief.Addrs()
+		if err != nil {
+			return nil, errors.New("interface " + entry + ": " + err.Error())
+		}
+		found := false
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipnet.IP.String()
+			if ipnet.IP.To4() == nil && ipnet.IP.IsLinkLocalUnicast() {
+				ip += "%" + ief.Name // link-local addresses need the zone
+			}
+			add(ip)
+			found = true
+		}
+		if !found {
+			return nil, errors.New("interface " + entry + " has no addresses")
+		}
+	}
+	return ips, nil
+}
+
+// ipFamily returns "4" or "6", the suffix of the network names for an IP
+func ipFamily(ip string) string {
+	if i := strings.Index(ip, "%"); i >= 0 {
+		ip = ip[0:i]
+	}
+	if net.ParseIP(ip).To4() != nil {
+		return "4"
+	}
+	return "6"
+}
+
+// startListeners starts the UDP, TCP and secure (if SecureDNSPort is
+// set) servers on every IP
+func startListeners(ips []string) error {
+	for _, ip := range ips {
+		err := listen(ip)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listen(ip string) error {
+	family := ipFamily(ip)
+	addr := net.JoinHostPort(ip, config.GetInstance().DNSPort)
+
+	pc, err := net.ListenPacket("udp"+family, addr)
+	if err != nil {
+		return fmt.Errorf("DNS Server on %s (udp): %s", addr, err)
+	}
+	// TCP is needed by the clients when the UDP answers are truncated
+	l, err := net.Listen("tcp"+family, addr)
+	if err != nil {
+		pc.Close()
+		return fmt.Errorf("DNS Server on %s (tcp): %s", addr, err)
+	}
+	for _, server := range []*dns.Server{{PacketConn: pc}, {Listener: l}} {
+		addServer(server)
+		go func(server *dns.Server) {
+			err := server.ActivateAndServe()
+			if err != nil {
+				log.Printf("DNS Server on %s failed: %s\n", addr, err)
+			}
+		}(server)
+	}
+	log.Printf("Starting DNS Server at %s (udp, tcp)\n", addr)
+
+	if config.GetInstance().SecureDNSPort == "" {
+		return nil
+	}
+	secureAddr := net.JoinHostPort(ip, config.GetInstance().SecureDNSPort)
+	spc, err := net.ListenPacket("udp"+family, secureAddr)
+	if err != nil {
+		return fmt.Errorf("DNS Secure Server on %s: %s", secureAddr, err)
+	}
+	addSecureConn(spc.(*net.UDPConn))
+	go serveSecure(spc.(*net.UDPConn))
+	log.Printf("Starting Secure DNS Server at %s\n", secureAddr)
+	return nil
+}
+
+// clientAddress returns the IP of a client address (IPv4 or IPv6),
+// without the zone of link-local IPv6 addresses (fe80::1%eth0) so it
+// matches the group clients, the pauses and the cookies
+func clientAddress(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	return host
+}
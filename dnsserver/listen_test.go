@@ -0,0 +1,25 @@
+package dnsserver
+
+import (
+	"net"
+	"testing"
+)
+
+func TestClientAddress(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		want string
+	}{
+		{&net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 5353}, "192.168.1.10"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5353}, "2001:db8::1"},
+		{&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 5353, Zone: "eth0"}, "fe80::1"},
+		{&net.IPAddr{IP: net.ParseIP("fe80::1"), Zone: "wlan0"}, "fe80::1"},
+	}
+	for _, test := range tests {
+		t.Run(test.addr.String(), func(t *testing.T) {
+			if got := clientAddress(test.addr); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
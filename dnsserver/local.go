@@ -0,0 +1,34 @@
+package dnsserver
+
+import (
+	"log"
+
+	"github.com/miekg/dns"
+
+	"GoHole/records"
+)
+
+// answerLocal answers a question from the local records, setting the AA
+// flag. It returns false if the name is not local.
+func answerLocal(q dns.Question, m *dns.Msg) bool {
+	local, ok := records.Lookup(q.Name, q.Qtype)
+	if !ok {
+		return false
+	}
+
+	m.Authoritative = true
+	m.Rcode = local.Rcode
+	m.Answer = append(m.Answer, local.Answer...)
+	m.Ns = append(m.Ns, local.Ns...)
+
+	// local CNAME pointing outside our records
+	if local.Target != "" {
+		r, err := exchange(local.Target, q.Qtype)
+		if r == nil {
+			log.Printf("*** error resolving %s for %s: %s\n", local.Target, q.Name, err)
+		} else if r.Rcode == dns.RcodeSuccess {
+			m.Answer = append(m.Answer, stripDNSSEC(r.Answer, q.Qtype)...)
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package dnsserver
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+
+	"GoHole/config"
+	"GoHole/dnscache"
+	"GoHole/logs"
+)
+
+// prefetch defaults
+const (
+	defaultPrefetchBefore  = 60 // seconds
+	defaultPrefetchMinHits = 5
+	prefetchInterval       = 10 * time.Second
+)
+
+// startPrefetchLoop refreshes the popular cache entries in the
+// background shortly before they expire, so clients keep hitting the cache
+func startPrefetchLoop() {
+	for {
+		time.Sleep(prefetchInterval)
+
+		// the settings are read each time so a reload changes them
+		conf := config.GetInstance()
+		if !conf.Prefetch {
+			continue
+		}
+		before := conf.PrefetchBefore
+		if before <= 0 {
+			before = defaultPrefetchBefore
+		}
+		minHits := conf.PrefetchMinHits
+		if minHits <= 0 {
+			minHits = defaultPrefetchMinHits
+		}
+		for _, c := range dnscache.PrefetchCandidates(time.Duration(before)*time.Second, minHits) {
+			prefetch(c)
+		}
+	}
+}
+
+// prefetch resolves a cached domain again and caches the new answer
+func prefetch(c dnscache.Candidate) {
+	qtype := dns.TypeA
+	if c.IPv6 {
+		qtype = dns.TypeAAAA
+	}
+	if refresh(c.Domain, qtype) {
+		go logs.AddPrefetchToGraphite()
+	}
+}
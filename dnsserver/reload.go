@@ -0,0 +1,166 @@
+package dnsserver
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"GoHole/blocking"
+	"GoHole/config"
+	"GoHole/dnscache"
+	"GoHole/dnssec"
+	"GoHole/encryption"
+	"GoHole/records"
+	"GoHole/recursor"
+	"GoHole/rewrite"
+)
+
+// interval at which the config file is checked for changes
+const configWatchInterval = 5 * time.Second
+
+// built holds everything the server builds from a config, so a config
+// is only applied when all of it could be built
+type built struct {
+	records   *records.Store
+	rules     *blocking.Rules
+	rewrites  rewrite.RuleSets
+	resolver  *recursor.Resolver
+	validator *dnssec.Validator
+}
+
+// build loads the local records, blocklists, rewrites, resolver and DNSSEC
+// validator of a config without using them yet
+func build(cfg *config.MyConfig) (*built, error) {
+	b := &built{}
+	var err error
+
+	// load local records and add go.hole domain to them :)
+	b.records, err = records.Build(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("local records: %s", err)
+	}
+	err = b.records.AddHost(serverDomain, cfg.ServerIP)
+	if err != nil {
+		log.Printf("Invalid ServerIP for %s: %s\n", serverDomain, err)
+	}
+
+	// load groups, schedules and their blocklists
+	b.rules, err = blocking.Build(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("blocklists: %s", err)
+	}
+	b.rewrites, err = rewrite.Build(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("rewrite rules: %s", err)
+	}
+	b.resolver, err = newResolver(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("resolver: %s", err)
+	}
+	b.validator, err = newValidator(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("DNSSEC validation: %s", err)
+	}
+	return b, nil
+}
+
+// apply swaps in a config and everything built from it
+func apply(cfg *config.MyConfig, b *built) {
+	config.Swap(cfg)
+	records.Swap(b.records)
+	blocking.Swap(b.rules)
+	rewrite.Swap(b.rewrites)
+	setUpstream(b.validator, b.resolver)
+	if b.resolver != nil {
+		log.Printf("Resolving recursively from the root servers\n")
+	}
+}
+
+// configure loads the local records, blocklists, rewrites, resolver and
+// DNSSEC validator from the current config
+func configure() error {
+	cfg := config.GetInstance()
+	b, err := build(cfg)
+	if err != nil {
+		return err
+	}
+	apply(cfg, b)
+	return nil
+}
+
+// listenersChanged reports whether the listeners must be restarted
+func listenersChanged(old, cfg *config.MyConfig) bool {
+	return old.Interface != cfg.Interface || old.DNSPort != cfg.DNSPort ||
+		old.SecureDNSPort != cfg.SecureDNSPort || !reflect.DeepEqual(old.ListenAddresses, cfg.ListenAddresses)
+}
+
+// reload reads the config file again and reconfigures the server. If
+// the new config can not be applied the previous one is kept.
+func reload() error {
+	cfg, err := config.Read()
+	if err != nil {
+		return err
+	}
+	b, err := build(cfg)
+	if err != nil {
+		return err
+	}
+	old := config.GetInstance()
+	apply(cfg, b)
+
+	dnscache.Configure()
+	if cfg.EncryptionKey != old.EncryptionKey {
+		encryption.ImportKeyFromFile(cfg.EncryptionKey)
+	}
+	if cfg.ControlSocket != old.ControlSocket || cfg.PidFile != old.PidFile || cfg.APIAddress != old.APIAddress ||
+		cfg.Dashboard != old.Dashboard || cfg.DashboardPort != old.DashboardPort {
+		log.Printf("ControlSocket, PidFile, APIAddress and Dashboard changes need a restart\n")
+	}
+
+	// queries already received are answered by the old listeners
+	if listenersChanged(old, cfg) {
+		ips, err := listenAddresses()
+		if err == nil {
+			stopListeners()
+			err = startListeners(ips)
+		}
+		if err != nil {
+			log.Printf("Failed to start %s, using the previous listen addresses\n", err)
+			c := *cfg
+			c.Interface, c.ListenAddresses = old.Interface, old.ListenAddresses
+			c.DNSPort, c.SecureDNSPort = old.DNSPort, old.SecureDNSPort
+			config.Swap(&c)
+			stopListeners()
+			ips, _ = listenAddresses()
+			startListeners(ips)
+		}
+	}
+	return nil
+}
+
+func reloadConfig() {
+	err := reload()
+	if err != nil {
+		log.Printf("Failed to reload config: %s\n", err)
+		return
+	}
+	log.Printf("Config reloaded\n")
+}
+
+// startConfigWatch reloads the config when the file is modified
+func startConfigWatch() {
+	if !config.GetInstance().ConfigWatch {
+		return
+	}
+	last, _ := config.ModTime()
+	for {
+		time.Sleep(configWatchInterval)
+		t, err := config.ModTime()
+		if err != nil || t.Equal(last) {
+			continue
+		}
+		last = t
+		reloadConfig()
+	}
+}
@@ -0,0 +1,162 @@
+package dnsserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"GoHole/config"
+	"GoHole/records"
+	"GoHole/rewrite"
+)
+
+var testDir string
+
+// testConfig returns a config file with the test files and some more fields
+func testConfig(fields string) string {
+	return `{
+		"ServerIP": "10.0.0.1",
+		"BlockedDomainsFile": "` + testDir + `/gohole.blocked",
+		"AllowedDomainsFile": "` + testDir + `/gohole.allowed",
+		"BlocklistsFile": "` + testDir + `/gohole.blocklists"` + fields + `
+	}`
+}
+
+func writeConfig(t *testing.T, fields string) {
+	path := filepath.Join(testDir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(testConfig(fields)), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMain(m *testing.M) {
+	var err error
+	testDir, err = ioutil.TempDir("", "gohole-dnsserver")
+	if err != nil {
+		panic(err)
+	}
+	path := filepath.Join(testDir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(testConfig("")), 0600); err != nil {
+		panic(err)
+	}
+	config.CreateInstance(path)
+	if err := configure(); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(testDir)
+	os.Exit(code)
+}
+
+// withConfig changes the config during a test
+func withConfig(t *testing.T, f func(c *config.MyConfig)) {
+	old := config.GetInstance()
+	c := *old
+	f(&c)
+	config.Swap(&c)
+	t.Cleanup(func() { config.Swap(old) })
+}
+
+func local(name string) bool {
+	a, ok := records.Lookup(name, dns.TypeA)
+	return ok && a.Rcode == dns.RcodeSuccess && len(a.Answer) > 0
+}
+
+func TestReload(t *testing.T) {
+	// the steps run in order, every one reloads the config of the previous
+	// one with fields, a failed reload must keep the previous config in full
+	tests := []struct {
+		name    string
+		fields  string
+		err     string
+		local   string // local names after the reload
+		remote  string // names that are not local
+		timeout int
+	}{
+		{"records added", `, "UpstreamTimeout": 1000, "LocalRecords": ["a.test. 60 IN A 192.0.2.1", "b.test. 60 IN A 192.0.2.2"]`,
+			"", "a.test b.test", "c.test", 1000},
+		{"invalid record", `, "UpstreamTimeout": 3000, "LocalRecords": ["c.test. 60 IN A 192.0.2.3", "d.test. IN A nope"]`,
+			"local records", "a.test b.test", "c.test", 1000},
+		{"invalid rewrite after valid records", `, "UpstreamTimeout": 3000, "LocalRecords": ["c.test. 60 IN A 192.0.2.3"], "SafeSearch": ["nope"]`,
+			"rewrite rules", "a.test b.test", "c.test", 1000},
+		{"unknown resolver", `, "UpstreamTimeout": 3000, "LocalRecords": ["c.test. 60 IN A 192.0.2.3"], "SafeSearch": ["google"], "ResolverMode": "nope"`,
+			"ResolverMode", "a.test b.test", "c.test", 1000},
+		{"records replaced", `, "UpstreamTimeout": 3000, "LocalRecords": ["c.test. 60 IN A 192.0.2.3"], "SafeSearch": ["google"]`,
+			"", "c.test", "a.test b.test", 3000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			old := config.GetInstance()
+			writeConfig(t, test.fields)
+			err := reload()
+			if test.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				if config.GetInstance() != old {
+					t.Error("the config was replaced")
+				}
+			}
+
+			for _, name := range append(strings.Fields(test.local), serverDomain) {
+				if !local(name) {
+					t.Errorf("%s is not a local record", name)
+				}
+			}
+			for _, name := range strings.Fields(test.remote) {
+				if local(name) {
+					t.Errorf("%s is a local record", name)
+				}
+			}
+			if got := config.GetInstance().UpstreamTimeout; got != test.timeout {
+				t.Errorf("got UpstreamTimeout %d, want %d", got, test.timeout)
+			}
+			// the safe search rules of the last step are only used once valid
+			safe := rewrite.Lookup("192.0.2.10", "www.google.com") != nil
+			if want := test.err == "" && strings.Contains(test.fields, `"google"`); safe != want {
+				t.Errorf("safe search enforced: %t, want %t", safe, want)
+			}
+		})
+	}
+}
+
+// the queries answered during a reload always see the records of a config
+func TestReloadConcurrent(t *testing.T) {
+	writeConfig(t, `, "LocalRecords": ["a.test. 60 IN A 192.0.2.1"]`)
+	if err := reload(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if !local(serverDomain) || !local("a.test") {
+				t.Error("a reload left the local records incomplete")
+				return
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if err := reload(); err != nil {
+			t.Error(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,49 @@
+package dnsserver
+
+import (
+	"github.com/miekg/dns"
+
+	"GoHole/records"
+	"GoHole/rewrite"
+)
+
+// TTL of the synthesized rewrite records
+const rewriteTTL = 300
+
+// rewriteAnswer answers a question with the rule IP, or with a CNAME to
+// the rule target followed by the target records
+func rewriteAnswer(q dns.Question, rule *rewrite.Rule) ([]dns.RR, error) {
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: rewriteTTL}
+
+	if rule.IP != nil {
+		// only answer the record type matching the IP family
+		ipv4 := rule.IP.To4()
+		if q.Qtype == dns.TypeA && ipv4 != nil {
+			return []dns.RR{&dns.A{Hdr: hdr, A: ipv4}}, nil
+		}
+		if q.Qtype == dns.TypeAAAA && ipv4 == nil {
+			return []dns.RR{&dns.AAAA{Hdr: hdr, AAAA: rule.IP}}, nil
+		}
+		return nil, nil
+	}
+
+	hdr.Rrtype = dns.TypeCNAME
+	answer := []dns.RR{&dns.CNAME{Hdr: hdr, Target: dns.Fqdn(rule.Target)}}
+	if q.Qtype == dns.TypeCNAME {
+		return answer, nil
+	}
+
+	// the target can be a local name, e.g. printer -> printer.lan
+	if local, ok := records.Lookup(rule.Target, q.Qtype); ok {
+		return append(answer, local.Answer...), nil
+	}
+
+	r, err := exchange(rule.Target, q.Qtype)
+	if r == nil {
+		return answer, err
+	}
+	if r.Rcode == dns.RcodeSuccess {
+		answer = append(answer, stripDNSSEC(r.Answer, q.Qtype)...)
+	}
+	return answer, nil
+}
@@ -9,91 +9,232 @@ import (
 
     "github.com/miekg/dns"
 
-    "GoHole/config"
+    "GoHole/api"
+    "GoHole/blocking"
+    "GoHole/dashboard"
+    "GoHole/control"
     "GoHole/dnscache"
+    "GoHole/dnssec"
     "GoHole/logs"
     "GoHole/encryption"
+    "GoHole/rewrite"
 )
 
-func parseQuery(clientIp string, m *dns.Msg) {
-	for _, q := range m.Question {
-		var err error = nil
-		var ip = ""
-		cleanedName := q.Name[0:len(q.Name)-1] // remove the end "."
-		qType := "A"
-		isCached := false
-		isBlocked := false
-		isIpv4 := true
-
-		if q.Qtype == dns.TypeA{
+// domain that resolves to the GoHole server itself
+const serverDomain = "go.hole"
+
+// parseQuery answers the question of a query, from the local records,
+// the blocklists, the rewrites, the cache or upstream
+func parseQuery(clientIp string, edns *ednsRequest, m *dns.Msg) {
+	var err error = nil
+	var ip = ""
+	q := m.Question[0]
+	cleanedName := strings.TrimSuffix(q.Name, ".") // remove the end "."
+	qType := "A"
+	isCached := false
+	isBlocked := false
+	isRewritten := false
+	unblocked := false // blocked in cache, but resolved upstream
+	isIpv4 := q.Qtype != dns.TypeAAAA
+	d := Diagnosis{}
+
+	// Add logs, whatever way the query was answered
+	defer func(){
+		logs.AddQuery(clientIp, cleanedName, isCached, isRewritten, isBlocked, time.Now())
+		go logs.AddQueryToGraphite(isBlocked, isIpv4, isCached, isRewritten)
+		publishQuery(clientIp, q, d)
+	}()
+
+	// local records are answered authoritatively, before any blocking
+	if answerLocal(q, m){
+		isCached = true
+		d.Decision = "local"
+		log.Printf("Query for %s from %s, local record", q.Name, clientIp)
+		return
+	}
+
+	// blocklists enforced for the client's groups/schedules take
+	// precedence over the cache
+	now := time.Now()
+	paused := blocking.IsPaused(clientIp, now)
+	allowed := blocking.IsAllowed(cleanedName)
+	decision := blocking.Decision{}
+	d.Paused, d.Allowed = paused, allowed
+	if !paused && !allowed{
+		decision = blocking.Check(clientIp, cleanedName, now)
+	}
+
+	if q.Qtype == dns.TypeA{
+		if decision.Blocked{
+			ip, isBlocked = blocking.BlockedIPv4, true
+		}else{
 			ip, isBlocked, err = dnscache.GetDomainIPv4(cleanedName)
-		}else if q.Qtype == dns.TypeAAAA{
+		}
+	}else if q.Qtype == dns.TypeAAAA{
+		if decision.Blocked{
+			ip, isBlocked = blocking.BlockedIPv6, true
+		}else{
 			ip, isBlocked, err = dnscache.GetDomainIPv6(cleanedName)
-			qType = "AAAA"
-			isIpv4 = false
+		}
+		qType = "AAAA"
+	}
+
+	if (paused || allowed) && isBlocked{
+		// blocking is paused or the domain is allowed, resolve the blocked domain upstream
+		ip, isBlocked = "", false
+		unblocked = true
+	}
+
+	// rewrites and safe search, checked before the cache
+	// because they depend on the client
+	var rule *rewrite.Rule = nil
+	if !decision.Blocked{
+		rule = rewrite.Lookup(clientIp, cleanedName)
+	}
+
+	if rule != nil{
+		answer, err := rewriteAnswer(q, rule)
+		if err != nil{
+			log.Printf("*** error resolving %s for rewrite of %s: %s\n", rule.Target, q.Name, err)
+		}
+		m.Answer = append(m.Answer, answer...)
+	}else if decision.Blocked && ip == "" {
+		// blocked domain queried for another record type, answer without records
+		isBlocked = true
+	}else if ip != "" && err == nil {
+		rr, err := dns.NewRR(fmt.Sprintf("%s %s %s", q.Name, qType, ip))
+		if err == nil {
+			m.Answer = append(m.Answer, rr)
+		}
+		isCached = true
+	}else{
+		// Request to a DNS server
+		r, upstream, err := exchangeCoalesced(q, edns.upstreamSubnet())
+		d.Upstream = upstream
+		if r == nil || r.Rcode == dns.RcodeServerFailure {
+			// upstream unreachable, answer with the expired entry if we still have it
+			if answer, ok := staleAnswer(q, cleanedName); ok {
+				m.Answer = append(m.Answer, answer...)
+				isCached = true
+				d.Decision = "stale"
+				log.Printf("Query for %s from %s, upstream failed, served stale", q.Name, clientIp)
+				return
+			}
+		}
+		if r == nil {
+			log.Printf("*** error: %s\n", err.Error())
+			m.Rcode = dns.RcodeServerFailure
+			d.Decision = "failed"
+			return
 		}
 
-		if ip != "" && err == nil {
-			rr, err := dns.NewRR(fmt.Sprintf("%s %s %s", q.Name, qType, ip))
-			if err == nil {
-				m.Answer = append(m.Answer, rr)
+		result := dnssec.Insecure
+		if v := getValidator(); v != nil{
+			result = v.Validate(q.Name, q.Qtype, r)
+			if result == dnssec.Bogus{
+				log.Printf(" *** bogus DNSSEC answer for %s\n", q.Name)
+				m.Rcode = dns.RcodeServerFailure
+				d.Decision = "failed"
+				return
 			}
-			isCached = true
-		}else{
-			// Request to a DNS server
-			c := new(dns.Client)
-			msg := new(dns.Msg)
-			msg.SetQuestion(dns.Fqdn(q.Name), q.Qtype)
-			msg.RecursionDesired = true
-
-		    r, _, err := c.Exchange(msg, net.JoinHostPort(config.GetInstance().UpstreamDNSServer, "53"))
-		    if r == nil {
-		    	log.Printf("*** error: %s\n", err.Error())
-		    	return
-		    }
-
-		    if r.Rcode != dns.RcodeSuccess {
-		    	log.Printf(" *** invalid answer name %s after %s query for %s\n", q.Name, qType, q.Name)
-		    	return
-		    }
-		    // Parse Answer
-		    for _, a := range r.Answer {
-		    	ans := strings.Split(a.String(), "\t")
-		    	if len(ans) == 5 && ans[3] == qType{
-		    		// Save on cache
-		    		if q.Qtype == dns.TypeA{
-		    			dnscache.AddDomainIPv4(cleanedName, ans[4], true)
-					}else if q.Qtype == dns.TypeAAAA{
-						dnscache.AddDomainIPv6(cleanedName, ans[4], true)
-					}
-		    	}
-		    }
-		    // Set answer for the client
-		    m.Answer = r.Answer
-		    isCached = false
 		}
 
-		// Add logs
-		logs.AddQuery(clientIp, cleanedName, isCached, time.Now())
-		go logs.AddQueryToGraphite(isBlocked, isIpv4, isCached)
+		// Set answer for the client, as upstream sent it
+		setUpstreamReply(q, r, result, m)
+		edns.scope = upstreamScope(r)
+		if r.Rcode != dns.RcodeSuccess {
+			log.Printf("Query for %s from %s, upstream answered %s", q.Name, clientIp, dns.RcodeToString[r.Rcode])
+		}else if !unblocked && edns.scope == 0{
+			// Parse Answer and save on cache (the blocked entry is kept
+			// for when the pause ends or the domain is no longer allowed).
+			// Answers scoped to the client subnet are not saved, the cache
+			// is shared by all the clients.
+			cacheAnswer(cleanedName, q.Qtype, r)
+		}
+		isCached = false
+	}
+
+	isRewritten = rule != nil
+	d.Decision = "forwarded"
+	if isRewritten{
+		d.Decision = "rewritten"
+		d.Rewrite = rule.Target + " (" + rule.Set + ")"
+	}else if isBlocked{
+		d.Decision = "blocked"
+		d.List, d.Group, d.Schedule = decision.List, decision.Group, decision.Schedule
+		if d.List == ""{
+			// blocked with gohole block add or a blacklist
+			d.List, d.TTL = "blocked domains", -1
+		}
+	}else if isCached{
+		d.Decision = "cached"
+		if _, expires, found := dnscache.PeekDomain(cleanedName, !isIpv4); found{
+			d.TTL = int(expires.Sub(now).Seconds())
+		}
+	}
 
-		log.Printf("Query for %s from %s, blocked : %s, cached : %s", q.Name, clientIp, isBlocked, isCached)
+	if rule != nil{
+		log.Printf("Query for %s from %s, rewritten to %s (%s)", q.Name, clientIp, rule.Target, rule.Set)
+	}else if decision.Blocked{
+		log.Printf("Query for %s from %s, blocked by list %s (group: %s, schedule: %s)", q.Name, clientIp, decision.List, decision.Group, decision.Schedule)
+	}else{
+		log.Printf("Query for %s from %s, blocked : %t, cached : %t", q.Name, clientIp, isBlocked, isCached)
 	}
 }
 
-func handleDnsRequest(w dns.ResponseWriter, r *dns.Msg) {
+// publishQuery sends an answered query to the live log (gohole log tail)
+// and records how it was answered for gohole query
+func publishQuery(clientIp string, q dns.Question, d Diagnosis) {
+	recordOutcome(clientIp, q.Name, q.Qtype, d)
+	logs.Publish(logs.QueryEvent{
+		Time:     time.Now(),
+		ClientIp: clientIp,
+		Domain:   strings.TrimSuffix(q.Name, "."),
+		Type:     dns.TypeToString[q.Qtype],
+		Status:   d.Decision,
+		List:     d.List,
+	})
+}
+
+// buildReply answers a request, for the plain and the secure servers.
+// UDP answers are truncated to the client buffer size.
+func buildReply(clientIp string, r *dns.Msg, isUdp, encrypted bool) *dns.Msg {
 	m := new(dns.Msg)
 	m.SetReply(r)
 	m.Compress = false
-	clientIp := w.RemoteAddr().String()
-	clientIp = clientIp[0:strings.LastIndex(clientIp, ":")] // remove port
+	m.RecursionAvailable = true
+
+	edns, rcode := parseEdns(r, clientIp)
+	if rcode != dns.RcodeSuccess {
+		m.Rcode = rcode
+	}else if r.Opcode != dns.OpcodeQuery {
+		m.Rcode = dns.RcodeNotImplemented
+	}else if len(r.Question) != 1 {
+		// like real resolvers, only messages with one question are answered
+		m.Rcode = dns.RcodeFormatError
+	}else if isUdp && edns.badCookie() {
+		m.Rcode = dns.RcodeBadCookie
+	}else if r.Question[0].Qclass != dns.ClassINET {
+		// only the Internet class is resolved (and asked upstream)
+		m.Rcode = dns.RcodeNotImplemented
+	}else{
+		parseQuery(clientIp, edns, m)
+	}
 
-	switch r.Opcode {
-	case dns.OpcodeQuery:
-		parseQuery(clientIp, m)
+	// padding hides the size of the encrypted answers
+	setEdns(edns, m, clientIp, encrypted)
+	if isUdp {
+		// answers bigger than the client buffer are truncated, the client retries over TCP
+		m.Truncate(edns.udpSize())
 	}
+	return m
+}
+
+func handleDnsRequest(w dns.ResponseWriter, r *dns.Msg) {
+	clientIp := clientAddress(w.RemoteAddr())
+	isUdp := w.RemoteAddr().Network() == "udp"
 
-	w.WriteMsg(m)
+	w.WriteMsg(buildReply(clientIp, r, isUdp, false))
 }
 
 func handleSecureDnsRequest(conn *net.UDPConn, buf []byte, addr net.UDPAddr){
@@ -102,11 +243,14 @@ func handleSecureDnsRequest(conn *net.UDPConn, buf []byte, addr net.UDPAddr){
     	return
     }
 
-    m := new(dns.Msg)
-    m.Unpack(query)
-    clientIp := addr.String()
-    clientIp = clientIp[0:strings.LastIndex(clientIp, ":")] // remove port
-    parseQuery(clientIp, m)
+    r := new(dns.Msg)
+    err = r.Unpack(query)
+    if err != nil{
+    	return
+    }
+    clientIp := clientAddress(&addr)
+
+    m := buildReply(clientIp, r, false, true)
 
     reply, err := m.Pack()
     if err != nil{
@@ -120,67 +264,82 @@ func handleSecureDnsRequest(conn *net.UDPConn, buf []byte, addr net.UDPAddr){
     conn.WriteToUDP(eReply, &addr)
 }
 
-func listenAndServeSecure(){
-	serverAddr, err := net.ResolveUDPAddr("udp",":"+ config.GetInstance().SecureDNSPort)
-	if err != nil {
-		log.Fatalf("Failed to start DNS Secure Server: %s\n", err)
-	}
-	conn, err := net.ListenUDP("udp", serverAddr)
-	if err != nil {
-		log.Fatalf("Failed to start DNS Secure Server: %s\n", err)
-	}
-	defer conn.Close()
-
-	log.Printf("Starting Secure DNS Server at %s\n", config.GetInstance().SecureDNSPort)
-
+// serveSecure reads the encrypted queries received on conn
+func serveSecure(conn *net.UDPConn){
 	//simple read
 	for{
 		buf := make([]byte, 2048)
 		n, addr, err := conn.ReadFromUDP(buf)
         if err != nil {
+            if !isListening(conn){
+                return
+            }
             continue
         }
 
-        go handleSecureDnsRequest(conn, buf[:n], *addr)
+        secureQueries.Add(1)
+        go func(){
+            defer secureQueries.Done()
+            handleSecureDnsRequest(conn, buf[:n], *addr)
+        }()
 	}
 }
 
 func ListenAndServe(){
 
-	// add go.hole domain to our cache :)
-	dnscache.AddDomainIPv4("go.hole", config.GetInstance().ServerIP, false)
+	// a second server would take over the control socket of the first
+	if control.Running(){
+		log.Fatalf("Another GoHole server is running, run \"gohole stop\" first\n")
+	}
+
+	// load the local records, blocklists, rewrites and resolver
+	err := configure()
+	if err != nil {
+		log.Fatalf("Failed to load %s\n", err)
+	}
 
 	// start the graphite statistics loop
 	go logs.StartStatsLoop()
 
-	dns.HandleFunc(".", handleDnsRequest)
-	// Start DNS server
-	port := config.GetInstance().DNSPort
-
+	// warm the cache with the domains resolved by the last run
+	restoreCacheSnapshot()
+	go startSnapshotLoop()
 
-	ief, err := net.InterfaceByName(config.GetInstance().Interface)
-	if err !=nil{
-		log.Fatal(err)
-	}
-	addrs, err := ief.Addrs()
-	if err !=nil{
-		log.Fatal(err)
+	// block again the domains added with the CLI or the API
+	n, err := dnscache.LoadBlocked()
+	if err != nil {
+		log.Printf("Failed to load blocked domains from %s: %s\n", dnscache.BlockedFile(), err)
+	} else if n > 0 {
+		log.Printf("Loaded %d blocked domains from %s\n", n, dnscache.BlockedFile())
 	}
 
-	externalIpAddr := addrs[0].String()[:strings.Index(addrs[0].String(), "/")]
-	fmt.Println("Using interface", externalIpAddr)
-	server := &dns.Server{Addr: externalIpAddr + ":" + port, Net: "udp"}
+	// refresh popular cache entries before they expire
+	go startPrefetchLoop()
 
-	//server := &dns.Server{Addr: ":" + port, Net: "udp"}
+	// start the control socket used by the CLI
+	registerControlHandlers()
+	go control.ListenAndServe()
+	go api.ListenAndServe()
+	go dashboard.ListenAndServe()
 
+	// reload the config when the file changes
+	go startConfigWatch()
 
-	log.Printf("Starting at %s\n", port)
-	go listenAndServeSecure()
+	dns.HandleFunc(".", handleDnsRequest)
+	ips, err := listenAddresses()
+	if err != nil {
+		log.Fatalf("Invalid listen addresses: %s\n", err)
+	}
 
-	err = server.ListenAndServe()
-	defer server.Shutdown()
+	err = startListeners(ips)
 	if err != nil {
-		log.Fatalf("Failed to start DNS Server: %s\n ", err.Error())
+		log.Fatalf("Failed to start %s\n", err)
 	}
 
-}
\ No newline at end of file
+	// stop gracefully on SIGINT/SIGTERM (gohole stop)
+	writePidFile()
+	go handleSignals()
+
+	// wait until the server is shut down and everything is saved
+	<-stopped
+}
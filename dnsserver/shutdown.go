@@ -0,0 +1,187 @@
+package dnsserver
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"GoHole/api"
+	"GoHole/config"
+	"GoHole/dashboard"
+	"GoHole/control"
+	"GoHole/logs"
+)
+
+// max time to wait for the queries in flight when stopping
+const shutdownTimeout = 5 * time.Second
+
+// the listeners closed on shutdown (or when they change on reload)
+var servers []*dns.Server
+var secureConns []*net.UDPConn
+var serversLock sync.Mutex
+
+// secure queries being answered
+var secureQueries sync.WaitGroup
+
+var stopped = make(chan struct{})
+
+func pidFile() string {
+	path := config.GetInstance().PidFile
+	if path == "" {
+		path = os.TempDir() + "/gohole.pid"
+	}
+	return path
+}
+
+// the PID file stays open and locked while the server runs, so Stop can
+// tell it from a stale file left by a crash
+var pidLock *os.File = nil
+
+func writePidFile() {
+	file, err := os.OpenFile(pidFile(), os.O_RDWR|os.O_CREATE, 0644)
+	if err == nil {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == syscall.EWOULDBLOCK {
+			err = errors.New("locked by another running server")
+		}
+	}
+	if err == nil {
+		err = file.Truncate(0)
+	}
+	if err == nil {
+		_, err = file.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+	}
+	if err != nil {
+		log.Printf("Failed to write PID file %s: %s\n", pidFile(), err)
+		if file != nil {
+			file.Close()
+		}
+		return
+	}
+	pidLock = file
+}
+
+// Stop asks the running server (the PID in the PID file) to shut down.
+// The PID is only signaled if the server holds the lock of the file,
+// otherwise the file is stale and it is removed.
+func Stop() error {
+	file, err := os.Open(pidFile())
+	if err != nil {
+		return errors.New("server not running: " + err.Error())
+	}
+	defer file.Close()
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		os.Remove(pidFile())
+		return errors.New("server not running, removed stale PID file " + pidFile())
+	}
+	if err != syscall.EWOULDBLOCK {
+		return errors.New("PID file " + pidFile() + ": " + err.Error())
+	}
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return errors.New("invalid PID file " + pidFile())
+	}
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
+
+func addServer(server *dns.Server) {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+	servers = append(servers, server)
+}
+
+func addSecureConn(conn *net.UDPConn) {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+	secureConns = append(secureConns, conn)
+}
+
+// isListening reports whether a secure conn has not been closed
+func isListening(conn *net.UDPConn) bool {
+	serversLock.Lock()
+	defer serversLock.Unlock()
+	for _, c := range secureConns {
+		if c == conn {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSignals reloads the config on SIGHUP and shuts the server
+// down on SIGINT/SIGTERM
+func handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			reloadConfig()
+			continue
+		}
+		shutdown()
+		return
+	}
+}
+
+// stopListeners closes all the listeners, waiting for the queries in flight
+func stopListeners() {
+	serversLock.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		server.ShutdownContext(ctx)
+	}
+	conns := secureConns
+	servers, secureConns = nil, nil
+	serversLock.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	secureQueries.Wait()
+}
+
+// shutdown stops the listeners, waiting for the queries in flight, and
+// then saves the state: cache snapshot, blocked domains, last stats and
+// logs DB
+func shutdown() {
+	log.Printf("Shutting down\n")
+	control.Close()
+	api.Close()
+	dashboard.Close()
+	stopListeners()
+
+	if config.GetInstance().CacheSnapshot != "" {
+		saveCacheSnapshot()
+	}
+	saveBlocked()
+	logs.SendStats()
+	err := logs.Close()
+	if err != nil {
+		log.Printf("Failed to close logs DB: %s\n", err)
+	}
+	if pidLock != nil {
+		os.Remove(pidFile())
+		pidLock.Close()
+	}
+
+	close(stopped)
+}
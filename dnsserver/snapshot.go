@@ -0,0 +1,56 @@
+package dnsserver
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"GoHole/config"
+	"GoHole/dnscache"
+)
+
+// default interval at which the cache snapshot is saved
+const defaultCacheSnapshotInterval = 300 // seconds
+
+// restoreCacheSnapshot warms the cache with the snapshot saved by the
+// last run, if enabled
+func restoreCacheSnapshot() {
+	path := config.GetInstance().CacheSnapshot
+	if path == "" {
+		return
+	}
+	n, err := dnscache.LoadSnapshot(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to restore cache snapshot %s: %s\n", path, err)
+		}
+		return
+	}
+	log.Printf("Restored %d domains from cache snapshot %s\n", n, path)
+}
+
+func saveCacheSnapshot() {
+	path := config.GetInstance().CacheSnapshot
+	n, err := dnscache.SaveSnapshot(path)
+	if err != nil {
+		log.Printf("Failed to save cache snapshot %s: %s\n", path, err)
+		return
+	}
+	log.Printf("Saved %d domains to cache snapshot %s\n", n, path)
+}
+
+// startSnapshotLoop saves the cache snapshot periodically, it is also
+// saved when the server is shut down
+func startSnapshotLoop() {
+	for {
+		// the settings are read each time so a reload changes them
+		interval := config.GetInstance().CacheSnapshotInterval
+		if interval <= 0 {
+			interval = defaultCacheSnapshotInterval
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+		if config.GetInstance().CacheSnapshot != "" {
+			saveCacheSnapshot()
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package dnsserver
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"GoHole/config"
+	"GoHole/dnscache"
+)
+
+// serve-stale defaults (RFC 8767)
+const (
+	defaultStaleTTL    = 30 // seconds
+	staleRetryInterval = 30 * time.Second
+)
+
+// last time a stale domain was refreshed, so an unreachable upstream
+// is not asked again for every query
+var staleRefreshes = map[string]time.Time{}
+var staleRefreshesLock sync.Mutex
+
+// staleAnswer answers a question with an expired cache entry when the
+// upstream servers fail, and refreshes it in the background
+func staleAnswer(q dns.Question, domain string) ([]dns.RR, bool) {
+	if !config.GetInstance().ServeStale {
+		return nil, false
+	}
+
+	var ip string
+	var err error
+	switch q.Qtype {
+	case dns.TypeA:
+		ip, err = dnscache.GetStaleDomainIPv4(domain)
+	case dns.TypeAAAA:
+		ip, err = dnscache.GetStaleDomainIPv6(domain)
+	default:
+		return nil, false
+	}
+	if err != nil {
+		return nil, false
+	}
+
+	ttl := config.GetInstance().StaleTTL
+	if ttl <= 0 {
+		ttl = defaultStaleTTL
+	}
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d %s %s", q.Name, ttl, dns.TypeToString[q.Qtype], ip))
+	if err != nil {
+		return nil, false
+	}
+
+	go refreshStale(domain, q.Qtype)
+	return []dns.RR{rr}, true
+}
+
+// refreshStale resolves a stale domain again, at most once every
+// staleRetryInterval, caching it again once upstream recovers
+func refreshStale(domain string, qtype uint16) {
+	key := dns.TypeToString[qtype] + ":" + domain
+	now := time.Now()
+
+	staleRefreshesLock.Lock()
+	for k, t := range staleRefreshes {
+		if now.Sub(t) > staleRetryInterval {
+			delete(staleRefreshes, k)
+		}
+	}
+	if _, ok := staleRefreshes[key]; ok {
+		staleRefreshesLock.Unlock()
+		return
+	}
+	staleRefreshes[key] = now
+	staleRefreshesLock.Unlock()
+
+	if refresh(domain, qtype) {
+		log.Printf("Upstream recovered, %s refreshed\n", domain)
+	}
+}
@@ -0,0 +1,225 @@
+package dnsserver
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"GoHole/config"
+	"GoHole/dnscache"
+	"GoHole/dnssec"
+	"GoHole/recursor"
+)
+
+// validator of upstream answers, nil when DNSSEC is disabled
+var validator *dnssec.Validator = nil
+
+// resolver used instead of the upstream server in recursive mode
+var resolver *recursor.Resolver = nil
+
+// guards the swap of validator and resolver on reload
+var upstreamLock sync.RWMutex
+
+func getValidator() *dnssec.Validator {
+	upstreamLock.RLock()
+	defer upstreamLock.RUnlock()
+	return validator
+}
+
+func getResolver() *recursor.Resolver {
+	upstreamLock.RLock()
+	defer upstreamLock.RUnlock()
+	return resolver
+}
+
+// exchange sends a query to the upstream DNS server (or resolves it
+// recursively), asking for the DNSSEC records when validating
+func exchange(name string, qtype uint16) (*dns.Msg, error) {
+	r, _, err := exchangeWithSubnet(name, qtype, nil)
+	return r, err
+}
+
+// default time to wait for an upstream server
+const defaultUpstreamTimeout = 2000 // ms
+
+// exchangeWithSubnet sends a query upstream with the client subnet
+// (if not nil), retrying over TCP when the answer is truncated. The
+// upstream servers are tried in order until one answers without failing,
+// the one that answered is also returned.
+func exchangeWithSubnet(name string, qtype uint16, ecs *dns.EDNS0_SUBNET) (*dns.Msg, string, error) {
+	if r := getResolver(); r != nil {
+		// authoritative servers never get the client subnet
+		m, err := r.Resolve(name, qtype)
+		return m, "recursive resolver", err
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.RecursionDesired = true
+	msg.SetEdns0(uint16(ednsBufferSize()), getValidator() != nil)
+	if ecs != nil {
+		opt := msg.IsEdns0()
+		opt.Option = append(opt.Option, ecs)
+	}
+
+	var r *dns.Msg = nil
+	var err error = errors.New("no upstream DNS servers")
+	for _, server := range upstreamServers() {
+		c := &dns.Client{Timeout: upstreamTimeout()}
+		r, _, err = c.Exchange(msg, server)
+		if err == nil && r.Truncated {
+			c.Net = "tcp"
+			r, _, err = c.Exchange(msg, server)
+		}
+		if err == nil && r.Rcode != dns.RcodeServerFailure && r.Rcode != dns.RcodeRefused {
+			return r, server, nil
+		}
+		if err != nil {
+			log.Printf("Upstream DNS server %s failed: %s\n", server, err)
+		}
+	}
+	return r, "", err
+}
+
+// upstreamServers returns the addresses of UpstreamDNSServer and the
+// fallback UpstreamDNSServers ("ip" or "ip:port")
+func upstreamServers() []string {
+	cfg := config.GetInstance()
+	var servers []string
+	for _, s := range append([]string{cfg.UpstreamDNSServer}, cfg.UpstreamDNSServers...) {
+		if s == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, "53")
+		}
+		servers = append(servers, s)
+	}
+	return servers
+}
+
+func upstreamTimeout() time.Duration {
+	return timeoutOf(config.GetInstance())
+}
+
+func timeoutOf(cfg *config.MyConfig) time.Duration {
+	timeout := cfg.UpstreamTimeout
+	if timeout <= 0 {
+		timeout = defaultUpstreamTimeout
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+// refresh resolves a domain again in the background and caches the new
+// answer, it reports whether the cache was updated
+func refresh(domain string, qtype uint16) bool {
+	r, err := exchange(domain, qtype)
+	if err != nil {
+		log.Printf("Failed to refresh %s: %s\n", domain, err)
+		return false
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return false
+	}
+	if v := getValidator(); v != nil && v.Validate(dns.Fqdn(domain), qtype, r) == dnssec.Bogus {
+		log.Printf("Not refreshing %s: DNSSEC validation failed\n", domain)
+		return false
+	}
+
+	cacheAnswer(domain, qtype, r)
+	return true
+}
+
+// cacheAnswer saves the A/AAAA records of an upstream answer on cache
+func cacheAnswer(name string, qtype uint16, r *dns.Msg) {
+	qType := dns.TypeToString[qtype]
+	for _, a := range r.Answer {
+		ans := strings.Split(a.String(), "\t")
+		if len(ans) == 5 && ans[3] == qType {
+			if qtype == dns.TypeA {
+				dnscache.AddDomainIPv4(name, ans[4], true)
+			} else if qtype == dns.TypeAAAA {
+				dnscache.AddDomainIPv6(name, ans[4], true)
+			}
+		}
+	}
+}
+
+// stripDNSSEC removes the DNSSEC records we asked for to validate,
+// keeping the ones of the queried type
+func stripDNSSEC(rrs []dns.RR, qtype uint16) []dns.RR {
+	if getValidator() == nil {
+		return rrs
+	}
+	var stripped []dns.RR
+	for _, rr := range rrs {
+		switch rr.Header().Rrtype {
+		case qtype:
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeOPT:
+			continue
+		}
+		stripped = append(stripped, rr)
+	}
+	return stripped
+}
+
+// newResolver returns the resolver of a config in recursive mode, nil
+// when forwarding the queries upstream
+func newResolver(cfg *config.MyConfig) (*recursor.Resolver, error) {
+	switch cfg.ResolverMode {
+	case "", "forward":
+		return nil, nil
+	case "recursive":
+		r, err := recursor.NewResolver(cfg.RootHints, cfg.RecursorPort)
+		if err != nil {
+			return nil, err
+		}
+		r.DNSSEC = cfg.DNSSEC
+		r.Timeout = timeoutOf(cfg)
+		return r, nil
+	}
+	return nil, errors.New("unknown resolver mode " + cfg.ResolverMode)
+}
+
+// newValidator returns the DNSSEC validator of a config, nil when DNSSEC
+// is disabled
+func newValidator(cfg *config.MyConfig) (*dnssec.Validator, error) {
+	if !cfg.DNSSEC {
+		return nil, nil
+	}
+	return dnssec.NewValidator(exchange, cfg.DNSSECTrustAnchors)
+}
+
+// setUpstream swaps the validator and the resolver, the queries in
+// flight keep using the ones they started with
+func setUpstream(v *dnssec.Validator, r *recursor.Resolver) {
+	upstreamLock.Lock()
+	defer upstreamLock.Unlock()
+	validator, resolver = v, r
+}
+
+// setUpstreamReply copies an upstream answer to the reply: its rcode,
+// the answer, authority (e.g. the SOA of negative answers) and additional
+// sections, and the AD flag (our validation result when validating)
+func setUpstreamReply(q dns.Question, r *dns.Msg, result dnssec.Result, m *dns.Msg) {
+	m.Rcode = r.Rcode
+	m.Answer = append(m.Answer, stripDNSSEC(r.Answer, q.Qtype)...)
+	m.Ns = append(m.Ns, stripDNSSEC(r.Ns, q.Qtype)...)
+	for _, rr := range stripDNSSEC(r.Extra, q.Qtype) {
+		// our own OPT record is added for the client
+		if rr.Header().Rrtype != dns.TypeOPT {
+			m.Extra = append(m.Extra, rr)
+		}
+	}
+
+	if getValidator() != nil {
+		m.AuthenticatedData = result == dnssec.Secure
+	} else {
+		m.AuthenticatedData = r.AuthenticatedData
+	}
+}
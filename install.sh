@@ -1,6 +1,7 @@
 #!bin/sh
 go get github.com/miekg/dns
-go get github.com/patrickmn/go-cache
 go get github.com/asdine/storm
 go get github.com/olekukonko/tablewriter
 go get github.com/marpaia/graphite-golang
+go get gopkg.in/yaml.v3
+go get github.com/BurntSushi/toml
@@ -1,12 +1,15 @@
 package logs
 
 import (
+	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
     "github.com/marpaia/graphite-golang"
 
     "GoHole/config"
+    "GoHole/dnscache"
 )
 
 type Statistics struct {
@@ -17,9 +20,14 @@ type Statistics struct {
     NonCached int
     Ipv4 int
     Ipv6 int
+    Rewritten int
+    Prefetched int
+    Coalesced int
 }
 
 var statsInstance *Statistics = nil
+var statsLock sync.Mutex // the stats are updated by the queries concurrently
+var totalStats Statistics // stats already sent to Graphite
 
 func getGraphiteInstance() *graphite.Graphite {
 	host := config.GetInstance().Graphite.Host
@@ -44,16 +52,25 @@ func getStatsInstance() *Statistics {
 			NonCached:0,
 			Ipv4:0,
 			Ipv6:0,
+			Rewritten:0,
+			Prefetched:0,
+			Coalesced:0,
 		}
 	}
 
     return statsInstance
 }
 
-func AddQueryToGraphite(isBlocked, isIpv4, isCached bool){
+func AddQueryToGraphite(isBlocked, isIpv4, isCached, isRewritten bool){
+	statsLock.Lock()
+	defer statsLock.Unlock()
 	stats := getStatsInstance()
 	stats.Total += 1
-	// add query to blocked/non-blocked query metric
+	addToHistory(isBlocked, time.Now())
+	// add query to blocked/non-blocked/rewritten query metric
+	if isRewritten {
+		stats.Rewritten += 1
+	}
 	if isBlocked {
 		stats.Blocked += 1
 	}else{
@@ -75,6 +92,23 @@ func AddQueryToGraphite(isBlocked, isIpv4, isCached bool){
 	}
 }
 
+// AddPrefetchToGraphite counts a cache entry refreshed before it expired
+func AddPrefetchToGraphite(){
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	stats := getStatsInstance()
+	stats.Prefetched += 1
+}
+
+// AddCoalescedToGraphite counts a query that shared the upstream
+// exchange of an identical query in progress
+func AddCoalescedToGraphite(){
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	stats := getStatsInstance()
+	stats.Coalesced += 1
+}
+
 func resetStats(){
 	stats := getStatsInstance()
 	stats.Total = 0
@@ -84,17 +118,46 @@ func resetStats(){
 	stats.NonCached = 0
 	stats.Ipv4 = 0
 	stats.Ipv6 = 0
+	stats.Rewritten = 0
+	stats.Prefetched = 0
+	stats.Coalesced = 0
+}
+
+func (s *Statistics) add(o Statistics){
+	s.Total += o.Total
+	s.Blocked += o.Blocked
+	s.NonBlocked += o.NonBlocked
+	s.Cached += o.Cached
+	s.NonCached += o.NonCached
+	s.Ipv4 += o.Ipv4
+	s.Ipv6 += o.Ipv6
+	s.Rewritten += o.Rewritten
+	s.Prefetched += o.Prefetched
+	s.Coalesced += o.Coalesced
+}
+
+// GetStats returns the query counters since the server started
+func GetStats() Statistics {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	stats := totalStats
+	stats.add(*getStatsInstance())
+	return stats
 }
 
 func sendQueriesToGraphite(){
 	// The user should configure the graph to "summarize" (sum)
 	// the metrics in order to see better graphs :)
 
-	stats := getStatsInstance()
 	Graphite := getGraphiteInstance()
 	if Graphite == nil{
 		return
 	}
+	statsLock.Lock()
+	stats := *getStatsInstance()
+	totalStats.add(stats)
+	resetStats()
+	statsLock.Unlock()
 
 	// add query to total query metric
 	Graphite.SimpleSend("gohole.queries.total", strconv.Itoa(stats.Total))
@@ -102,6 +165,7 @@ func sendQueriesToGraphite(){
 	// add query to blocked/non-blocked query metric
 	Graphite.SimpleSend("gohole.queries.blocked", strconv.Itoa(stats.Blocked))
 	Graphite.SimpleSend("gohole.queries.nonblocked", strconv.Itoa(stats.NonBlocked))
+	Graphite.SimpleSend("gohole.queries.rewritten", strconv.Itoa(stats.Rewritten))
 
 	// add query to ipv4/ipv6 query metrics
 	Graphite.SimpleSend("gohole.queries.ipv4", strconv.Itoa(stats.Ipv4))
@@ -110,11 +174,28 @@ func sendQueriesToGraphite(){
 	// add query to cached/non-cached query metric
 	Graphite.SimpleSend("gohole.queries.cached", strconv.Itoa(stats.Cached))
 	Graphite.SimpleSend("gohole.queries.noncached", strconv.Itoa(stats.NonCached))
+	Graphite.SimpleSend("gohole.queries.coalesced", strconv.Itoa(stats.Coalesced))
+
+	// add cache entries refreshed by prefetching
+	Graphite.SimpleSend("gohole.cache.prefetched", strconv.Itoa(stats.Prefetched))
+
+	// cache occupancy and efficiency
+	cacheStats := dnscache.GetStats()
+	Graphite.SimpleSend("gohole.cache.entries", strconv.Itoa(cacheStats.Entries))
+	Graphite.SimpleSend("gohole.cache.bytes", strconv.Itoa(cacheStats.Bytes))
+	Graphite.SimpleSend("gohole.cache.pinned", strconv.Itoa(cacheStats.Pinned))
+	Graphite.SimpleSend("gohole.cache.evictions", strconv.FormatUint(cacheStats.Evictions, 10))
+	Graphite.SimpleSend("gohole.cache.hitratio", fmt.Sprintf("%.3f", cacheStats.HitRatio))
 
-	resetStats()
 	Graphite.Disconnect()
 }
 
+// SendStats sends the stats collected since the last time they were sent,
+// so they are not lost when the server stops
+func SendStats(){
+	sendQueriesToGraphite()
+}
+
 func StartStatsLoop(){
 	// loop in which every 30s we send the stats to Graphite
 	for{
@@ -0,0 +1,56 @@
+package logs
+
+import (
+	"time"
+)
+
+// the queries of the last 24 hours are counted in 10 minute slots
+const (
+	historyInterval = 10 * time.Minute
+	historySize     = 144
+)
+
+// HistoryPoint is the number of queries received in a slot starting at Time
+type HistoryPoint struct {
+	Time    time.Time
+	Total   int
+	Blocked int
+}
+
+// ring of slots, guarded by statsLock
+var history [historySize]HistoryPoint
+
+func historySlot(t time.Time) (int, time.Time) {
+	start := t.Truncate(historyInterval)
+	return int(start.Unix()/int64(historyInterval/time.Second)) % historySize, start
+}
+
+func addToHistory(isBlocked bool, t time.Time) {
+	i, start := historySlot(t)
+	if !history[i].Time.Equal(start) {
+		history[i] = HistoryPoint{Time: start} // slot of the previous day
+	}
+	history[i].Total += 1
+	if isBlocked {
+		history[i].Blocked += 1
+	}
+}
+
+// GetHistory returns the queries received in the last 24 hours in slots
+// of 10 minutes, oldest first
+func GetHistory() []HistoryPoint {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+
+	points := make([]HistoryPoint, 0, historySize)
+	_, now := historySlot(time.Now())
+	for n := historySize - 1; n >= 0; n-- {
+		i, start := historySlot(now.Add(-time.Duration(n) * historyInterval))
+		p := HistoryPoint{Time: start}
+		if history[i].Time.Equal(start) {
+			p = history[i]
+		}
+		points = append(points, p)
+	}
+	return points
+}
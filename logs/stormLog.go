@@ -5,6 +5,8 @@ import (
   "log"
 
   "github.com/asdine/storm"
+  "github.com/asdine/storm/q"
+  "regexp"
   "time"
 )
 
@@ -13,6 +15,8 @@ type QueryLog struct {
   ClientIp  string `storm:"index"`
   Domain    string `storm:"index"`
   Cached    bool
+  Rewritten bool
+  Blocked   bool
   Timestamp time.Time `storm:"index"`
 }
 
@@ -52,8 +56,18 @@ func GetInstance() *storm.DB {
   return instance
 }
 
-func AddQuery(clientIp string, domain string, cached bool, timestamp time.Time) (error) {
-  queryLog := QueryLog{ClientIp: clientIp, Domain: domain, Cached: cached, Timestamp: timestamp}
+// Close closes the logs DB, it is opened again on the next use
+func Close() (error) {
+  if instance == nil {
+    return nil
+  }
+  err := instance.Close()
+  instance = nil
+  return err
+}
+
+func AddQuery(clientIp string, domain string, cached, rewritten, blocked bool, timestamp time.Time) (error) {
+  queryLog := QueryLog{ClientIp: clientIp, Domain: domain, Cached: cached, Rewritten: rewritten, Blocked: blocked, Timestamp: timestamp}
   err := GetInstance().Save(&queryLog)
   if err != nil {
     return err
@@ -112,6 +126,40 @@ func GetQueriesByDomain(domain string) ([]QueryLog, error) {
   return queryLogs, err
 }
 
+// QueryFilter selects queries from the log, the empty fields match
+// every query
+type QueryFilter struct {
+  ClientIp string
+  Domain   string // part of the domain, e.g. "google"
+  Since    time.Time
+  Until    time.Time
+  Limit    int
+}
+
+// SearchQueries returns the latest queries matching the filter
+func SearchQueries(filter QueryFilter) ([]QueryLog, error) {
+  matchers := []q.Matcher{}
+  if filter.ClientIp != "" {
+    matchers = append(matchers, q.Eq("ClientIp", filter.ClientIp))
+  }
+  if filter.Domain != "" {
+    matchers = append(matchers, q.Re("Domain", regexp.QuoteMeta(filter.Domain)))
+  }
+  if !filter.Since.IsZero() {
+    matchers = append(matchers, q.Gte("Timestamp", filter.Since))
+  }
+  if !filter.Until.IsZero() {
+    matchers = append(matchers, q.Lte("Timestamp", filter.Until))
+  }
+
+  queryLogs := []QueryLog{}
+  err := GetInstance().Select(matchers...).OrderBy("Timestamp").Reverse().Limit(filter.Limit).Find(&queryLogs)
+  if err == storm.ErrNotFound {
+    return []QueryLog{}, nil
+  }
+  return queryLogs, err
+}
+
 func GetClients() ([]ClientLog, error) {
   var clientLogs [] ClientLog
   err := GetInstance().Select().OrderBy("Queries").Reverse().Find(&clientLogs)
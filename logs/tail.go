@@ -0,0 +1,110 @@
+package logs
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// QueryEvent is a query answered by the server, published as it is
+// answered to follow the log live (gohole log tail)
+type QueryEvent struct {
+	Time     time.Time
+	ClientIp string
+	Domain   string
+	Type     string // record type, e.g. AAAA
+	Status   string // local, blocked, rewritten, cached, forwarded, stale or failed
+	List     string // blocklist that blocked the domain
+}
+
+// TailFilter selects the events of a subscriber, the empty fields
+// match every query
+type TailFilter struct {
+	ClientIp string
+	Domain   string // part of the domain, or a pattern where * matches anything, e.g. *.google.com
+	Blocked  bool   // only the blocked queries
+	Type     string
+}
+
+type subscriber struct {
+	filter TailFilter
+	domain *regexp.Regexp
+	events chan QueryEvent
+}
+
+// events a subscriber can fall behind before the new ones are dropped
+const tailBuffer = 256
+
+var subscribers = map[*subscriber]bool{}
+var subscribersLock sync.RWMutex
+
+// Subscribe returns the queries matching the filter as they are answered,
+// until cancel is called. A subscriber that does not keep up misses events
+// instead of slowing down the server.
+func Subscribe(filter TailFilter) (<-chan QueryEvent, func(), error) {
+	s := &subscriber{filter: filter, events: make(chan QueryEvent, tailBuffer)}
+	if filter.Type != "" {
+		t, ok := dns.StringToType[strings.ToUpper(filter.Type)]
+		if !ok {
+			return nil, nil, errors.New("unknown record type " + filter.Type)
+		}
+		s.filter.Type = dns.TypeToString[t]
+	}
+	if filter.Domain != "" {
+		pattern := regexp.QuoteMeta(strings.ToLower(filter.Domain))
+		if strings.Contains(pattern, `\*`) {
+			pattern = "^" + strings.Replace(pattern, `\*`, ".*", -1) + "$"
+		}
+		s.domain = regexp.MustCompile(pattern)
+	}
+
+	subscribersLock.Lock()
+	subscribers[s] = true
+	subscribersLock.Unlock()
+
+	cancel := func() {
+		subscribersLock.Lock()
+		defer subscribersLock.Unlock()
+		if subscribers[s] {
+			delete(subscribers, s)
+			close(s.events)
+		}
+	}
+	return s.events, cancel, nil
+}
+
+// Publish sends a query to the subscribers it matches, it never blocks
+func Publish(e QueryEvent) {
+	subscribersLock.RLock()
+	defer subscribersLock.RUnlock()
+	for s := range subscribers {
+		if !s.matches(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			// the subscriber is too slow, drop the event
+		}
+	}
+}
+
+func (s *subscriber) matches(e QueryEvent) bool {
+	if s.filter.ClientIp != "" && s.filter.ClientIp != e.ClientIp {
+		return false
+	}
+	if s.filter.Blocked && e.Status != "blocked" {
+		return false
+	}
+	if s.filter.Type != "" && s.filter.Type != e.Type {
+		return false
+	}
+	if s.domain != nil && !s.domain.MatchString(strings.ToLower(e.Domain)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// list is the result of a listing command: the rows are printed as a
+// table or CSV, and data (the values the rows were made from) as JSON
+type list struct {
+	header []string
+	rows   [][]string
+	data   interface{}
+}
+
+func newList(data interface{}, header ...string) *list {
+	return &list{header: header, data: data}
+}
+
+func (l *list) add(row ...string) {
+	l.rows = append(l.rows, row)
+}
+
+// outputFlag adds the -output flag of the listing commands
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", "table", "Output format: table, json or csv")
+}
+
+func validOutput(format string) error {
+	switch format {
+	case "table", "json", "csv":
+		return nil
+	}
+	return fmt.Errorf("unknown output format %s, use table, json or csv", format)
+}
+
+// print writes the list to stdout in the given format
+func (l *list) print(format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(l.data)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write(l.header)
+		w.WriteAll(l.rows)
+		return w.Error()
+	case "table":
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader(l.header)
+		table.SetAutoWrapText(false)
+		table.AppendBulk(l.rows)
+		table.Render()
+		return nil
+	}
+	return validOutput(format)
+}
@@ -8,14 +8,36 @@ import (
     "io"
     "io/ioutil"
     "net/http"
+    "time"
 
     //"GoHole/config"
     "GoHole/dnscache"
 )
 
 func ParseBlacklistFile(path string) (error){
+    return parseHostsFile(path, func(ip, domain string){
+        fmt.Printf("\nDomain %s blocked with %s", domain, ip)
+
+        dnscache.AddDomainIPv4(domain, ip, false)
+        dnscache.AddDomainIPv6(domain, "::1", false) // by default ad lists doesn't include ipv6 block..
+    })
+}
+
+// ParseDomainsFile returns the domains listed in a blacklist file
+// without adding them to the cache
+func ParseDomainsFile(path string) ([]string, error){
+    var domains []string
+    err := parseHostsFile(path, func(ip, domain string){
+        domains = append(domains, domain)
+    })
+    return domains, err
+}
+
+// parseHostsFile reads a hosts file (or a file with one domain per line)
+// and calls add for every entry
+func parseHostsFile(path string, add func(ip, domain string)) (error){
     var err error = nil
-    if path[0:4] == "http"{
+    if strings.HasPrefix(path, "http"){
         // download file, parse and delete
         path, err = downloadFile(path)
         if err != nil{
@@ -48,14 +70,11 @@ func ParseBlacklistFile(path string) (error){
             }
             
             if parsedLine[1] != "localhost"{
-                // clean domain and save it on block list
+                // clean domain
                 parsedLine[1] = strings.Replace(parsedLine[1], " ", "", -1)
                 parsedLine[1] = strings.Replace(parsedLine[1], "\t", "", -1)
 
-                fmt.Printf("\nDomain %s blocked with %s", parsedLine[1], parsedLine[0])
-
-                dnscache.AddDomainIPv4(parsedLine[1], parsedLine[0], false)
-                dnscache.AddDomainIPv6(parsedLine[1], "::1", false) // by default ad lists doesn't include ipv6 block..
+                add(parsedLine[0], parsedLine[1])
             }
         }
     }
@@ -63,20 +82,34 @@ func ParseBlacklistFile(path string) (error){
     return nil
 }
 
-func downloadFile(url string) (string, error) {
-    // Create temporal file
-    tmpfile, err := ioutil.TempFile(os.TempDir(), "gohole")
+// time to download a blacklist, so a server that never answers does
+// not hang the (re)load of the blocklists
+const downloadTimeout = 60 * time.Second
 
+var httpClient = &http.Client{Timeout: downloadTimeout}
+
+func downloadFile(url string) (string, error) {
     // Get the data
-    resp, err := http.Get(url)
+    resp, err := httpClient.Get(url)
     if err != nil {
         return "", err
     }
     defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("download failed: %s", resp.Status)
+    }
+
+    // Create temporal file
+    tmpfile, err := ioutil.TempFile(os.TempDir(), "gohole")
+    if err != nil {
+        return "", err
+    }
+    defer tmpfile.Close()
 
     // Write the body to file
     _, err = io.Copy(tmpfile, resp.Body)
     if err != nil  {
+        os.Remove(tmpfile.Name())
         return "", err
     }
 
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"GoHole/config"
+	"GoHole/control"
+	"GoHole/dnsserver"
+	"GoHole/encryption"
+)
+
+// queryCommand resolves a name like dig and shows how GoHole answered it
+func queryCommand(fs *flag.FlagSet) func(args []string) error {
+	transport := fs.String("transport", "udp", "Transport: udp, tcp, dot (DNS over TLS), doh (DNS over HTTPS) or aes (the secure port)")
+	server := fs.String("server", "", "Server address, or URL for doh (default this GoHole server)")
+	client := fs.String("client", "", "Client IP the server sees the query from (default the address the query is sent from)")
+	timeout := fs.Duration("timeout", 5*time.Second, "Time to wait for the answer")
+	return func(args []string) error {
+		qtype := dns.TypeA
+		if len(args) > 1 {
+			t, ok := dns.StringToType[strings.ToUpper(args[1])]
+			if !ok {
+				return fmt.Errorf("unknown record type %s", args[1])
+			}
+			qtype = t
+		}
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(args[0]), qtype)
+		m.RecursionDesired = true
+
+		addr := *server
+		if addr == "" {
+			var err error
+			addr, err = localServer(*transport)
+			if err != nil {
+				return err
+			}
+		}
+
+		start := time.Now()
+		r, err := exchangeQuery(m, *transport, addr, *timeout)
+		if err != nil {
+			return err
+		}
+		rtt := time.Since(start)
+
+		fmt.Println(r.String())
+		fmt.Printf(";; Query time: %d msec\n", rtt.Milliseconds())
+		fmt.Printf(";; SERVER: %s (%s)\n", addr, *transport)
+		fmt.Printf(";; WHEN: %s\n", start.Format(time.RFC1123))
+		fmt.Printf(";; MSG SIZE rcvd: %d\n", r.Len())
+
+		if *server != "" || !control.Running() {
+			return nil
+		}
+		// the server records how it answered the query
+		from := *client
+		if from == "" {
+			from = sourceIP(addr)
+		}
+		var d dnsserver.Diagnosis
+		err = control.Call("diagnose", map[string]string{"client": from, "name": m.Question[0].Name, "type": dns.TypeToString[qtype]}, &d)
+		if err != nil {
+			return err
+		}
+		printDiagnosis(d)
+		return nil
+	}
+}
+
+func printDiagnosis(d dnsserver.Diagnosis) {
+	fmt.Printf("\n;; GOHOLE DECISION: %s\n", d.Decision)
+	switch d.Decision {
+	case "local":
+		fmt.Println(";; Answered from the local records")
+	case "blocked":
+		fmt.Printf(";; Blocked by: %s", d.List)
+		if d.Group != "" {
+			fmt.Printf(", group %s", d.Group)
+		}
+		if d.Schedule != "" {
+			fmt.Printf(", schedule %s", d.Schedule)
+		}
+		fmt.Println()
+	case "rewritten":
+		fmt.Printf(";; Rewritten to: %s\n", d.Rewrite)
+	case "cached":
+		fmt.Printf(";; Cache hit, TTL %ds remaining\n", d.TTL)
+	case "forwarded":
+		upstream := d.Upstream
+		if upstream == "" {
+			upstream = "unknown"
+		}
+		fmt.Printf(";; Cache miss, answered by upstream %s\n", upstream)
+	case "stale":
+		fmt.Println(";; Upstream failed, answered with an expired cache entry")
+	case "failed":
+		fmt.Println(";; Upstream failed or the answer failed DNSSEC validation")
+	}
+	if d.Paused {
+		fmt.Println(";; Blocking is paused for this client")
+	}
+	if d.Allowed {
+		fmt.Println(";; The domain is in the allowlist")
+	}
+}
+
+// localServer returns the address of this GoHole server for a transport
+func localServer(transport string) (string, error) {
+	cfg := config.GetInstance()
+	host := cfg.ServerIP
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	switch transport {
+	case "udp", "tcp":
+		return net.JoinHostPort(host, cfg.DNSPort), nil
+	case "aes":
+		return net.JoinHostPort(host, cfg.SecureDNSPort), nil
+	case "dot", "doh":
+		return "", fmt.Errorf("GoHole does not serve %s, give the -server to query", transport)
+	}
+	return "", fmt.Errorf("unknown transport %s, use udp, tcp, dot, doh or aes", transport)
+}
+
+// sourceIP returns the IP the queries to addr are sent from, which is
+// the client IP the server sees
+func sourceIP(addr string) string {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+func exchangeQuery(m *dns.Msg, transport, addr string, timeout time.Duration) (*dns.Msg, error) {
+	switch transport {
+	case "udp", "tcp", "dot":
+		c := &dns.Client{Net: transport, Timeout: timeout}
+		if transport == "dot" {
+			c.Net = "tcp-tls"
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				addr = net.JoinHostPort(addr, "853")
+			}
+			host, _, _ := net.SplitHostPort(addr)
+			c.TLSConfig = &tls.Config{ServerName: host}
+		}
+		r, _, err := c.Exchange(m, addr)
+		return r, err
+	case "doh":
+		return exchangeHTTPS(m, addr, timeout)
+	case "aes":
+		return exchangeSecure(m, addr, timeout)
+	}
+	return nil, fmt.Errorf("unknown transport %s, use udp, tcp, dot, doh or aes", transport)
+}
+
+// exchangeHTTPS sends the query to a DNS over HTTPS server (RFC 8484)
+func exchangeHTTPS(m *dns.Msg, url string, timeout time.Duration) (*dns.Msg, error) {
+	if !strings.HasPrefix(url, "https://") {
+		url = "https://" + url + "/dns-query"
+	}
+	query, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	res, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", url, res.Status)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	return r, r.Unpack(body)
+}
+
+// exchangeSecure sends the query encrypted with EncryptionKey to the
+// secure port, as the GoHole CryptClient does
+func exchangeSecure(m *dns.Msg, addr string, timeout time.Duration) (*dns.Msg, error) {
+	encryption.CreateInstance()
+	_, err := encryption.ImportKeyFromFile(config.GetInstance().EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the encryption key: %s", err)
+	}
+
+	query, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := encryption.Encrypt(query)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	_, err = conn.Write(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := encryption.Decrypt(buf[:n])
+	if err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	err = r.Unpack(reply)
+	if err != nil {
+		return nil, errors.New("cannot decode the answer, is the encryption key right?")
+	}
+	return r, nil
+}
@@ -0,0 +1,276 @@
+package records
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"GoHole/config"
+)
+
+// TTL of the records loaded from hosts files
+const hostsTTL = 300
+
+// max number of local CNAMEs followed when answering
+const maxChain = 8
+
+// Answer is the authoritative answer for a local name
+type Answer struct {
+	Answer []dns.RR
+	Ns     []dns.RR
+	Rcode  int
+	Target string // CNAME target that is not local and must be resolved upstream
+}
+
+type zone struct {
+	origin string
+	soa    dns.RR
+}
+
+// Store holds the local records and zones
+type Store struct {
+	sync.RWMutex
+	rrs   map[string][]dns.RR
+	zones []*zone
+}
+
+var instance = newStore()
+var instanceLock sync.RWMutex // guards the swap of instance on reload
+
+func getStore() *Store {
+	instanceLock.RLock()
+	defer instanceLock.RUnlock()
+	return instance
+}
+
+func newStore() *Store {
+	return &Store{rrs: map[string][]dns.RR{}}
+}
+
+func key(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+// Build reads the local records, hosts files and zones of a config into
+// a new store, which is used once it is swapped in with Swap
+func Build(cfg *config.MyConfig) (*Store, error) {
+	s := newStore()
+
+	for _, path := range cfg.LocalHosts {
+		err := s.loadHostsFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("hosts file %s: %s", path, err)
+		}
+	}
+	for _, z := range cfg.LocalZones {
+		err := s.loadZoneFile(z.Origin, z.File)
+		if err != nil {
+			return nil, fmt.Errorf("zone %s: %s", z.Origin, err)
+		}
+	}
+	for _, record := range cfg.LocalRecords {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			return nil, fmt.Errorf("record %q: %s", record, err)
+		}
+		s.add(rr)
+	}
+
+	return s, nil
+}
+
+// Swap replaces the local records with a store made by Build
+func Swap(s *Store) {
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
+	instance = s
+}
+
+// Add adds a record to the local records
+func Add(rr dns.RR) {
+	s := getStore()
+	s.Lock()
+	defer s.Unlock()
+	s.add(rr)
+}
+
+// AddHost adds an A or AAAA record (depending on ip) for name
+func AddHost(name, ip string) error {
+	return getStore().AddHost(name, ip)
+}
+
+// AddHost adds an A or AAAA record (depending on ip) for name to the store
+func (s *Store) AddHost(name, ip string) error {
+	rr, err := hostRecord(name, ip)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.add(rr)
+	return nil
+}
+
+func hostRecord(name, ip string) (dns.RR, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("invalid IP %s", ip)
+	}
+	hdr := dns.RR_Header{Name: key(name), Class: dns.ClassINET, Ttl: hostsTTL}
+	if addr.To4() != nil {
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: addr.To4()}, nil
+	}
+	hdr.Rrtype = dns.TypeAAAA
+	return &dns.AAAA{Hdr: hdr, AAAA: addr}, nil
+}
+
+// add stores a record, generating the PTR record for A/AAAA records
+// unless the reverse name already has one
+func (s *Store) add(rr dns.RR) {
+	name := key(rr.Header().Name)
+	rr.Header().Name = name
+	if soa, ok := rr.(*dns.SOA); ok {
+		s.zones = append(s.zones, &zone{origin: name, soa: soa})
+	}
+	s.rrs[name] = append(s.rrs[name], rr)
+
+	var ip net.IP = nil
+	switch r := rr.(type) {
+	case *dns.A:
+		ip = r.A
+	case *dns.AAAA:
+		ip = r.AAAA
+	}
+	if ip != nil {
+		reverse, err := dns.ReverseAddr(ip.String())
+		if err == nil && len(s.find(reverse, dns.TypePTR)) == 0 {
+			s.rrs[reverse] = append(s.rrs[reverse], &dns.PTR{
+				Hdr: dns.RR_Header{Name: reverse, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: rr.Header().Ttl},
+				Ptr: name,
+			})
+		}
+	}
+}
+
+// loadHostsFile reads a hosts file, every line is an IP followed by one or more names
+func (s *Store) loadHostsFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[0:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, name := range fields[1:] {
+			rr, err := hostRecord(name, fields[0])
+			if err != nil {
+				return err
+			}
+			s.add(rr)
+		}
+	}
+	return scanner.Err()
+}
+
+// loadZoneFile reads a zone file in RFC 1035 format
+func (s *Store) loadZoneFile(origin, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	zp := dns.NewZoneParser(file, dns.Fqdn(origin), path)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		s.add(rr)
+	}
+	if err := zp.Err(); err != nil {
+		return err
+	}
+
+	// a zone without SOA is still authoritative for its names
+	if s.zoneFor(key(origin)) == nil {
+		s.zones = append(s.zones, &zone{origin: key(origin)})
+	}
+	return nil
+}
+
+func (s *Store) find(name string, qtype uint16) []dns.RR {
+	var rrs []dns.RR
+	for _, rr := range s.rrs[name] {
+		if qtype == dns.TypeANY || rr.Header().Rrtype == qtype {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+func (s *Store) zoneFor(name string) *zone {
+	var best *zone = nil
+	for _, z := range s.zones {
+		if dns.IsSubDomain(z.origin, name) && (best == nil || len(z.origin) > len(best.origin)) {
+			best = z
+		}
+	}
+	return best
+}
+
+// Lookup answers a question from the local records, it returns false
+// if the name is not local and must be resolved as usual
+func Lookup(name string, qtype uint16) (*Answer, bool) {
+	s := getStore()
+	s.RLock()
+	defer s.RUnlock()
+
+	name = key(name)
+	z := s.zoneFor(name)
+	if _, ok := s.rrs[name]; !ok && z == nil {
+		return nil, false
+	}
+
+	a := &Answer{Rcode: dns.RcodeSuccess}
+	for i := 0; i < maxChain; i++ {
+		if _, ok := s.rrs[name]; !ok {
+			if s.zoneFor(name) != nil {
+				a.Rcode = dns.RcodeNameError
+			} else {
+				// CNAME to a name outside our records
+				a.Target = name
+			}
+			break
+		}
+
+		rrs := s.find(name, qtype)
+		if len(rrs) > 0 {
+			a.Answer = append(a.Answer, rrs...)
+			break
+		}
+		cname := s.find(name, dns.TypeCNAME)
+		if len(cname) == 0 {
+			break
+		}
+		a.Answer = append(a.Answer, cname[0])
+		name = key(cname[0].(*dns.CNAME).Target)
+	}
+
+	// negative answers include the zone SOA
+	if len(a.Answer) == 0 && z != nil && z.soa != nil {
+		a.Ns = append(a.Ns, z.soa)
+	}
+	return a, true
+}
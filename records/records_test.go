@@ -0,0 +1,173 @@
+package records
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"GoHole/config"
+)
+
+const testZone = `$TTL 300
+@         IN SOA   ns.lan. admin.lan. 1 3600 600 86400 60
+nas       IN A     192.168.1.10
+www       IN CNAME nas
+a         IN CNAME b
+b         IN CNAME c
+c         IN CNAME nas
+ext       IN CNAME example.com.
+dangling  IN CNAME missing
+loop1     IN CNAME loop2
+loop2     IN CNAME loop1
+`
+
+const testHosts = `# hosts file
+192.168.1.30 tv tv.home  # two names
+`
+
+// testStore builds and swaps in the records of the tests
+func testStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohole-records")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	zone, hosts := filepath.Join(dir, "lan.zone"), filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(zone, []byte(testZone), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(hosts, []byte(testHosts), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Build(&config.MyConfig{
+		LocalZones:   []config.LocalZoneConfig{{Origin: "lan", File: zone}},
+		LocalHosts:   []string{hosts},
+		LocalRecords: []string{"printer.home. 60 IN A 192.168.1.20", "printer.home. 60 IN AAAA fd00::20"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddHost("go.hole", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	old := getStore()
+	Swap(s)
+	t.Cleanup(func() { Swap(old) })
+}
+
+// types returns the types of the records, e.g. "CNAME A"
+func types(rrs []dns.RR) string {
+	var list []string
+	for _, rr := range rrs {
+		list = append(list, dns.TypeToString[rr.Header().Rrtype])
+	}
+	return strings.Join(list, " ")
+}
+
+func TestLookup(t *testing.T) {
+	testStore(t)
+	loop := strings.TrimSpace(strings.Repeat("CNAME ", maxChain))
+	tests := []struct {
+		name   string
+		qtype  uint16
+		found  bool
+		rcode  int
+		answer string // types of the answer records
+		soa    bool   // SOA in the authority section
+		target string // CNAME target resolved upstream
+	}{
+		{"nas.lan", dns.TypeA, true, dns.RcodeSuccess, "A", false, ""},
+		{"NAS.Lan.", dns.TypeA, true, dns.RcodeSuccess, "A", false, ""},
+		{"lan", dns.TypeSOA, true, dns.RcodeSuccess, "SOA", false, ""},
+		{"www.lan", dns.TypeA, true, dns.RcodeSuccess, "CNAME A", false, ""},
+		{"www.lan", dns.TypeCNAME, true, dns.RcodeSuccess, "CNAME", false, ""},
+		{"a.lan", dns.TypeA, true, dns.RcodeSuccess, "CNAME CNAME CNAME A", false, ""},
+		{"ext.lan", dns.TypeA, true, dns.RcodeSuccess, "CNAME", false, "example.com."},
+		{"loop1.lan", dns.TypeA, true, dns.RcodeSuccess, loop, false, ""},
+		{"dangling.lan", dns.TypeA, true, dns.RcodeNameError, "CNAME", false, ""},
+		{"missing.lan", dns.TypeA, true, dns.RcodeNameError, "", true, ""},
+		{"deep.missing.lan", dns.TypeAAAA, true, dns.RcodeNameError, "", true, ""},
+		{"nas.lan", dns.TypeAAAA, true, dns.RcodeSuccess, "", true, ""},
+		{"printer.home", dns.TypeAAAA, true, dns.RcodeSuccess, "AAAA", false, ""},
+		{"printer.home", dns.TypeMX, true, dns.RcodeSuccess, "", false, ""},
+		{"tv.home", dns.TypeA, true, dns.RcodeSuccess, "A", false, ""},
+		{"tv", dns.TypeA, true, dns.RcodeSuccess, "A", false, ""},
+		{"go.hole", dns.TypeA, true, dns.RcodeSuccess, "A", false, ""},
+		{"other.home", dns.TypeA, false, 0, "", false, ""},
+		{"example.com", dns.TypeA, false, 0, "", false, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name+"/"+dns.TypeToString[test.qtype], func(t *testing.T) {
+			a, found := Lookup(test.name, test.qtype)
+			if found != test.found {
+				t.Fatalf("found %t, want %t", found, test.found)
+			}
+			if !found {
+				return
+			}
+			if a.Rcode != test.rcode || types(a.Answer) != test.answer || a.Target != test.target {
+				t.Errorf("got %s %q target %q, want %s %q target %q", dns.RcodeToString[a.Rcode], types(a.Answer), a.Target,
+					dns.RcodeToString[test.rcode], test.answer, test.target)
+			}
+			if soa := types(a.Ns) == "SOA"; soa != test.soa || (!soa && len(a.Ns) > 0) {
+				t.Errorf("got authority %q", types(a.Ns))
+			}
+		})
+	}
+}
+
+func TestPTR(t *testing.T) {
+	testStore(t)
+	tests := []struct {
+		ip   string
+		want string // empty if there is no PTR record
+	}{
+		{"192.168.1.10", "nas.lan."},
+		{"192.168.1.20", "printer.home."},
+		{"fd00::20", "printer.home."},
+		{"192.168.1.30", "tv."}, // the first name of the hosts line
+		{"10.0.0.1", "go.hole."},
+		{"192.168.1.99", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			reverse, err := dns.ReverseAddr(test.ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, found := Lookup(reverse, dns.TypePTR)
+			if test.want == "" {
+				if found {
+					t.Errorf("got %v", a.Answer)
+				}
+				return
+			}
+			if !found || len(a.Answer) != 1 || a.Answer[0].(*dns.PTR).Ptr != test.want {
+				t.Errorf("got %v, want a PTR to %s", a, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.MyConfig
+	}{
+		{"invalid record", config.MyConfig{LocalRecords: []string{"nas.lan. IN A nope"}}},
+		{"missing hosts file", config.MyConfig{LocalHosts: []string{"/nonexistent/hosts"}}},
+		{"missing zone file", config.MyConfig{LocalZones: []config.LocalZoneConfig{{Origin: "lan", File: "/nonexistent/lan.zone"}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Build(&test.cfg); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package recursor
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultRootHints are the IPv4 addresses of the root servers (a to m)
+var DefaultRootHints = []string{
+	"198.41.0.4", "170.247.170.2", "192.33.4.12", "199.7.91.13",
+	"192.203.230.10", "192.5.5.241", "192.112.36.4", "198.97.190.53",
+	"192.36.148.17", "192.58.128.30", "193.0.14.129", "199.7.83.42",
+	"202.12.27.33",
+}
+
+// max number of queries sent to resolve a name
+const maxSteps = 30
+
+// max nesting of CNAMEs and name server resolutions
+const maxDepth = 8
+
+// min and max time delegations are cached
+const (
+	minDelegationTTL = 60 * time.Second
+	maxDelegationTTL = 24 * time.Hour
+)
+
+type delegation struct {
+	servers []string // "ip:port"
+	expires time.Time
+}
+
+// Resolver resolves names iterating from the root servers, without
+// any upstream forwarder
+type Resolver struct {
+	roots    []string
+	Minimise bool // send only the labels each zone needs to know (QNAME minimisation, RFC 7816)
+	DNSSEC   bool // ask for the DNSSEC records (DO bit)
+	Timeout  time.Duration
+	Port     string // port of the name servers found in referrals
+
+	lock        sync.Mutex
+	delegations map[string]*delegation
+}
+
+// NewResolver creates a resolver for the root servers in hints
+// ("ip" or "ip:port", DefaultRootHints if empty), asking the name
+// servers on port (53 if empty)
+func NewResolver(hints []string, port string) (*Resolver, error) {
+	if len(hints) == 0 {
+		hints = DefaultRootHints
+	}
+	if port == "" {
+		port = "53"
+	}
+	r := &Resolver{Minimise: true, Timeout: 2 * time.Second, Port: port, delegations: map[string]*delegation{}}
+	for _, h := range hints {
+		addr, err := serverAddr(h, port)
+		if err != nil {
+			return nil, err
+		}
+		r.roots = append(r.roots, addr)
+	}
+	return r, nil
+}
+
+func serverAddr(s, port string) (string, error) {
+	if _, _, err := net.SplitHostPort(s); err == nil {
+		return s, nil
+	}
+	if net.ParseIP(s) == nil {
+		return "", errors.New("invalid root server address " + s)
+	}
+	return net.JoinHostPort(s, port), nil
+}
+
+func canonical(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+// Resolve resolves a question, returning the authoritative answer
+func (r *Resolver) Resolve(name string, qtype uint16) (*dns.Msg, error) {
+	return r.resolve(canonical(name), qtype, 0)
+}
+
+// closestDelegation returns the deepest known zone containing name and its servers
+func (r *Resolver) closestDelegation(name string) (string, []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	for zone := name; ; {
+		if d, ok := r.delegations[zone]; ok && d.expires.After(now) {
+			return zone, d.servers
+		}
+		i, end := dns.NextLabel(zone, 0)
+		if end {
+			break
+		}
+		zone = zone[i:]
+	}
+	return ".", r.roots
+}
+
+func (r *Resolver) cacheDelegation(zone string, servers []string, ttl time.Duration) {
+	if ttl < minDelegationTTL {
+		ttl = minDelegationTTL
+	}
+	if ttl > maxDelegationTTL {
+		ttl = maxDelegationTTL
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.delegations[zone] = &delegation{servers: servers, expires: time.Now().Add(ttl)}
+}
+
+// FlushDelegations empties the delegation cache
+func (r *Resolver) FlushDelegations() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.delegations = map[string]*delegation{}
+}
+
+// nextName returns name with one more label than zone
+func nextName(zone, name string) string {
+	labels := dns.SplitDomainName(name)
+	n := dns.CountLabel(zone) + 1
+	if n >= len(labels) {
+		return name
+	}
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+func (r *Resolver) resolve(name string, qtype uint16, depth int) (*dns.Msg, error) {
+	if depth > maxDepth {
+		return nil, errors.New("too many CNAMEs or name server lookups resolving " + name)
+	}
+
+	// the DS records are served by the parent zone
+	start := name
+	if qtype == dns.TypeDS && name != "." {
+		i, _ := dns.NextLabel(name, 0)
+		start = name[i:]
+	}
+	zone, servers := r.closestDelegation(start)
+	known := zone // deepest name we know exists, for QNAME minimisation
+
+	for step := 0; step < maxSteps; step++ {
+		qname, qt := name, qtype
+		if r.Minimise && known != name {
+			qname = nextName(known, name)
+			if qname != name {
+				qt = dns.TypeA
+			}
+		}
+
+		resp, err := r.query(servers, qname, qt)
+		if err != nil {
+			return nil, err
+		}
+
+		if referral, ok := r.referral(zone, qname, resp); ok {
+			if !dns.IsSubDomain(referral, name) {
+				return nil, errors.New("bad referral to " + referral + " resolving " + name)
+			}
+			servers, err = r.delegationServers(zone, referral, resp, depth)
+			if err != nil {
+				return nil, err
+			}
+			zone, known = referral, referral
+			continue
+		}
+
+		if qname != name {
+			// a minimised query was answered by the zone itself
+			if resp.Rcode == dns.RcodeNameError {
+				// nothing exists below a name that does not exist (RFC 8020)
+				resp.Question = []dns.Question{{Name: name, Qtype: qtype, Qclass: dns.ClassINET}}
+				return resp, nil
+			}
+			known = qname
+			continue
+		}
+
+		return r.answer(zone, name, qtype, resp, depth)
+	}
+
+	return nil, errors.New("too many steps resolving " + name)
+}
+
+// referral returns the delegated zone if resp is a referral to a zone
+// below the current one
+func (r *Resolver) referral(zone, qname string, resp *dns.Msg) (string, bool) {
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) > 0 || resp.Authoritative && !hasNS(resp) {
+		return "", false
+	}
+	for _, rr := range resp.Ns {
+		if ns, ok := rr.(*dns.NS); ok {
+			child := canonical(ns.Hdr.Name)
+			if child != zone && dns.IsSubDomain(zone, child) && dns.IsSubDomain(child, qname) {
+				return child, true
+			}
+		}
+	}
+	return "", false
+}
+
+func hasNS(resp *dns.Msg) bool {
+	for _, rr := range resp.Ns {
+		if rr.Header().Rrtype == dns.TypeNS {
+			return true
+		}
+	}
+	return false
+}
+
+// delegationServers returns the addresses of the name servers of the
+// delegated zone, from the glue records in the bailiwick of the parent
+// zone or resolving the name server names
+func (r *Resolver) delegationServers(parent, child string, resp *dns.Msg, depth int) ([]string, error) {
+	var names []string
+	ttl := maxDelegationTTL
+	for _, rr := range resp.Ns {
+		if ns, ok := rr.(*dns.NS); ok && canonical(ns.Hdr.Name) == child {
+			names = append(names, canonical(ns.Ns))
+			if t := time.Duration(ns.Hdr.Ttl) * time.Second; t < ttl {
+				ttl = t
+			}
+		}
+	}
+
+	var servers, ipv6 []string
+	for _, ns := range names {
+		if !dns.IsSubDomain(parent, ns) {
+			continue // out of bailiwick glue is never trusted
+		}
+		for _, rr := range resp.Extra {
+			if canonical(rr.Header().Name) != ns {
+				continue
+			}
+			switch a := rr.(type) {
+			case *dns.A:
+				servers = append(servers, net.JoinHostPort(a.A.String(), r.Port))
+			case *dns.AAAA:
+				ipv6 = append(ipv6, net.JoinHostPort(a.AAAA.String(), r.Port))
+			}
+		}
+	}
+
+	// without glue, resolve the name server names (until one works)
+	if len(servers) == 0 && len(ipv6) == 0 {
+		for _, ns := range names {
+			if dns.IsSubDomain(child, ns) {
+				continue // it would need glue
+			}
+			m, err := r.resolve(ns, dns.TypeA, depth+1)
+			if err != nil {
+				continue
+			}
+			for _, rr := range m.Answer {
+				if a, ok := rr.(*dns.A); ok {
+					servers = append(servers, net.JoinHostPort(a.A.String(), r.Port))
+				}
+			}
+			if len(servers) > 0 {
+				break
+			}
+		}
+	}
+
+	servers = append(servers, ipv6...)
+	if len(servers) == 0 {
+		return nil, errors.New("no reachable name servers for " + child)
+	}
+	r.cacheDelegation(child, servers, ttl)
+	return servers, nil
+}
+
+// answer builds the final answer, following CNAMEs to other zones
+func (r *Resolver) answer(zone, name string, qtype uint16, resp *dns.Msg, depth int) (*dns.Msg, error) {
+	// only records in the bailiwick of the zone are accepted
+	var answer []dns.RR
+	for _, rr := range resp.Answer {
+		if dns.IsSubDomain(zone, canonical(rr.Header().Name)) {
+			answer = append(answer, rr)
+		}
+	}
+	resp.Answer = answer
+
+	if qtype == dns.TypeCNAME || resp.Rcode != dns.RcodeSuccess {
+		return resp, nil
+	}
+
+	// follow the CNAME chain inside the answer
+	target := name
+	for i := 0; i < len(answer); i++ {
+		for _, rr := range answer {
+			if c, ok := rr.(*dns.CNAME); ok && canonical(c.Hdr.Name) == target {
+				target = canonical(c.Target)
+			}
+		}
+	}
+	if target == name {
+		return resp, nil
+	}
+	for _, rr := range answer {
+		if canonical(rr.Header().Name) == target && rr.Header().Rrtype == qtype {
+			return resp, nil
+		}
+	}
+
+	// the target is in another zone
+	m, err := r.resolve(target, qtype, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	m.Answer = append(answer, m.Answer...)
+	m.Question = resp.Question
+	return m, nil
+}
+
+// query asks the servers until one answers, retrying over TCP when
+// the answer is truncated
+func (r *Resolver) query(servers []string, name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.RecursionDesired = false
+	msg.SetEdns0(4096, r.DNSSEC)
+
+	var err error = errors.New("no servers to ask for " + name)
+	for _, server := range servers {
+		c := &dns.Client{Timeout: r.Timeout}
+		var resp *dns.Msg
+		resp, _, err = c.Exchange(msg, server)
+		if err == nil && resp.Truncated {
+			c.Net = "tcp"
+			resp, _, err = c.Exchange(msg, server)
+		}
+		if err != nil {
+			continue
+		}
+		if resp.Rcode == dns.RcodeServerFailure || resp.Rcode == dns.RcodeRefused {
+			err = errors.New(dns.RcodeToString[resp.Rcode] + " from " + server + " for " + name)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, err
+}
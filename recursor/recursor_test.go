@@ -0,0 +1,208 @@
+package recursor
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// authServer is a stand-in authoritative server for a zone
+type authServer struct {
+	zone        string
+	records     []dns.RR
+	delegations map[string][]dns.RR // zone: NS and glue records
+
+	lock    sync.Mutex
+	queries []string
+}
+
+func newRRs(t *testing.T, lines ...string) []dns.RR {
+	var rrs []dns.RR
+	for _, l := range lines {
+		rr, err := dns.NewRR(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+func (s *authServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	q := r.Question[0]
+	name := canonical(q.Name)
+	s.lock.Lock()
+	s.queries = append(s.queries, name+" "+dns.TypeToString[q.Qtype])
+	s.lock.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	for child, rrs := range s.delegations {
+		if dns.IsSubDomain(child, name) {
+			for _, rr := range rrs {
+				if rr.Header().Rrtype == dns.TypeNS {
+					m.Ns = append(m.Ns, rr)
+				} else {
+					m.Extra = append(m.Extra, rr)
+				}
+			}
+			w.WriteMsg(m)
+			return
+		}
+	}
+
+	m.Authoritative = true
+	exists := false
+	for _, rr := range s.records {
+		owner := canonical(rr.Header().Name)
+		if owner == name && (rr.Header().Rrtype == q.Qtype || rr.Header().Rrtype == dns.TypeCNAME) {
+			m.Answer = append(m.Answer, rr)
+		}
+		if dns.IsSubDomain(name, owner) {
+			exists = true
+		}
+	}
+	if len(m.Answer) == 0 && !exists && name != s.zone {
+		m.Rcode = dns.RcodeNameError
+	}
+	w.WriteMsg(m)
+}
+
+func (s *authServer) seen() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.queries...)
+}
+
+// startServers starts the servers on 127.0.0.1, 127.0.0.2... all on the
+// same high port, which is returned
+func startServers(t *testing.T, servers ...*authServer) string {
+	port := "0"
+	for i, s := range servers {
+		ip := fmt.Sprintf("127.0.0.%d", i+1)
+		conn, err := net.ListenPacket("udp", net.JoinHostPort(ip, port))
+		if err != nil {
+			t.Skipf("cannot listen on %s: %s", ip, err)
+		}
+		_, port, _ = net.SplitHostPort(conn.LocalAddr().String())
+
+		srv := &dns.Server{PacketConn: conn, Handler: s}
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+		go srv.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { srv.Shutdown() })
+	}
+	return port
+}
+
+// testServers are the root, the zone test. (127.0.0.2) which delegates
+// sub.test. to 127.0.0.3, and bad.test. with out of bailiwick glue
+func testServers(t *testing.T) (root, test, sub *authServer) {
+	root = &authServer{zone: ".", delegations: map[string][]dns.RR{
+		"test.": newRRs(t, "test. 3600 IN NS ns.test.", "ns.test. 3600 IN A 127.0.0.2"),
+	}}
+	test = &authServer{
+		zone: "test.",
+		records: newRRs(t,
+			"www.test. 300 IN A 192.0.2.1",
+			"alias.test. 300 IN CNAME host.sub.test.",
+		),
+		delegations: map[string][]dns.RR{
+			"sub.test.": newRRs(t, "sub.test. 3600 IN NS ns.sub.test.", "ns.sub.test. 3600 IN A 127.0.0.3"),
+			"bad.test.": newRRs(t, "bad.test. 3600 IN NS ns.other.", "ns.other. 3600 IN A 127.0.0.3"),
+		},
+	}
+	sub = &authServer{zone: "sub.test.", records: newRRs(t, "host.sub.test. 300 IN A 192.0.2.3")}
+	return root, test, sub
+}
+
+func newTestResolver(t *testing.T, port string) *Resolver {
+	r, err := NewResolver([]string{"127.0.0.1"}, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Timeout = time.Second
+	return r
+}
+
+func TestResolve(t *testing.T) {
+	root, test, sub := testServers(t)
+	port := startServers(t, root, test, sub)
+
+	tests := []struct {
+		name  string
+		qname string
+		want  string // address of the last A record, empty for errors
+		rcode int
+	}{
+		{"zone below the root", "www.test.", "192.0.2.1", dns.RcodeSuccess},
+		{"two delegations", "host.sub.test.", "192.0.2.3", dns.RcodeSuccess},
+		{"cname to another zone", "alias.test.", "192.0.2.3", dns.RcodeSuccess},
+		{"nxdomain", "nope.test.", "", dns.RcodeNameError},
+		{"out of bailiwick glue", "www.bad.test.", "", -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := newTestResolver(t, port).Resolve(test.qname, dns.TypeA)
+			if test.rcode < 0 {
+				if err == nil {
+					t.Fatalf("got %v, want an error", m)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Rcode != test.rcode {
+				t.Fatalf("got rcode %s, want %s", dns.RcodeToString[m.Rcode], dns.RcodeToString[test.rcode])
+			}
+			got := ""
+			for _, rr := range m.Answer {
+				if a, ok := rr.(*dns.A); ok {
+					got = a.A.String()
+				}
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveMinimise(t *testing.T) {
+	root, test, sub := testServers(t)
+	port := startServers(t, root, test, sub)
+	r := newTestResolver(t, port)
+
+	if _, err := r.Resolve("host.sub.test.", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	// the root only learns about test., and test. about sub.test.
+	if got := strings.Join(root.seen(), ","); got != "test. A" {
+		t.Errorf("root saw %s", got)
+	}
+	if got := strings.Join(test.seen(), ","); got != "sub.test. A" {
+		t.Errorf("test. saw %s", got)
+	}
+
+	// the delegations are cached
+	if _, err := r.Resolve("host.sub.test.", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	if len(root.seen()) != 1 || len(test.seen()) != 1 {
+		t.Errorf("delegations not cached, root saw %v, test. saw %v", root.seen(), test.seen())
+	}
+	r.FlushDelegations()
+	if _, err := r.Resolve("host.sub.test.", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	if len(root.seen()) != 2 {
+		t.Errorf("delegations not flushed, root saw %v", root.seen())
+	}
+}
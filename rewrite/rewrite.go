@@ -0,0 +1,154 @@
+package rewrite
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"GoHole/blocking"
+	"GoHole/config"
+)
+
+// Rule answers queries for Domain with IP, or with a CNAME to Target
+// when it is not an IP address
+type Rule struct {
+	Domain string // exact name, or "*.example.com" to match all its subdomains
+	Target string
+	IP     net.IP
+	Set    string // name of the rule set the rule comes from
+}
+
+// ruleSet is a group of rules enforced for some client groups
+type ruleSet struct {
+	groups    []string // empty means all clients
+	rules     map[string]*Rule
+	wildcards []*Rule
+}
+
+// RuleSets are the rewrite rules of a config
+type RuleSets []*ruleSet
+
+var instance RuleSets = nil
+var instanceLock sync.RWMutex // guards the swap of instance on reload
+
+func getInstance() RuleSets {
+	instanceLock.RLock()
+	defer instanceLock.RUnlock()
+	return instance
+}
+
+func newSafeSearchSet(names []string, groups []string) (*ruleSet, error) {
+	set := &ruleSet{groups: groups, rules: map[string]*Rule{}}
+	for _, name := range names {
+		rules, ok := safeSearchSets[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown safe search rule set %s", name)
+		}
+		for domain, target := range rules() {
+			set.rules[domain] = &Rule{Domain: domain, Target: target, Set: name}
+		}
+	}
+	return set, nil
+}
+
+func newRewriteSet(cfg config.RewriteConfig) (*ruleSet, error) {
+	domain := strings.ToLower(strings.TrimSuffix(cfg.Domain, "."))
+	if domain == "" || cfg.Target == "" {
+		return nil, errors.New("rewrite rules need a domain and a target")
+	}
+	if strings.Contains(strings.TrimPrefix(domain, "*."), "*") {
+		return nil, fmt.Errorf("rewrite %s: only leading wildcards (*.domain) are supported", cfg.Domain)
+	}
+
+	r := &Rule{Domain: domain, Target: strings.TrimSuffix(cfg.Target, "."), IP: net.ParseIP(cfg.Target), Set: "rewrite"}
+	set := &ruleSet{groups: cfg.Groups, rules: map[string]*Rule{}}
+	if strings.HasPrefix(domain, "*.") {
+		set.wildcards = append(set.wildcards, r)
+	} else {
+		set.rules[domain] = r
+	}
+	return set, nil
+}
+
+// Build builds the rewrite rules of a config, user rewrites are checked
+// before the safe search rule sets. They are used once swapped in with Swap.
+func Build(cfg *config.MyConfig) (RuleSets, error) {
+	sets := RuleSets{}
+
+	for _, rc := range cfg.Rewrites {
+		set, err := newRewriteSet(rc)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+
+	if len(cfg.SafeSearch) > 0 {
+		set, err := newSafeSearchSet(cfg.SafeSearch, nil)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	for _, g := range cfg.Groups {
+		if len(g.SafeSearch) > 0 {
+			set, err := newSafeSearchSet(g.SafeSearch, []string{g.Name})
+			if err != nil {
+				return nil, fmt.Errorf("group %s: %s", g.Name, err)
+			}
+			sets = append(sets, set)
+		}
+	}
+
+	return sets, nil
+}
+
+// Swap replaces the rewrite rules with the ones made by Build
+func Swap(sets RuleSets) {
+	instanceLock.Lock()
+	defer instanceLock.Unlock()
+	instance = sets
+}
+
+func (s *ruleSet) appliesTo(groups []*blocking.Group) bool {
+	if len(s.groups) == 0 {
+		return true
+	}
+	for _, name := range s.groups {
+		for _, g := range groups {
+			if g.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Lookup returns the rule that rewrites the domain for the client,
+// or nil if the domain must be resolved as usual
+func Lookup(clientIp, domain string) *Rule {
+	sets := getInstance()
+	if len(sets) == 0 {
+		return nil
+	}
+
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	groups := blocking.GetInstance().ClientGroups(clientIp)
+
+	// exact names take precedence over wildcards
+	for _, set := range sets {
+		if r, ok := set.rules[domain]; ok && set.appliesTo(groups) {
+			return r
+		}
+	}
+	for _, set := range sets {
+		for _, r := range set.wildcards {
+			if strings.HasSuffix(domain, r.Domain[1:]) && set.appliesTo(groups) {
+				return r
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package rewrite
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"GoHole/blocking"
+	"GoHole/config"
+)
+
+const (
+	kid   = "192.168.1.10"
+	teen  = "192.168.1.11"
+	adult = "192.168.1.20"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "gohole-rewrite")
+	if err != nil {
+		panic(err)
+	}
+	cfg := `{
+		"AllowedDomainsFile": "` + dir + `/gohole.allowed",
+		"BlocklistsFile": "` + dir + `/gohole.blocklists",
+		"Groups": [
+			{"Name": "kids", "Clients": ["` + kid + `"], "SafeSearch": ["google", "youtube"]},
+			{"Name": "teens", "Clients": ["` + teen + `"], "SafeSearch": ["youtube-moderate"]}
+		],
+		"Rewrites": [
+			{"Domain": "nas.example.com", "Target": "192.168.1.50"},
+			{"Domain": "games.example.com", "Target": "0.0.0.0", "Groups": ["kids"]},
+			{"Domain": "*.kids.example.com", "Target": "kids.example.net", "Groups": ["kids"]},
+			{"Domain": "*.example.com", "Target": "192.168.1.60"},
+			{"Domain": "www.bing.com", "Target": "10.0.0.5"}
+		],
+		"SafeSearch": ["bing"]
+	}`
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(cfg), 0600); err != nil {
+		panic(err)
+	}
+	config.CreateInstance(path)
+	if err := blocking.Load(); err != nil {
+		panic(err)
+	}
+	sets, err := Build(config.GetInstance())
+	if err != nil {
+		panic(err)
+	}
+	Swap(sets)
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		client string
+		domain string
+		target string // empty if not rewritten
+		set    string
+	}{
+		{"exact", adult, "nas.example.com", "192.168.1.50", "rewrite"},
+		{"exact in any case", adult, "NAS.Example.com.", "192.168.1.50", "rewrite"},
+		{"wildcard", adult, "printer.example.com", "192.168.1.60", "rewrite"},
+		{"wildcard, deeper", adult, "a.b.example.com", "192.168.1.60", "rewrite"},
+		{"wildcard, not the domain itself", adult, "example.com", "", ""},
+		{"other domain", adult, "example.org", "", ""},
+		{"exact before wildcard", kid, "games.example.com", "0.0.0.0", "rewrite"},
+		{"exact of another group", adult, "games.example.com", "192.168.1.60", "rewrite"},
+		{"group wildcard", kid, "x.kids.example.com", "kids.example.net", "rewrite"},
+		{"group wildcard of another group", teen, "x.kids.example.com", "192.168.1.60", "rewrite"},
+		{"rewrite before safe search", kid, "www.bing.com", "10.0.0.5", "rewrite"},
+		{"safe search for everybody", adult, "bing.com", "strict.bing.com", "bing"},
+		{"group safe search", kid, "www.google.com", "forcesafesearch.google.com", "google"},
+		{"group safe search, country domain", kid, "google.co.uk", "forcesafesearch.google.com", "google"},
+		{"group safe search, other group", teen, "www.google.com", "", ""},
+		{"no group safe search", adult, "www.google.com", "", ""},
+		{"strict youtube", kid, "www.youtube.com", "restrict.youtube.com", "youtube"},
+		{"moderate youtube", teen, "m.youtube.com", "restrictmoderate.youtube.com", "youtube-moderate"},
+		{"no youtube restriction", adult, "www.youtube.com", "", ""},
+		{"unknown client", "not an ip", "www.google.com", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := Lookup(test.client, test.domain)
+			if test.target == "" {
+				if r != nil {
+					t.Errorf("got %+v, want no rewrite", r)
+				}
+				return
+			}
+			if r == nil || r.Target != test.target || r.Set != test.set {
+				t.Fatalf("got %+v, want %s (%s)", r, test.target, test.set)
+			}
+			// IP targets are answered with A/AAAA records, the rest with a CNAME
+			if !r.IP.Equal(net.ParseIP(test.target)) {
+				t.Errorf("got IP %v for target %s", r.IP, r.Target)
+			}
+		})
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.MyConfig
+	}{
+		{"no domain", config.MyConfig{Rewrites: []config.RewriteConfig{{Target: "10.0.0.1"}}}},
+		{"no target", config.MyConfig{Rewrites: []config.RewriteConfig{{Domain: "a.example.com"}}}},
+		{"wildcard in the middle", config.MyConfig{Rewrites: []config.RewriteConfig{{Domain: "a.*.example.com", Target: "10.0.0.1"}}}},
+		{"unknown safe search", config.MyConfig{SafeSearch: []string{"altavista"}}},
+		{"unknown group safe search", config.MyConfig{Groups: []config.GroupConfig{{Name: "kids", SafeSearch: []string{"altavista"}}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Build(&test.cfg); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package rewrite
+
+// Built-in rule sets that force safe search, they are CNAME
+// rewrites supported by every vendor
+
+var googleDomains = []string{
+	"google.com", "google.ad", "google.ae", "google.com.ar", "google.at", "google.com.au",
+	"google.be", "google.bg", "google.com.br", "google.ca", "google.ch", "google.cl",
+	"google.co.in", "google.co.jp", "google.co.kr", "google.co.nz", "google.co.uk", "google.co.za",
+	"google.com.co", "google.cz", "google.de", "google.dk", "google.com.eg", "google.es",
+	"google.fi", "google.fr", "google.gr", "google.com.hk", "google.hr", "google.hu",
+	"google.ie", "google.co.id", "google.co.il", "google.it", "google.lu", "google.com.mx",
+	"google.com.my", "google.nl", "google.no", "google.com.pe", "google.com.ph", "google.pl",
+	"google.pt", "google.ro", "google.rs", "google.ru", "google.se", "google.com.sg",
+	"google.sk", "google.com.tr", "google.com.tw", "google.com.ua", "google.com.uy", "google.com.vn",
+}
+
+func newGoogleRules() map[string]string {
+	rules := map[string]string{}
+	for _, d := range googleDomains {
+		rules[d] = "forcesafesearch.google.com"
+		rules["www."+d] = "forcesafesearch.google.com"
+	}
+	return rules
+}
+
+func newYoutubeRules(target string) map[string]string {
+	return map[string]string{
+		"www.youtube.com":          target,
+		"m.youtube.com":            target,
+		"youtubei.googleapis.com":  target,
+		"youtube.googleapis.com":   target,
+		"www.youtube-nocookie.com": target,
+	}
+}
+
+// safeSearchSets returns the built-in rule sets by name
+// (domain -> CNAME target)
+var safeSearchSets = map[string]func() map[string]string{
+	"google": newGoogleRules,
+	"bing": func() map[string]string {
+		return map[string]string{
+			"bing.com":     "strict.bing.com",
+			"www.bing.com": "strict.bing.com",
+		}
+	},
+	"duckduckgo": func() map[string]string {
+		return map[string]string{
+			"duckduckgo.com":       "safe.duckduckgo.com",
+			"www.duckduckgo.com":   "safe.duckduckgo.com",
+			"start.duckduckgo.com": "safe.duckduckgo.com",
+		}
+	},
+	"youtube": func() map[string]string {
+		return newYoutubeRules("restrict.youtube.com")
+	},
+	"youtube-moderate": func() map[string]string {
+		return newYoutubeRules("restrictmoderate.youtube.com")
+	},
+}